@@ -0,0 +1,59 @@
+package tui
+
+import (
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// EditModel is a one-line editor prefilled with a command
+type EditModel struct {
+	Input     textinput.Model
+	Done      bool // Enter was pressed
+	Cancelled bool
+}
+
+// InitialEditModel opens the editor with the command and the cursor at its end
+func InitialEditModel(command string) EditModel {
+	input := textinput.New()
+	input.Prompt = "$ "
+	input.SetValue(command)
+	input.CursorEnd()
+	input.Focus()
+	return EditModel{Input: input}
+}
+
+// Value returns the edited command
+func (m EditModel) Value() string {
+	return m.Input.Value()
+}
+
+func (m EditModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m EditModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Input.Width = msg.Width - len(m.Input.Prompt) - 1
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyEnter:
+			m.Done = true
+			return m, tea.Quit
+		case tea.KeyEsc, tea.KeyCtrlC:
+			m.Cancelled = true
+			return m, tea.Quit
+		}
+	}
+
+	var cmd tea.Cmd
+	m.Input, cmd = m.Input.Update(msg)
+	return m, cmd
+}
+
+func (m EditModel) View() string {
+	if m.Done || m.Cancelled {
+		return ""
+	}
+	return titleStyle.Render("Edit the command") + subtleStyle.Render("  enter to run, esc to cancel") + "\n\n" + m.Input.View() + "\n"
+}
@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// maxArgColumn is the widest argument kept on the same line as its meaning
+const maxArgColumn = 24
+
+var (
+	argStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FF00"))
+	meaningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("250"))
+)
+
+// RenderExplanation annotates each step of an explained command with what
+// it does and what each of its arguments means, followed by the risk
+func RenderExplanation(exp *model.CommandExplanation, risk model.Risk) string {
+	var sb strings.Builder
+	sb.WriteString(titleStyle.Render("EXPLAIN"))
+	sb.WriteString(" " + exp.Summary + "\n")
+
+	op := ""
+	for _, step := range exp.Steps {
+		sb.WriteString("\n")
+		if op != "" {
+			sb.WriteString(subtleStyle.Render(op) + " ")
+		}
+		sb.WriteString(cmdStyle.Render(step.Command) + "\n")
+		if step.Summary != "" {
+			sb.WriteString("  " + meaningStyle.Render(step.Summary) + "\n")
+		}
+
+		width := 0
+		for _, a := range step.Args {
+			if w := lipgloss.Width(a.Arg); w <= maxArgColumn && w > width {
+				width = w
+			}
+		}
+		for _, a := range step.Args {
+			if lipgloss.Width(a.Arg) > maxArgColumn {
+				sb.WriteString(fmt.Sprintf("    %s\n    %s  %s\n", argStyle.Render(a.Arg), strings.Repeat(" ", width), meaningStyle.Render(a.Meaning)))
+				continue
+			}
+			padding := strings.Repeat(" ", width-lipgloss.Width(a.Arg))
+			sb.WriteString(fmt.Sprintf("    %s%s  %s\n", argStyle.Render(a.Arg), padding, meaningStyle.Render(a.Meaning)))
+		}
+		op = step.Op
+	}
+
+	if r := renderRisk(risk); r != "" {
+		sb.WriteString(r + "\n")
+	} else {
+		sb.WriteString(subtleStyle.Render("\nNo risks found.") + "\n")
+	}
+
+	if exp.Metrics.Latency != "" {
+		metrics := exp.Metrics.Latency
+		if exp.Metrics.TokenCount > 0 {
+			metrics += fmt.Sprintf(", %d tokens", exp.Metrics.TokenCount)
+		}
+		sb.WriteString(subtleStyle.Render("\n"+metrics) + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,79 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+)
+
+// PickerModel lists alternative commands for one request, best first, and
+// lets the user choose one
+type PickerModel struct {
+	Results  []*model.CommandResult
+	Shell    model.Shell // Decides how commands are quoted
+	Selected int
+	Quitting bool
+	Choice   int // Index of the chosen command, -1 until one is chosen
+}
+
+func InitialPickerModel(results []*model.CommandResult, shell model.Shell) PickerModel {
+	return PickerModel{
+		Results: results,
+		Shell:   shell,
+		Choice:  -1,
+	}
+}
+
+func (m PickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m PickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			m.Quitting = true
+			return m, tea.Quit
+		case "down", "j", "tab":
+			m.Selected = (m.Selected + 1) % len(m.Results)
+		case "up", "k", "shift+tab":
+			m.Selected = (m.Selected + len(m.Results) - 1) % len(m.Results)
+		case "enter":
+			m.Choice = m.Selected
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m PickerModel) View() string {
+	if m.Quitting || m.Choice >= 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(titleStyle.Render("ALTERNATIVES") + "\n")
+	for i, res := range m.Results {
+		marker, style := "  ", subtleStyle
+		if i == m.Selected {
+			marker, style = "> ", cmdStyle
+		}
+		sb.WriteString(fmt.Sprintf("\n%s%s\n", marker, style.Render(fmt.Sprintf("%d. %s", i+1, res.Render(m.Shell)))))
+		if res.TradeOff != "" {
+			sb.WriteString("   " + meaningStyle.Render(res.TradeOff) + "\n")
+		}
+		if i == m.Selected {
+			if res.Explanation != "" {
+				sb.WriteString("   " + subtleStyle.Render(res.Explanation) + "\n")
+			}
+			if r := renderRisk(safety.Assess(res)); r != "" {
+				sb.WriteString(strings.ReplaceAll(r, "\n", "\n   ") + "\n")
+			}
+		}
+	}
+	sb.WriteString(subtleStyle.Render("\nUse arrow keys to navigate • Enter to select • q to quit") + "\n")
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package tui
+
+import (
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// PromptModel reads one line of a chat session. Up and down walk through the
+// lines entered before.
+type PromptModel struct {
+	Input   textinput.Model
+	History []string
+	Done    bool // Enter was pressed
+	Quit    bool // Ctrl-D on an empty line, or Ctrl-C
+
+	index int    // Position in History, len(History) for the new line
+	draft string // The new line, kept while browsing History
+}
+
+// InitialPromptModel opens an empty prompt after the given history
+func InitialPromptModel(history []string) PromptModel {
+	input := textinput.New()
+	input.Prompt = "cmdfy> "
+	input.Focus()
+	return PromptModel{Input: input, History: history, index: len(history)}
+}
+
+// Value returns the line entered
+func (m PromptModel) Value() string {
+	return m.Input.Value()
+}
+
+func (m PromptModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m PromptModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Input.Width = msg.Width - len(m.Input.Prompt) - 1
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyEnter:
+			m.Done = true
+			return m, tea.Quit
+		case tea.KeyCtrlC:
+			m.Quit = true
+			return m, tea.Quit
+		case tea.KeyCtrlD:
+			if m.Input.Value() == "" {
+				m.Quit = true
+				return m, tea.Quit
+			}
+		case tea.KeyUp:
+			if m.index > 0 {
+				if m.index == len(m.History) {
+					m.draft = m.Input.Value()
+				}
+				m.index--
+				m.Input.SetValue(m.History[m.index])
+				m.Input.CursorEnd()
+			}
+			return m, nil
+		case tea.KeyDown:
+			if m.index < len(m.History) {
+				m.index++
+				if m.index == len(m.History) {
+					m.Input.SetValue(m.draft)
+				} else {
+					m.Input.SetValue(m.History[m.index])
+				}
+				m.Input.CursorEnd()
+			}
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.Input, cmd = m.Input.Update(msg)
+	return m, cmd
+}
+
+func (m PromptModel) View() string {
+	if m.Quit {
+		return ""
+	}
+	if m.Done {
+		// Leave the line on screen like a shell would
+		return m.Input.Prompt + m.Input.Value() + "\n"
+	}
+	return m.Input.View() + "\n"
+}
@@ -6,7 +6,9 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
 	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
 )
 
 // Style definitions
@@ -35,16 +37,22 @@ var (
 	dangerStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#FF0000")).
 			Bold(true)
+
+	warnStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FFA500")).
+			Bold(true)
 )
 
 type ProviderResult struct {
-	Name   string
-	Result *model.CommandResult
-	Error  error
+	Name     string
+	Result   *model.CommandResult
+	Error    error
+	Provider llm.Provider // Used to regenerate the command, e.g. to fix it
 }
 
 type Model struct {
 	Results  []ProviderResult
+	Shell    model.Shell // Decides how commands are quoted
 	Selected int
 	Width    int
 	Height   int
@@ -52,9 +60,10 @@ type Model struct {
 	Choice   *ProviderResult
 }
 
-func InitialModel(results []ProviderResult) Model {
+func InitialModel(results []ProviderResult, shell model.Shell) Model {
 	return Model{
 		Results:  results,
+		Shell:    shell,
 		Selected: 0,
 	}
 }
@@ -95,6 +104,31 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// renderRisk shows the risk level with its categories and per-step reasons
+func renderRisk(risk model.Risk) string {
+	if risk.Level == model.RiskNone {
+		return ""
+	}
+
+	style := subtleStyle
+	switch {
+	case risk.Level.AtLeast(model.RiskHigh):
+		style = dangerStyle
+	case risk.Level.AtLeast(model.RiskMedium):
+		style = warnStyle
+	}
+
+	var sb strings.Builder
+	sb.WriteString(style.Render(fmt.Sprintf("\n[RISK: %s]", strings.ToUpper(string(risk.Level)))))
+	for _, c := range risk.Categories {
+		sb.WriteString(subtleStyle.Render(fmt.Sprintf("\n  %s", c)))
+	}
+	for _, s := range risk.Steps {
+		sb.WriteString(subtleStyle.Render(fmt.Sprintf("\n  step %d: %s", s.Step+1, s.Reason)))
+	}
+	return sb.String()
+}
+
 func (m Model) View() string {
 	if m.Quitting {
 		return ""
@@ -114,25 +148,17 @@ func (m Model) View() string {
 		if res.Error != nil {
 			content = fmt.Sprintf("Error:\n%s", res.Error.Error())
 		} else {
-			cmdStr := ""
-			for _, s := range res.Result.Steps {
-				cmdStr += fmt.Sprintf("%s %s %s ", s.Tool, strings.Join(s.Args, " "), s.Op)
-			}
+			cmdStr := res.Result.Render(m.Shell)
 
 			metrics := fmt.Sprintf("Latency: %s\nTokens: %d", res.Result.Metrics.Latency, res.Result.Metrics.TokenCount)
 
 			explanation := lipgloss.NewStyle().Foreground(lipgloss.Color("250")).Render(res.Result.Explanation)
 
-			dangerous := ""
-			if res.Result.Dangerous {
-				dangerous = dangerStyle.Render("\n[DANGEROUS]")
-			}
-
 			content = fmt.Sprintf("%s\n\n%s\n\n%s\n%s",
 				cmdStyle.Render(cmdStr),
 				explanation,
 				subtleStyle.Render(metrics),
-				dangerous,
+				renderRisk(safety.Assess(res.Result)),
 			)
 		}
 
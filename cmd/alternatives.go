@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/kesavan-vaisakh/cmdfy/app/tui"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/brain"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/system"
+)
+
+var alternativesFlag int
+
+// runAlternatives asks one provider for several distinct commands and lets
+// the user pick one, which then goes through the usual flow. The others are
+// recorded in the brain as rejected; the choice is recorded like any other
+// command once it has run.
+func runAlternatives(query string, meta llm.SystemMetadata, gen generator) {
+	fmt.Fprintf(os.Stderr, "Generating %d alternatives...\n", alternativesFlag)
+	results, err := llm.GenerateAlternatives(context.Background(), gen.provider, query, meta, alternativesFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating command: %v\n", err)
+		printHint(err, gen)
+		os.Exit(1)
+	}
+
+	shell := targetShell(meta)
+	b, err := brain.NewBrain()
+	if err != nil {
+		fmt.Printf("Warning: Failed to initialize brain: %v\n", err)
+	}
+	var rejected map[string]bool
+	if b != nil {
+		rejected, _ = b.RejectedCommands(query)
+	}
+	rankAlternatives(results, meta.AvailableCommands, rejected, shell)
+
+	if len(results) == 1 {
+		fmt.Fprintln(os.Stderr, "Only one distinct command was generated.")
+		printAndExecute(results[0], meta, query, gen)
+		return
+	}
+
+	m, err := tea.NewProgram(tui.InitialPickerModel(results, shell)).Run()
+	if err != nil {
+		fmt.Printf("Alas, there's been an error: %v", err)
+		os.Exit(1)
+	}
+	picker, ok := m.(tui.PickerModel)
+	if !ok || picker.Choice < 0 {
+		fmt.Println("Aborted.")
+		return
+	}
+
+	if b != nil {
+		for i, r := range results {
+			if i == picker.Choice {
+				continue
+			}
+			entry := brain.BrainEntry{
+				Query:       query,
+				Command:     r.Render(shell),
+				Explanation: r.Explanation,
+				Provider:    gen.name,
+				Model:       gen.model,
+				Context:     fixContext(meta),
+				Risk:        safety.Assess(r).Level,
+				Rejected:    true,
+			}
+			if err := b.Record(withTemplate(entry, r, shell)); err != nil {
+				fmt.Printf("Warning: Failed to record to brain: %v\n", err)
+				break
+			}
+		}
+	}
+
+	printAndExecute(results[picker.Choice], meta, query, gen)
+}
+
+// rankAlternatives orders the candidates best first: commands not rejected
+// for this query before, then those using only installed tools, then the
+// least risky. Otherwise the provider's order is kept.
+func rankAlternatives(results []*model.CommandResult, available []string, rejected map[string]bool, shell model.Shell) {
+	type key struct {
+		rejected bool
+		missing  bool
+		risk     int
+	}
+	keys := make(map[*model.CommandResult]key, len(results))
+	for _, r := range results {
+		keys[r] = key{
+			rejected: rejected[r.Render(shell)],
+			missing:  len(system.MissingTools(r, available, shell)) > 0,
+			risk:     safety.Assess(r).Level.Severity(),
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := keys[results[i]], keys[results[j]]
+		if a.rejected != b.rejected {
+			return !a.rejected
+		}
+		if a.missing != b.missing {
+			return !a.missing
+		}
+		return a.risk < b.risk
+	})
+}
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&alternativesFlag, "alternatives", 0, "Ask the provider for N distinct commands and pick one")
+}
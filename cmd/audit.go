@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/audit"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/runner"
+)
+
+var auditLimit int
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Browse and verify the log of executed commands",
+	Long: `Every command cmdfy executes, successful or not, is appended to a
+hash-chained log in ~/.cmdfy/audit.jsonl, and the hash of the last record is
+kept in ~/.cmdfy/audit.jsonl.head. Editing, removing or reordering records,
+including cutting records off the end, breaks the chain, which
+'cmdfy audit verify' detects. The log makes tampering evident; someone able
+to rewrite both files can still forge a new chain.`,
+}
+
+var auditListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List executed commands, most recent last",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := openAudit().List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading audit log: %v\n", err)
+			os.Exit(1)
+		}
+		if len(records) == 0 {
+			fmt.Println("No executions recorded.")
+			return
+		}
+		if auditLimit > 0 && len(records) > auditLimit {
+			records = records[len(records)-auditLimit:]
+		}
+		for _, r := range records {
+			fmt.Printf("%4d  %s  exit %-3d %8s  %s\n", r.Seq, r.Timestamp.Format("2006-01-02 15:04:05"), r.ExitCode, r.Duration(), r.Command)
+		}
+	},
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show <seq>",
+	Short: "Show every field of a record",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		seq, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid record number %q\n", args[0])
+			os.Exit(1)
+		}
+		r, err := openAudit().Get(seq)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Record:    %d\n", r.Seq)
+		fmt.Printf("Time:      %s\n", r.Timestamp.Format(time.RFC3339))
+		fmt.Printf("User:      %s\n", r.User)
+		fmt.Printf("Directory: %s\n", r.Dir)
+		fmt.Printf("Query:     %s\n", r.Query)
+		fmt.Printf("Command:   %s\n", r.Command)
+		fmt.Printf("Provider:  %s %s\n", r.Provider, r.Model)
+		fmt.Printf("Risk:      %s\n", r.Risk)
+		fmt.Printf("Exit code: %d\n", r.ExitCode)
+		fmt.Printf("Duration:  %s\n", r.Duration())
+		fmt.Printf("Hash:      %s\n", r.Hash)
+		fmt.Printf("Previous:  %s\n", r.PrevHash)
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that no record was edited, removed or reordered",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		n, err := openAudit().Verify()
+		var verr *audit.VerifyError
+		if errors.As(err, &verr) {
+			fmt.Printf("TAMPERED: %v (%d records before it are intact)\n", verr, n)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying audit log: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("OK: %d records, chain intact\n", n)
+	},
+}
+
+func openAudit() *audit.Log {
+	l, err := audit.NewLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening audit log: %v\n", err)
+		os.Exit(1)
+	}
+	return l
+}
+
+// recordAudit appends a finished run to the audit log. A failure to record
+// is reported, but the command has already run by then.
+func recordAudit(gen generator, query, command string, risk model.Risk, dir string, res runner.Result, runErr error) {
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	exitCode := res.ExitCode
+	if runErr != nil {
+		exitCode = -1
+	}
+
+	l, err := audit.NewLog()
+	if err == nil {
+		_, err = l.Append(audit.Record{
+			Timestamp:  time.Now().Add(-res.Duration),
+			Query:      query,
+			Command:    command,
+			Provider:   gen.name,
+			Model:      gen.model,
+			Dir:        dir,
+			User:       currentUser(),
+			ExitCode:   exitCode,
+			DurationMS: res.Duration.Milliseconds(),
+			Risk:       risk.Level,
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to write audit log: %v\n", err)
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+func init() {
+	auditListCmd.Flags().IntVarP(&auditLimit, "limit", "n", 20, "Show only the last N records (0 for all)")
+	auditCmd.AddCommand(auditListCmd, auditShowCmd, auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"runtime"
 	"strings"
 
@@ -25,6 +24,8 @@ import (
 	_ "github.com/kesavan-vaisakh/cmdfy/pkg/llm/ollama"    // Register Ollama provider
 	_ "github.com/kesavan-vaisakh/cmdfy/pkg/llm/openai"    // Register OpenAI provider
 	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/runner"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
 	"github.com/kesavan-vaisakh/cmdfy/pkg/system"
 )
 
@@ -35,23 +36,32 @@ var (
 	clipboardFlag bool
 	directoryFlag string
 	compareFlag   bool
+	stepFlag      bool
+	retryFlag     int
+	editFlag      bool
+	rawFlag       bool
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "cmdfy [query]",
 	Short: "Cmdfy is a AI-enabled tool to generate commands",
-	Long:  `Cmdfy translates natural language into shell commands using LLMs.`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Cmdfy translates natural language into shell commands using LLMs.
+Without a query it opens a chat session, see 'cmdfy chat'.`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			startChat("", false)
+			return
+		}
 		query := strings.Join(args, " ")
 
 		if clipboardFlag {
 			content, err := clipboard.ReadAll()
 			if err == nil && content != "" {
 				query = fmt.Sprintf("%s\n\nContext from Clipboard:\n%s", query, content)
-				fmt.Println("📋 Added clipboard content to context.")
+				fmt.Fprintln(os.Stderr, "📋 Added clipboard content to context.")
 			} else if err != nil {
-				fmt.Printf("⚠️  Failed to read clipboard: %v\n", err)
+				fmt.Fprintf(os.Stderr, "⚠️  Failed to read clipboard: %v\n", err)
 			}
 		}
 
@@ -62,11 +72,6 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// Context
-		// Gather system metadata
-		commands, _ := system.GetAvailableCommands()
-		files, _ := system.GetFileContext(directoryFlag)
-
 		// Capture Stdin (Previous Error)
 		var previousError string
 		stat, _ := os.Stdin.Stat()
@@ -82,34 +87,12 @@ var rootCmd = &cobra.Command{
 			}
 
 			if previousError != "" {
-				fmt.Println("Context detected from stdin (Error Fix Mode)")
+				fmt.Fprintln(os.Stderr, "Context detected from stdin (Error Fix Mode)")
 			}
 		}
 
-		// Initialize Brain
-		var examples []brain.BrainEntry
-		b, err := brain.NewBrain()
-		if err == nil {
-			examples, _ = b.GetExamples(query, 5)
-		} else {
-			fmt.Printf("Warning: Failed to initialize brain: %v\n", err)
-		}
-
-		meta := llm.SystemMetadata{
-			OS:                runtime.GOOS,
-			Shell:             os.Getenv("SHELL"),
-			AvailableCommands: commands,
-			CurrentDirFiles:   files,
-			PreviousError:     previousError,
-			FewShotExamples:   examples,
-		}
-		if meta.Shell == "" {
-			if runtime.GOOS == "windows" {
-				meta.Shell = "powershell"
-			} else {
-				meta.Shell = "/bin/bash"
-			}
-		}
+		meta := systemMetadata(query)
+		meta.PreviousError = previousError
 
 		if compareFlag {
 			runComparison(query, meta, cfg)
@@ -118,56 +101,25 @@ var rootCmd = &cobra.Command{
 
 		// Single Provider Flow
 
-		// Determine provider
-		providerName := cfg.CurrentProvider
-		if providerFlag != "" {
-			providerName = providerFlag
-		}
+		gen := newGenerator(cfg)
 
-		if providerName == "" {
-			fmt.Println("No provider configured. Please run 'cmdfy config set --provider <name> --key <key>' or use --provider flag.")
-			os.Exit(1)
-		}
-
-		providerConfig, ok := cfg.Providers[providerName]
-		if !ok && providerFlag == "" {
-			fmt.Printf("Provider '%s' not configured.\n", providerName)
-			os.Exit(1)
-		}
-
-		apiKey := providerConfig.APIKey
-		if apiKey == "" {
-			envKey := fmt.Sprintf("%s_API_KEY", strings.ToUpper(providerName))
-			apiKey = os.Getenv(envKey)
-		}
-
-		if apiKey == "" && providerName != "ollama" {
-			fmt.Printf("No API key found for provider '%s'. Please set it with 'cmdfy config set' or %s_API_KEY env var.\n", providerName, strings.ToUpper(providerName))
-			os.Exit(1)
-		}
-
-		llmConfig := llm.ProviderConfig{
-			APIKey:  apiKey,
-			Model:   providerConfig.Model,
-			BaseURL: providerConfig.BaseURL,
-		}
-		llmProvider, err := llm.GetProvider(providerName, llmConfig)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing provider: %v\n", err)
-			os.Exit(1)
+		if alternativesFlag > 1 {
+			runAlternatives(query, meta, gen)
+			return
 		}
 
 		// Generate
 		spinner := "Generating command..."
 		fmt.Fprintln(os.Stderr, spinner)
 
-		result, err := llmProvider.GenerateCommand(context.Background(), query, meta)
+		result, err := generateWithTools(context.Background(), gen.provider, query, meta)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error generating command: %v\n", err)
+			printHint(err, gen)
 			os.Exit(1)
 		}
 
-		printAndExecute(result, meta, query)
+		printAndExecute(result, meta, query, gen)
 	},
 }
 
@@ -208,7 +160,7 @@ func runComparison(query string, meta llm.SystemMetadata, cfg *config.Config) {
 			defer cancel()
 
 			res, err := provider.GenerateCommand(ctx, query, meta)
-			resultsChan <- tui.ProviderResult{Name: pName, Result: res, Error: err}
+			resultsChan <- tui.ProviderResult{Name: pName, Result: res, Error: err, Provider: provider}
 
 		}(name, pCfg, apiKey)
 	}
@@ -226,7 +178,7 @@ func runComparison(query string, meta llm.SystemMetadata, cfg *config.Config) {
 		os.Exit(1)
 	}
 
-	p := tea.NewProgram(tui.InitialModel(results))
+	p := tea.NewProgram(tui.InitialModel(results, targetShell(meta)))
 	m, err := p.Run()
 	if err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
@@ -239,34 +191,17 @@ func runComparison(query string, meta llm.SystemMetadata, cfg *config.Config) {
 
 		// Record to Brain
 		if b, err := brain.NewBrain(); err == nil {
-			// Construct full command string first
-			var fullCmdBuilder strings.Builder
-			for i, step := range finalModel.Choice.Result.Steps {
-				var args []string
-				for _, arg := range step.Args {
-					if strings.Contains(arg, " ") && !strings.HasPrefix(arg, "\"") && !strings.HasPrefix(arg, "'") {
-						args = append(args, fmt.Sprintf("\"%s\"", arg))
-					} else {
-						args = append(args, arg)
-					}
-				}
-				fullCmdBuilder.WriteString(fmt.Sprintf("%s %s", step.Tool, strings.Join(args, " ")))
-				if step.Op != "" {
-					fullCmdBuilder.WriteString(fmt.Sprintf(" %s ", step.Op))
-				} else if i < len(finalModel.Choice.Result.Steps)-1 {
-					// Default to &&
-				}
-			}
-			fullCmdStr := fullCmdBuilder.String()
+			fullCmdStr := finalModel.Choice.Result.Render(targetShell(meta))
 
-			recordErr := b.Record(brain.BrainEntry{
+			recordErr := b.Record(withTemplate(brain.BrainEntry{
 				Query:       query,
 				Command:     fullCmdStr,
 				Explanation: finalModel.Choice.Result.Explanation,
 				Provider:    finalModel.Choice.Name,
 				Model:       "unknown", // We don't have the model name easily available here without drilling into config
-				Context:     meta.PreviousError,
-			})
+				Context:     fixContext(meta),
+				Risk:        safety.Assess(finalModel.Choice.Result).Level,
+			}, finalModel.Choice.Result, targetShell(meta)))
 			if recordErr != nil {
 				fmt.Printf("Warning: Failed to record to brain: %v\n", recordErr)
 			} else {
@@ -274,99 +209,281 @@ func runComparison(query string, meta llm.SystemMetadata, cfg *config.Config) {
 			}
 		}
 
-		printAndExecute(finalModel.Choice.Result, meta, query)
+		gen := generator{
+			name:     finalModel.Choice.Name,
+			model:    cfg.Providers[finalModel.Choice.Name].Model,
+			provider: finalModel.Choice.Provider,
+		}
+		printAndExecute(finalModel.Choice.Result, meta, query, gen)
 	}
 }
 
-func printAndExecute(result *model.CommandResult, meta llm.SystemMetadata, query string) {
-	// Construct full command string
-	var fullCmdBuilder strings.Builder
-	for i, step := range result.Steps {
-		// Quote arguments if they contain spaces
-		var args []string
-		for _, arg := range step.Args {
-			if strings.Contains(arg, " ") && !strings.HasPrefix(arg, "\"") && !strings.HasPrefix(arg, "'") {
-				args = append(args, fmt.Sprintf("\"%s\"", arg))
-			} else {
-				args = append(args, arg)
-			}
-		}
+// systemMetadata gathers the context sent along with a query: the system,
+// the tools and files at hand and similar commands from the brain
+func systemMetadata(query string) llm.SystemMetadata {
+	commands, _ := system.GetAvailableCommands()
+	files, _ := system.GetFileContext(directoryFlag)
+
+	// Initialize Brain
+	var examples []brain.BrainEntry
+	b, err := brain.NewBrain()
+	if err == nil {
+		examples, _ = b.GetExamples(query, 5)
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to initialize brain: %v\n", err)
+	}
+
+	return llm.SystemMetadata{
+		OS:                runtime.GOOS,
+		Shell:             userShell(),
+		AvailableCommands: commands,
+		CurrentDirFiles:   files,
+		FewShotExamples:   examples,
+	}
+}
+
+// newGenerator sets up the provider chosen with --provider or in the config.
+// A provider that can't be used is fatal.
+func newGenerator(cfg *config.Config) generator {
+	providerName := cfg.CurrentProvider
+	if providerFlag != "" {
+		providerName = providerFlag
+	}
+
+	if providerName == "" {
+		fmt.Println("No provider configured. Please run 'cmdfy config set --provider <name> --key <key>' or use --provider flag.")
+		os.Exit(1)
+	}
+
+	providerConfig, ok := cfg.Providers[providerName]
+	if !ok && providerFlag == "" {
+		fmt.Printf("Provider '%s' not configured.\n", providerName)
+		os.Exit(1)
+	}
+
+	apiKey := providerConfig.APIKey
+	if apiKey == "" {
+		envKey := fmt.Sprintf("%s_API_KEY", strings.ToUpper(providerName))
+		apiKey = os.Getenv(envKey)
+	}
+
+	if apiKey == "" && providerName != "ollama" {
+		fmt.Printf("No API key found for provider '%s'. Please set it with 'cmdfy config set' or %s_API_KEY env var.\n", providerName, strings.ToUpper(providerName))
+		os.Exit(1)
+	}
+
+	llmConfig := llm.ProviderConfig{
+		APIKey:  apiKey,
+		Model:   providerConfig.Model,
+		BaseURL: providerConfig.BaseURL,
+	}
+	llmProvider, err := llm.GetProvider(providerName, llmConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing provider: %v\n", err)
+		os.Exit(1)
+	}
+	return generator{name: providerName, model: providerConfig.Model, provider: llmProvider}
+}
+
+// userShell returns the user's shell, or the platform's usual one
+func userShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	return "/bin/bash"
+}
+
+// generator is the provider a command came from, used to regenerate it and
+// to attribute it in the audit log
+type generator struct {
+	name     string
+	model    string
+	provider llm.Provider
+}
+
+func printAndExecute(result *model.CommandResult, meta llm.SystemMetadata, query string, gen generator) {
+	if scriptFlag != "" {
+		checkTools(result, meta, false)
+		writeScript(result, meta, query)
+		return
+	}
+
+	result = fillParameters(result)
+	fullCmdStr := result.Render(targetShell(meta))
+
+	if editFlag {
+		result, fullCmdStr = editBeforeRun(result, fullCmdStr, targetShell(meta), stepFlag)
+	}
 
-		fullCmdBuilder.WriteString(fmt.Sprintf("%s %s", step.Tool, strings.Join(args, " ")))
+	checkTools(result, meta, executeFlag || editFlag || stepFlag)
 
-		if step.Op != "" {
-			fullCmdBuilder.WriteString(fmt.Sprintf(" %s ", step.Op))
-		} else if i < len(result.Steps)-1 {
-			// Default to &&
+	// Don't rely on the model's own judgement alone
+	risk := safety.Assess(result)
+
+	if rawFlag {
+		// Only the command goes to stdout, for shell widgets and scripts
+		if risk.Level != model.RiskNone {
+			fmt.Fprintf(os.Stderr, "cmdfy: RISK %s\n", riskSummary(risk))
 		}
+		fmt.Println(fullCmdStr)
+		return
+	}
+
+	if stepFlag {
+		enforcePolicy(result, risk, false)
+		runStages(result, meta, query, risk, gen)
+		return
 	}
-	fullCmdStr := fullCmdBuilder.String()
-
-	if executeFlag {
-		if result.Dangerous {
-			fmt.Printf("[WARNING] This command is marked as dangerous: %s\n", result.Explanation)
-			fmt.Print("Are you sure you want to execute it? [y/N]: ")
-			var confirm string
-			fmt.Scanln(&confirm)
-			if strings.ToLower(confirm) != "y" {
+
+	if executeFlag || editFlag {
+		// Editing is a confirmation of its own, so only a plain -y is "auto"
+		enforcePolicy(result, risk, !editFlag)
+		if risk.IsDangerous() {
+			fmt.Printf("[WARNING] This command is marked as dangerous (%s): %s\n", riskSummary(risk), result.Explanation)
+			printRiskReasons(risk)
+			if !confirm("Are you sure you want to execute it?") {
 				fmt.Println("Aborted.")
 				os.Exit(0)
 			}
 		}
 
-		fmt.Printf("Executing: %s\n", fullCmdStr)
+		for attempt := 0; ; attempt++ {
+			snapshot := takeSnapshot(result, fullCmdStr)
+			fmt.Printf("Executing: %s\n", fullCmdStr)
 
-		var execCmd *exec.Cmd
-		if runtime.GOOS == "windows" {
-			execCmd = exec.Command("cmd", "/C", fullCmdStr)
-		} else {
-			execCmd = exec.Command(meta.Shell, "-c", fullCmdStr)
-		}
-
-		execCmd.Stdin = os.Stdin
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
+			res, err := runner.New(meta.Shell).Run(context.Background(), fullCmdStr)
+			sealSnapshot(snapshot)
+			recordAudit(gen, query, fullCmdStr, risk, "", res, err)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Execution failed: %v\n", err)
+				os.Exit(1)
+			}
+			if res.Success() {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "Execution failed: exit status %d\n", res.ExitCode)
+			if attempt >= retryFlag || gen.provider == nil {
+				os.Exit(1)
+			}
 
-		if err := execCmd.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "Execution failed: %v\n", err)
-			os.Exit(1)
-		} else {
-			// Successful execution - Record to Brain
-			if b, err := brain.NewBrain(); err == nil {
-				// Reconstruct provider info (best effort since printAndExecute is disconnected)
-				// ideally we'd pass the provider/model info into printAndExecute.
-				// For now, we'll mark provider as "cmdfy-exec" or try to guess.
-				// Better approach: Upgrade printAndExecute signature or pass a callback.
-				// Given phase constraints, let's pass a nil provider for now or simple "executed".
-				_ = b.Record(brain.BrainEntry{
-					Query:       query,
-					Command:     fullCmdStr,
-					Explanation: result.Explanation,
-					Provider:    "system", // Mark as executed
-					Context:     meta.PreviousError,
-				})
-				// Wait, we lost the 'query' in this function scope.
-				// Refactoring needed. Let's change printAndExecute signature.
+			// Feed the failure back to the model and offer its fix
+			fmt.Fprintf(os.Stderr, "Generating a fix (attempt %d/%d)...\n", attempt+1, retryFlag)
+			meta.PreviousCommand = fullCmdStr
+			meta.PreviousError = failureContext(res)
+			result, err = generateWithTools(context.Background(), gen.provider, query, meta)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error generating command: %v\n", err)
+				printHint(err, gen)
+				os.Exit(1)
+			}
+			result = fillParameters(result)
+			fullCmdStr = result.Render(targetShell(meta))
+			checkTools(result, meta, true)
+			risk = safety.Assess(result)
+			enforcePolicy(result, risk, true)
+
+			fmt.Printf("\nCOMMAND: %s\n", fullCmdStr)
+			fmt.Printf("\nEXPLANATION: %s\n", result.Explanation)
+			if risk.Level != model.RiskNone {
+				fmt.Printf("\nRISK: %s\n", riskSummary(risk))
+				printRiskReasons(risk)
 			}
+			if !confirm("Run the corrected command?") {
+				fmt.Println("Aborted.")
+				os.Exit(1)
+			}
+		}
+
+		// Successful execution - Record to Brain, with the error it fixed if any
+		if b, err := brain.NewBrain(); err == nil {
+			_ = b.Record(withTemplate(brain.BrainEntry{
+				Query:       query,
+				Command:     fullCmdStr,
+				Explanation: result.Explanation,
+				Provider:    "system", // Mark as executed
+				Context:     fixContext(meta),
+				Risk:        risk.Level,
+			}, result, targetShell(meta)))
 		}
 	} else {
 		// Pretty print
 		fmt.Printf("\nCOMMAND: %s\n", fullCmdStr)
 		fmt.Printf("\nEXPLANATION: %s\n", result.Explanation)
-		if result.Dangerous {
-			fmt.Printf("\n[DANGEROUS]: Yes\n")
+		if risk.Level != model.RiskNone {
+			fmt.Printf("\nRISK: %s\n", riskSummary(risk))
+			printRiskReasons(risk)
 		}
 		if result.Metrics.Latency != "" {
 			fmt.Printf("\nMETRICS: %s", result.Metrics.Latency)
 			if result.Metrics.TokenCount > 0 {
 				fmt.Printf(", %d tokens", result.Metrics.TokenCount)
 			}
+			if result.Metrics.TimeToFirstToken != "" {
+				fmt.Printf(", first token after %s", result.Metrics.TimeToFirstToken)
+			}
 			fmt.Println()
 		}
 		fmt.Println()
 	}
 }
 
+// fixContext is what a command is recorded with in the brain: the command it
+// fixed and that command's error, as the fix prompt got them
+func fixContext(meta llm.SystemMetadata) string {
+	if meta.PreviousCommand == "" || meta.PreviousError == "" {
+		return meta.PreviousError
+	}
+	return fmt.Sprintf("Failed command:\n%s\nError output:\n%s", meta.PreviousCommand, meta.PreviousError)
+}
+
+// failureContext describes a failed run the way the fix prompt expects it,
+// bounded like the error captured from stdin.
+func failureContext(res runner.Result) string {
+	if res.StderrTail == "" {
+		return fmt.Sprintf("Exit status %d (no error output)", res.ExitCode)
+	}
+	return fmt.Sprintf("Exit status %d\n%s", res.ExitCode, res.StderrTail)
+}
+
+// confirm asks a yes/no question, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	return strings.ToLower(answer) == "y"
+}
+
+// targetShell returns the shell the command will be executed with, which
+// decides how it must be quoted. Windows execution always goes through cmd.
+func targetShell(meta llm.SystemMetadata) model.Shell {
+	if runtime.GOOS == "windows" {
+		return model.ShellCmd
+	}
+	return model.ShellFromPath(meta.Shell)
+}
+
+// riskSummary renders the level and categories, e.g. "HIGH: deletes_data, irreversible"
+func riskSummary(risk model.Risk) string {
+	summary := strings.ToUpper(string(risk.Level))
+	if len(risk.Categories) > 0 {
+		categories := make([]string, len(risk.Categories))
+		for i, c := range risk.Categories {
+			categories[i] = string(c)
+		}
+		summary += ": " + strings.Join(categories, ", ")
+	}
+	return summary
+}
+
+func printRiskReasons(risk model.Risk) {
+	for _, s := range risk.Steps {
+		fmt.Printf("  - step %d: %s\n", s.Step+1, s.Reason)
+	}
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -381,6 +498,10 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&clipboardFlag, "clipboard", "c", false, "Include clipboard content as context")
 	rootCmd.PersistentFlags().StringVarP(&directoryFlag, "directory", "d", ".", "Target directory for context scanning")
 	rootCmd.PersistentFlags().BoolVar(&compareFlag, "compare", false, "Benchmark all configured providers")
+	rootCmd.PersistentFlags().BoolVar(&stepFlag, "step", false, "Execute the command one stage at a time, confirming each")
+	rootCmd.PersistentFlags().BoolVarP(&editFlag, "edit", "e", false, "Edit the command before executing it")
+	rootCmd.PersistentFlags().BoolVar(&rawFlag, "raw", false, "Print only the command, without executing it")
+	rootCmd.PersistentFlags().IntVar(&retryFlag, "retry", 0, "With -y, ask for a fix and retry up to N times when the command fails")
 
 	rootCmd.AddCommand(configCmd)
 }
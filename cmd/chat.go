@@ -0,0 +1,382 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/app/tui"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/brain"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/config"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/runner"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/session"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/system"
+)
+
+// maxConversation is how many earlier turns are sent along with a request
+const maxConversation = 10
+
+const chatHelp = `Type a request to get a command, then refine it, e.g. "now only .log files".
+  :run       execute the current command
+  :explain   break the current command down
+  :save      save the current command to the brain
+  :quit      leave the session (Ctrl-D works too)
+`
+
+var (
+	chatResumeFlag bool
+	chatListFlag   bool
+)
+
+var chatCmd = &cobra.Command{
+	Use:   "chat [session-id]",
+	Short: "Generate and refine commands in a conversation",
+	Long: `Chat opens a session in which every request builds on the earlier ones, so
+"make it recursive" changes the last command instead of starting over. The
+transcript is saved to ~/.cmdfy/sessions as it goes; pass a session id, or
+--resume for the latest one, to pick it up again.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if chatListFlag {
+			listSessions()
+			return
+		}
+		id := ""
+		if len(args) == 1 {
+			id = args[0]
+		}
+		startChat(id, chatResumeFlag)
+	},
+}
+
+// chat is a running session. current is the command the latest request
+// produced, which :run, :explain and :save act on.
+type chat struct {
+	sess    *session.Session
+	gen     generator
+	in      *bufio.Reader // Set when stdin is not a terminal
+	current *model.CommandResult
+	line    string // current rendered, or as written when it can't be parsed
+	query   string // The request current answers
+}
+
+// startChat opens the session with the given id, the latest one when
+// resuming, or a new one, and reads requests until the user leaves
+func startChat(id string, resume bool) {
+	store, err := session.NewStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening sessions: %v\n", err)
+		os.Exit(1)
+	}
+
+	var sess *session.Session
+	switch {
+	case id != "":
+		sess, err = store.Open(id)
+	case resume:
+		sess, err = store.Latest()
+	default:
+		sess, err = store.Create()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening session: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	c := &chat{sess: sess, gen: newGenerator(cfg)}
+	if stat, _ := os.Stdin.Stat(); (stat.Mode() & os.ModeCharDevice) == 0 {
+		c.in = bufio.NewReader(os.Stdin)
+	}
+
+	if len(sess.Turns) == 0 {
+		fmt.Printf("Session %s\n%s\n", sess.ID, chatHelp)
+	} else {
+		fmt.Printf("Resuming session %s (%d turns)\n", sess.ID, len(sess.Turns))
+		c.restore()
+	}
+
+	for {
+		line, ok := c.readLine()
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == ":q" || line == ":quit" || line == ":exit" {
+			break
+		}
+		c.handle(line)
+	}
+
+	if len(sess.Turns) > 0 {
+		fmt.Printf("Resume this session with: cmdfy chat %s\n", sess.ID)
+	}
+}
+
+// handle runs a chat command, or generates a command for a request
+func (c *chat) handle(line string) {
+	switch line {
+	case "":
+	case ":help":
+		fmt.Print(chatHelp)
+	case ":run":
+		c.run()
+	case ":explain":
+		c.explain()
+	case ":save":
+		c.save()
+	default:
+		if strings.HasPrefix(line, ":") {
+			fmt.Fprintf(os.Stderr, "Unknown command %s, try :help\n", line)
+			return
+		}
+		c.request(line)
+	}
+}
+
+// readLine reads the next request, with history navigation on a terminal
+func (c *chat) readLine() (string, bool) {
+	if c.in != nil {
+		line, err := c.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			return line, true
+		}
+		return line, err == nil
+	}
+
+	m, err := tea.NewProgram(tui.InitialPromptModel(c.sess.Lines())).Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+		return "", false
+	}
+	prompt, ok := m.(tui.PromptModel)
+	if !ok || prompt.Quit {
+		return "", false
+	}
+	return prompt.Value(), true
+}
+
+// restore makes the latest command of a resumed session current again and
+// shows it
+func (c *chat) restore() {
+	turn, ok := c.sess.Current()
+	if !ok {
+		return
+	}
+	c.query, c.line = turn.Query, turn.Command
+	c.current, _ = model.Parse(turn.Command)
+	if c.current == nil {
+		// Not POSIX, so it can only run unchecked and after confirmation
+		c.current = &model.CommandResult{Risk: model.Risk{
+			Level: model.RiskHigh,
+			Steps: []model.StepRisk{{Step: 0, Level: model.RiskHigh, Reason: "command could not be parsed, so it was not checked"}},
+		}}
+	}
+	c.current.Explanation = turn.Explanation
+	fmt.Printf("Last request: %s\nCOMMAND: %s\n\n", turn.Query, turn.Command)
+}
+
+// request generates a command for the request, refining the earlier ones
+func (c *chat) request(query string) {
+	meta := systemMetadata(query)
+	meta.Conversation = c.sess.Conversation(maxConversation)
+
+	fmt.Fprintln(os.Stderr, "Generating command...")
+	result, err := generateWithTools(context.Background(), c.gen.provider, query, meta)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating command: %v\n", err)
+		printHint(err, c.gen)
+		c.appendTurn(session.Turn{Kind: session.KindRequest, Query: query, Error: err.Error()})
+		return
+	}
+
+	checkTools(result, meta, false)
+	risk := safety.Assess(result)
+	c.current, c.line, c.query = result, result.Render(targetShell(meta)), query
+
+	fmt.Printf("\nCOMMAND: %s\n", c.line)
+	fmt.Printf("\nEXPLANATION: %s\n", result.Explanation)
+	if risk.Level != model.RiskNone {
+		fmt.Printf("\nRISK: %s\n", riskSummary(risk))
+		printRiskReasons(risk)
+	}
+	fmt.Println()
+
+	c.appendTurn(session.Turn{
+		Kind:        session.KindRequest,
+		Query:       query,
+		Command:     c.line,
+		Explanation: result.Explanation,
+		Risk:        risk.Level,
+	})
+}
+
+// run executes the current command with the same checks as -y, except that
+// a refusal returns to the prompt instead of exiting
+func (c *chat) run() {
+	if c.current == nil {
+		fmt.Fprintln(os.Stderr, "Nothing to run yet, type a request first")
+		return
+	}
+	meta := systemMetadata(c.query)
+
+	result, line := c.current, c.line
+	if len(result.Steps) > 0 {
+		result = fillParameters(result)
+		line = result.Render(targetShell(meta))
+	}
+
+	checkTools(result, meta, false)
+	if strictToolsFlag && len(system.MissingTools(result, meta.AvailableCommands, targetShell(meta))) > 0 {
+		fmt.Fprintln(os.Stderr, "Refusing to execute: --strict-tools is set and the command uses tools that are not installed")
+		return
+	}
+
+	risk := safety.Assess(result)
+	if err := checkPolicy(result, risk, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if risk.IsDangerous() {
+		fmt.Printf("[WARNING] This command is marked as dangerous (%s): %s\n", riskSummary(risk), result.Explanation)
+		printRiskReasons(risk)
+		if !confirm("Are you sure you want to execute it?") {
+			fmt.Println("Aborted.")
+			return
+		}
+	}
+
+	snapshot := takeSnapshot(result, line)
+	fmt.Printf("Executing: %s\n", line)
+	r := runner.New(meta.Shell)
+	if c.in != nil {
+		r.Stdin = nil // The rest of stdin is the session's, not the command's
+	}
+	res, err := r.Run(context.Background(), line)
+	sealSnapshot(snapshot)
+	recordAudit(c.gen, c.query, line, risk, "", res, err)
+
+	turn := session.Turn{Kind: session.KindRun, Command: line, Risk: risk.Level}
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Execution failed: %v\n", err)
+		turn.Error = err.Error()
+	case !res.Success():
+		fmt.Fprintf(os.Stderr, "Execution failed: exit status %d\n", res.ExitCode)
+		turn.ExitCode = &res.ExitCode
+		turn.Output = res.StderrTail
+	default:
+		turn.ExitCode = &res.ExitCode
+		if b, err := brain.NewBrain(); err == nil {
+			_ = b.Record(withTemplate(brain.BrainEntry{
+				Query:       c.query,
+				Command:     line,
+				Explanation: result.Explanation,
+				Provider:    "system", // Mark as executed
+				Risk:        risk.Level,
+			}, result, targetShell(meta)))
+		}
+	}
+	c.appendTurn(turn)
+}
+
+// explain breaks the current command down like 'cmdfy explain'
+func (c *chat) explain() {
+	if c.current == nil {
+		fmt.Fprintln(os.Stderr, "Nothing to explain yet, type a request first")
+		return
+	}
+	meta := llm.SystemMetadata{
+		OS:    runtime.GOOS,
+		Shell: userShell(),
+	}
+
+	fmt.Fprintln(os.Stderr, "Explaining command...")
+	exp, err := c.gen.provider.ExplainCommand(context.Background(), c.line, meta)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error explaining command: %v\n", err)
+		printHint(err, c.gen)
+		return
+	}
+	fmt.Print(tui.RenderExplanation(exp, explainRisk(c.line, exp)))
+
+	c.appendTurn(session.Turn{Kind: session.KindExplain, Command: c.line, Explanation: exp.Summary})
+}
+
+// save records the current command in the brain as a good example
+func (c *chat) save() {
+	if c.current == nil {
+		fmt.Fprintln(os.Stderr, "Nothing to save yet, type a request first")
+		return
+	}
+	b, err := brain.NewBrain()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening brain: %v\n", err)
+		return
+	}
+	shell := model.ShellFromPath(userShell())
+	err = b.Record(withTemplate(brain.BrainEntry{
+		Query:       c.query,
+		Command:     c.line,
+		Explanation: c.current.Explanation,
+		Provider:    c.gen.name,
+		Model:       c.gen.model,
+		Risk:        safety.Assess(c.current).Level,
+	}, c.current, shell))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving to brain: %v\n", err)
+		return
+	}
+	fmt.Println("Saved to the brain.")
+
+	c.appendTurn(session.Turn{Kind: session.KindSave, Command: c.line})
+}
+
+// appendTurn writes the turn to the transcript. Failing to do so shouldn't
+// end the session.
+func (c *chat) appendTurn(t session.Turn) {
+	if err := c.sess.Append(t); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save the session: %v\n", err)
+	}
+}
+
+// listSessions prints the stored sessions, most recent first
+func listSessions() {
+	store, err := session.NewStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening sessions: %v\n", err)
+		os.Exit(1)
+	}
+	infos, err := store.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing sessions: %v\n", err)
+		os.Exit(1)
+	}
+	if len(infos) == 0 {
+		fmt.Println("No sessions recorded.")
+		return
+	}
+	for _, info := range infos {
+		fmt.Printf("%s  %s  %3d turns  %s\n", info.ID, info.Updated.Format("2006-01-02 15:04"), info.Turns, info.First)
+	}
+}
+
+func init() {
+	chatCmd.Flags().BoolVar(&chatResumeFlag, "resume", false, "Resume the most recent session")
+	chatCmd.Flags().BoolVar(&chatListFlag, "list", false, "List recorded sessions")
+	rootCmd.AddCommand(chatCmd)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/kesavan-vaisakh/cmdfy/app/tui"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// maxInlineEdit is the longest command edited inline when $EDITOR is set
+const maxInlineEdit = 200
+
+// editBeforeRun lets the user change the command before it runs. The edited
+// text is what gets executed, and it is parsed back into steps so that the
+// safety checks, policies and brain see what actually ran. Syntax errors
+// reopen the editor. Text that is valid but can't be split into steps, such
+// as a loop, runs as written after confirmation, except with needSteps,
+// which refuses it instead of running it unchecked.
+func editBeforeRun(result *model.CommandResult, line string, shell model.Shell, needSteps bool) (*model.CommandResult, string) {
+	for {
+		edited, err := editCommand(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error editing command: %v\n", err)
+			os.Exit(1)
+		}
+		edited = strings.TrimSpace(edited)
+		if edited == "" {
+			fmt.Println("Aborted.")
+			os.Exit(0)
+		}
+		if edited == line {
+			return result, line
+		}
+
+		parsed, err := model.Parse(edited)
+		if err == nil {
+			parsed.Explanation = result.Explanation
+			parsed.Metrics = result.Metrics
+			return parsed, edited
+		}
+
+		if shell.IsPOSIX() && !errors.Is(err, model.ErrUnsupported) {
+			// Reopen the editor with their text so the mistake can be fixed
+			fmt.Fprintf(os.Stderr, "Can't check the edited command: %v\n", err)
+			line = edited
+			continue
+		}
+		if needSteps {
+			fmt.Fprintf(os.Stderr, "Error: --step can't split the edited command into steps: %v\n", err)
+			os.Exit(1)
+		}
+
+		// The parser only knows the POSIX constructs it can split into
+		// steps, so anything else runs unverified and needs confirmation
+		if shell.IsPOSIX() {
+			fmt.Fprintf(os.Stderr, "Warning: the edited command can't be analyzed: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: the edited command can't be analyzed for %s.\n", shell)
+		}
+		return &model.CommandResult{
+			Explanation: result.Explanation,
+			Risk: model.Risk{
+				Level: model.RiskHigh,
+				Steps: []model.StepRisk{{Step: 0, Level: model.RiskHigh, Reason: "could not be parsed"}},
+			},
+			Metrics: result.Metrics,
+		}, edited
+	}
+}
+
+// editCommand opens the command in an editor and returns the edited text.
+// Multi-line commands and long pipelines go to $EDITOR, everything else to
+// an inline line editor.
+func editCommand(line string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+
+	if strings.Contains(line, "\n") || (editor != "" && len(line) > maxInlineEdit) {
+		if editor == "" {
+			editor = "vi"
+			if runtime.GOOS == "windows" {
+				editor = "notepad"
+			}
+		}
+		return editExternal(editor, line)
+	}
+
+	m, err := tea.NewProgram(tui.InitialEditModel(line)).Run()
+	if err != nil {
+		return "", err
+	}
+	edit, ok := m.(tui.EditModel)
+	if !ok || edit.Cancelled {
+		return "", nil
+	}
+	return edit.Value(), nil
+}
+
+// editExternal writes the command to a temporary file, opens it in editor
+// and reads it back
+func editExternal(editor, line string) (string, error) {
+	f, err := os.CreateTemp("", "cmdfy-*.sh")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	// $EDITOR may carry arguments, e.g. "code --wait"
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], f.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read temp file: %w", err)
+	}
+	return string(data), nil
+}
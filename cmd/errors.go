@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+)
+
+// printHint follows a failed request's error with what the user can do
+// about it, when cmdfy knows
+func printHint(err error, gen generator) {
+	if hint := errorHint(err, gen); hint != "" {
+		fmt.Fprintf(os.Stderr, "Hint: %s\n", hint)
+	}
+}
+
+// errorHint suggests a fix for err, which gen's provider returned
+func errorHint(err error, gen generator) string {
+	switch {
+	case errors.Is(err, llm.ErrAuth):
+		return fmt.Sprintf("check your API key, set it with 'cmdfy config set --provider %s --key <key>'", gen.name)
+	case errors.Is(err, llm.ErrQuotaExceeded):
+		return fmt.Sprintf("your %s account is out of credit or over its quota, check its plan and billing", gen.name)
+	case errors.Is(err, llm.ErrRateLimited):
+		return "the provider is limiting requests, wait a minute and try again"
+	case errors.Is(err, llm.ErrModelNotFound):
+		if gen.name == "ollama" && gen.model != "" {
+			return fmt.Sprintf("download the model with 'ollama pull %s', or pick another with 'cmdfy config set --provider ollama --model <model>'", gen.model)
+		}
+		return fmt.Sprintf("pick a model your account can use with 'cmdfy config set --provider %s --model <model>'", gen.name)
+	case errors.Is(err, llm.ErrTimeout):
+		return "the provider took too long, try again or use a faster model"
+	case errors.Is(err, llm.ErrBadResponse):
+		return "the model's reply couldn't be used, try rephrasing the request or use a stronger model"
+	}
+
+	// No response at all
+	var perr *llm.ProviderError
+	if errors.As(err, &perr) && perr.Kind == nil && perr.Status == 0 {
+		if gen.name == "ollama" {
+			return "is Ollama running? Start it with 'ollama serve'"
+		}
+		return "check your network connection and the provider's URL"
+	}
+	return ""
+}
+
+func init() {
+	llm.DefaultBackoff.OnRetry = func(err error, wait time.Duration) {
+		fmt.Fprintf(os.Stderr, "%v, retrying in %s...\n", err, wait.Round(100*time.Millisecond))
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/app/tui"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/config"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [command]",
+	Short: "Break down an existing command step by step and flag by flag",
+	Long: `Explain sends a command you already have, e.g. one pasted from a runbook,
+to the configured provider and shows what each step and argument does along
+with its risk. Without an argument the command is read from stdin.
+
+Several arguments are the words of one command, as with 'cmdfy run'. A
+single argument is a whole command line.
+
+  cmdfy explain -- tar -xzvf 'my backup.tar.gz'
+  cmdfy explain 'find . -name "*.log" -delete'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		command := model.CommandLine(targetShell(llm.SystemMetadata{Shell: userShell()}), args)
+		if command == "" {
+			// Nothing piped in, so don't wait on the terminal
+			if stat, _ := os.Stdin.Stat(); stat == nil || stat.Mode()&os.ModeCharDevice != 0 {
+				cmd.Usage()
+				os.Exit(1)
+			}
+		}
+		if command == "" || command == "-" {
+			input, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+				os.Exit(1)
+			}
+			command = string(input)
+		}
+		command = strings.TrimSpace(command)
+		if command == "" {
+			fmt.Fprintln(os.Stderr, "Error: no command to explain")
+			os.Exit(1)
+		}
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+		gen := newGenerator(cfg)
+
+		meta := llm.SystemMetadata{
+			OS:    runtime.GOOS,
+			Shell: userShell(),
+		}
+
+		fmt.Fprintln(os.Stderr, "Explaining command...")
+		exp, err := gen.provider.ExplainCommand(context.Background(), command, meta)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error explaining command: %v\n", err)
+			printHint(err, gen)
+			os.Exit(1)
+		}
+
+		fmt.Print(tui.RenderExplanation(exp, explainRisk(command, exp)))
+	},
+}
+
+// explainRisk combines the model's assessment with the local safety rules
+// when the command can be parsed, so the same checks apply as to generated
+// commands.
+func explainRisk(command string, exp *model.CommandExplanation) model.Risk {
+	parsed, err := model.Parse(command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: only the model's risk assessment is shown, the command can't be analyzed: %v\n", err)
+		return exp.Risk
+	}
+	parsed.Risk = exp.Risk
+	return safety.Assess(parsed)
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+}
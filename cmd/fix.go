@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/config"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/history"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/runner"
+)
+
+// defaultFixQuery is the request sent with a failed command when the user
+// doesn't say what it was meant to do
+const defaultFixQuery = "fix this command"
+
+var fixTimeoutFlag time.Duration
+
+var fixCmd = &cobra.Command{
+	Use:   "fix [query]",
+	Short: "Re-run the last command and fix it if it fails",
+	Long: `Fix reads the last command you ran from the shell-init hook or your shell's
+history file, runs it again to capture its exit status and error output, and
+asks the configured provider for a corrected command. An optional query says
+what the command was meant to do.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		query := strings.Join(args, " ")
+		if query == "" {
+			query = defaultFixQuery
+		}
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+
+		meta := systemMetadata(query)
+		last, err := history.LastCommand(filepath.Base(meta.Shell))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding the last command: %v\n", err)
+			os.Exit(1)
+		}
+
+		risk := lineRisk(last)
+		confirmRerun(last, risk)
+
+		fmt.Fprintf(os.Stderr, "Re-running: %s\n", last)
+		ctx, cancel := context.WithTimeout(context.Background(), fixTimeoutFlag)
+		defer cancel()
+
+		// Stdin stays closed so a command waiting for input can't hang here
+		r := runner.New(meta.Shell)
+		r.Stdin = nil
+		res, err := r.Run(ctx, last)
+		recordAudit(generator{}, "", last, risk, "", res, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Execution failed: %v\n", err)
+			os.Exit(1)
+		}
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		if res.Success() && !timedOut {
+			fmt.Fprintln(os.Stderr, "The command succeeded, nothing to fix.")
+			return
+		}
+
+		meta.PreviousCommand = last
+		if timedOut {
+			meta.PreviousError = fmt.Sprintf("Timed out after %s\n%s", fixTimeoutFlag, res.StderrTail)
+			fmt.Fprintf(os.Stderr, "Timed out after %s. Generating a fix...\n", fixTimeoutFlag)
+		} else {
+			meta.PreviousError = failureContext(res)
+			fmt.Fprintf(os.Stderr, "Exit status %d. Generating a fix...\n", res.ExitCode)
+		}
+
+		offerFix(cfg, query, meta)
+	},
+}
+
+// offerFix generates a correction for meta.PreviousCommand, which failed
+// with meta.PreviousError, and shows or runs it like any generated command
+func offerFix(cfg *config.Config, query string, meta llm.SystemMetadata) {
+	gen := newGenerator(cfg)
+	result, err := generateWithTools(context.Background(), gen.provider, query, meta)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating command: %v\n", err)
+		printHint(err, gen)
+		os.Exit(1)
+	}
+
+	printAndExecute(result, meta, query, gen)
+}
+
+// confirmRerun asks before running the last command again, showing its risk,
+// unless -y was given
+func confirmRerun(line string, risk model.Risk) {
+	if executeFlag {
+		return
+	}
+	fmt.Printf("Last command: %s\n", line)
+	if risk.Level != model.RiskNone {
+		fmt.Printf("RISK: %s\n", riskSummary(risk))
+		printRiskReasons(risk)
+	}
+	if !confirm("Run it again to capture its error?") {
+		fmt.Println("Aborted.")
+		os.Exit(0)
+	}
+}
+
+func init() {
+	fixCmd.Flags().DurationVar(&fixTimeoutFlag, "timeout", 30*time.Second, "How long to let the last command run before stopping it")
+	rootCmd.AddCommand(fixCmd)
+}
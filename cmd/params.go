@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/brain"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+var (
+	setFlags []string
+	// paramValues holds the values given with --set and answered so far, so
+	// a regenerated command doesn't ask for them again
+	paramValues map[string]string
+)
+
+// fillParameters fills in the command's {{name}} placeholders with the values
+// given with --set, asking for the rest. Without a terminal to ask on, the
+// defaults are used and a parameter without one is fatal.
+func fillParameters(result *model.CommandResult) *model.CommandResult {
+	params := result.Params()
+	if len(params) == 0 {
+		return result
+	}
+
+	if paramValues == nil {
+		paramValues = setValues()
+	}
+
+	stat, _ := os.Stdin.Stat()
+	interactive := stat != nil && stat.Mode()&os.ModeCharDevice != 0
+	in := bufio.NewReader(os.Stdin)
+
+	for _, p := range params {
+		if _, ok := paramValues[p.Name]; ok {
+			continue
+		}
+		if !interactive {
+			if p.Default == "" {
+				fmt.Fprintf(os.Stderr, "Error: no value for {{%s}}, pass it with --set %s=<value>\n", p.Name, p.Name)
+				os.Exit(1)
+			}
+			paramValues[p.Name] = p.Default
+			continue
+		}
+		paramValues[p.Name] = askParameter(in, p)
+	}
+
+	filled, err := result.Fill(paramValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return filled
+}
+
+// setValues parses the --set flags
+func setValues() map[string]string {
+	values := make(map[string]string, len(setFlags))
+	for _, kv := range setFlags {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "Error: invalid --set %q, expected key=value\n", kv)
+			os.Exit(1)
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// askParameter prompts until the value is valid for the parameter's type.
+// An empty answer, or the end of input, takes the default. The prompts go
+// to stderr so that --raw output stays clean.
+func askParameter(in *bufio.Reader, p model.Parameter) string {
+	question := p.Name
+	if p.Description != "" {
+		question += " (" + p.Description + ")"
+	}
+	if p.Default != "" {
+		question += " [" + p.Default + "]"
+	}
+
+	for {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintln(os.Stderr)
+			if p.Default != "" {
+				return p.Default
+			}
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			os.Exit(1)
+		}
+		answer = strings.TrimRight(answer, "\r\n")
+		if answer == "" {
+			answer = p.Default
+		}
+		if err := p.Validate(answer); err != nil {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+			continue
+		}
+		return answer
+	}
+}
+
+// withTemplate stores the parameterized form of the command on a brain
+// entry, so it can be reused with other values
+func withTemplate(entry brain.BrainEntry, result *model.CommandResult, shell model.Shell) brain.BrainEntry {
+	template := result.Template
+	if template == nil {
+		template = result
+	}
+	if params := template.Params(); len(params) > 0 {
+		entry.Template = template.Render(shell)
+		entry.Parameters = params
+	}
+	return entry
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&setFlags, "set", nil, "Fill in a parameter of the generated command (key=value, repeatable)")
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/policy"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Inspect execution policies",
+	Long: `Policies in ~/.cmdfy/policy.yaml and a project's .cmdfy/policy.yaml restrict
+which commands cmdfy is allowed to execute.`,
+}
+
+var policyCheckCmd = &cobra.Command{
+	Use:   "check <command>",
+	Short: "Check a command against the policies without generating anything",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Parse reads POSIX syntax, so the words are quoted for it
+		result, err := model.Parse(model.CommandLine(model.ShellPOSIX, args))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		set := loadPolicies()
+		if len(set.Policies) == 0 {
+			fmt.Println("No policy files found.")
+		}
+		for _, p := range set.Policies {
+			fmt.Printf("Using %s\n", p.Source)
+		}
+
+		risk := safety.Assess(result)
+		fmt.Printf("RISK: %s\n", riskSummary(risk))
+
+		violations := set.Check(result)
+		for _, v := range violations {
+			fmt.Printf("DENIED %s\n", v)
+		}
+		if ok, source := set.AllowsAutoExecute(risk.Level); !ok {
+			fmt.Printf("-y is not permitted for %s risk commands (%s)\n", risk.Level, source)
+		}
+
+		if len(violations) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("ALLOWED")
+	},
+}
+
+// loadPolicies reads the policies for the current directory. A broken
+// policy file is fatal, silently ignoring it would defeat its purpose.
+func loadPolicies() *policy.Set {
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
+	set, err := policy.Load(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading policy: %v\n", err)
+		os.Exit(1)
+	}
+	return set
+}
+
+// enforcePolicy refuses to go on when the command breaks a policy, or when
+// it would be run by -y at a risk level the policy doesn't permit.
+func enforcePolicy(result *model.CommandResult, risk model.Risk, auto bool) {
+	if err := checkPolicy(result, risk, auto); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// checkPolicy is enforcePolicy for callers that carry on after a refusal
+func checkPolicy(result *model.CommandResult, risk model.Risk, auto bool) error {
+	set := loadPolicies()
+
+	if len(result.Steps) == 0 && len(set.Policies) > 0 {
+		return errors.New("refused by policy: the command could not be parsed, so it can't be checked")
+	}
+
+	if violations := set.Check(result); len(violations) > 0 {
+		msg := "refused by policy:"
+		for _, v := range violations {
+			msg += fmt.Sprintf("\n  - %s", v)
+		}
+		return errors.New(msg)
+	}
+
+	if auto {
+		if ok, source := set.AllowsAutoExecute(risk.Level); !ok {
+			return fmt.Errorf("refused by policy: -y is not permitted for %s risk commands (%s), use --step to confirm each stage", risk.Level, source)
+		}
+	}
+	return nil
+}
+
+func init() {
+	policyCmd.AddCommand(policyCheckCmd)
+	rootCmd.AddCommand(policyCmd)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/prompt"
+)
+
+var promptsCmd = &cobra.Command{
+	Use:   "prompts [name]",
+	Short: "List the prompt templates or print one",
+	Long: `Prompts are built from Go templates. A file in ~/.cmdfy/prompts named after a
+template, e.g. schema.tmpl, replaces the built-in one; extra.tmpl is empty by
+default and adds your own instructions to every request. Print a template
+to start from its text.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		templates, err := prompt.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading prompts: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(args) == 1 {
+			text, _, ok := templates.Source(args[0])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Error: no template named %q\n", args[0])
+				os.Exit(1)
+			}
+			fmt.Println(text)
+			return
+		}
+
+		for _, name := range templates.Names() {
+			_, origin, _ := templates.Source(name)
+			if origin == "" {
+				origin = "built-in"
+			}
+			fmt.Printf("%-16s %s\n", name, origin)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(promptsCmd)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/config"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/runner"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+)
+
+var runQueryFlag string
+
+var runCmd = &cobra.Command{
+	Use:   "run -- <command>",
+	Short: "Run a command and offer a fix if it fails",
+	Long: `Run executes the command through your shell with its output shown as usual.
+If it exits with a non-zero status, the command line, the exit status and the
+end of its error output are sent to the configured provider and a corrected
+command is offered. On success nothing else happens. The exit status of the
+command is passed on unless the correction is executed.
+
+Several arguments are the words of one command and reach it unchanged. A
+single argument is a whole command line, for pipes and lists.
+
+  cmdfy run -- make build
+  cmdfy run -- 'tar -xf backup.tar.zst | head'`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		shell := userShell()
+		line := model.CommandLine(targetShell(llm.SystemMetadata{Shell: shell}), args)
+
+		res, err := runner.New(shell).Run(context.Background(), line)
+		recordAudit(generator{}, "", line, lineRisk(line), "", res, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Execution failed: %v\n", err)
+			os.Exit(1)
+		}
+		if res.Success() {
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "\ncmdfy: exit status %d. Generating a fix...\n", res.ExitCode)
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(res.ExitCode)
+		}
+
+		query := runQueryFlag
+		if query == "" {
+			query = defaultFixQuery
+		}
+		meta := systemMetadata(query)
+		meta.PreviousCommand = line
+		meta.PreviousError = failureContext(res)
+		offerFix(cfg, query, meta)
+
+		if !executeFlag && !editFlag && !stepFlag {
+			// Only a suggestion was shown, the command still failed
+			os.Exit(res.ExitCode)
+		}
+	},
+}
+
+// lineRisk rates a command line the user typed. One that can't be parsed is
+// rated high, as an edited command is.
+func lineRisk(line string) model.Risk {
+	parsed, err := model.Parse(line)
+	if err != nil {
+		return model.Risk{
+			Level: model.RiskHigh,
+			Steps: []model.StepRisk{{Step: 0, Level: model.RiskHigh, Reason: "could not be parsed"}},
+		}
+	}
+	return safety.Assess(parsed)
+}
+
+func init() {
+	runCmd.Flags().StringVarP(&runQueryFlag, "query", "q", "", "What the command is meant to do, to guide the fix")
+	rootCmd.AddCommand(runCmd)
+}
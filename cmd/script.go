@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+)
+
+var (
+	scriptFlag string
+	forceFlag  bool
+)
+
+// writeScript saves the command as an executable script instead of running
+// it. Parameters stay variables in the script, with the values given with
+// --set as their defaults.
+func writeScript(result *model.CommandResult, meta llm.SystemMetadata, query string) {
+	shell := model.ShellFromPath(meta.Shell)
+	if !shell.IsPOSIX() {
+		fmt.Fprintf(os.Stderr, "Warning: %s scripts are not supported, writing a bash script instead.\n", shell)
+		shell = model.ShellBash
+	}
+	if shell == model.ShellPOSIX && result.HasPipeline() {
+		fmt.Fprintln(os.Stderr, "Warning: sh can't catch a failure inside a pipeline, writing a bash script with pipefail instead.")
+	}
+
+	template := *result
+	template.Parameters = result.Params()
+	values := setValues()
+	for i, p := range template.Parameters {
+		if v, ok := values[p.Name]; ok {
+			template.Parameters[i].Default = v
+		}
+	}
+
+	script, err := template.Script(shell, query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if forceFlag {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(scriptFlag, flags, 0755)
+	if errors.Is(err, fs.ErrExist) {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists, use --force to overwrite it\n", scriptFlag)
+		os.Exit(1)
+	}
+	if err == nil {
+		_, err = f.WriteString(script)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		// An overwritten file keeps its old mode otherwise
+		err = os.Chmod(scriptFlag, 0755)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing script: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Wrote %s\n", scriptFlag)
+	fmt.Printf("\nEXPLANATION: %s\n", result.Explanation)
+	if risk := safety.Assess(result); risk.Level != model.RiskNone {
+		fmt.Printf("\nRISK: %s\n", riskSummary(risk))
+		printRiskReasons(risk)
+	}
+	run := scriptFlag
+	if !strings.ContainsRune(run, filepath.Separator) {
+		run = "." + string(filepath.Separator) + run
+	}
+	fmt.Printf("\nRun %s --dry-run to see what it would do.\n", run)
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&scriptFlag, "script", "", "Write the command to an executable script instead of printing it")
+	rootCmd.PersistentFlags().BoolVar(&forceFlag, "force", false, "With --script, overwrite an existing file")
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// The widgets run cmdfy with --raw on the current line and replace it with
+// the command. SHELL is set so that it is quoted for the shell it lands in.
+// The hooks export the last command run for 'cmdfy fix'.
+const bashInit = `# cmdfy shell integration for bash, add to ~/.bashrc:
+#   eval "$(cmdfy shell-init bash)"
+# Type a request and press Ctrl-G to replace it with the command.
+__cmdfy_widget() {
+  [ -n "$READLINE_LINE" ] || return
+  local cmd
+  cmd="$(SHELL=bash cmdfy --raw -- "$READLINE_LINE" </dev/null)" || return
+  READLINE_LINE="$cmd"
+  READLINE_POINT=${#READLINE_LINE}
+}
+bind -x '"\C-g": __cmdfy_widget'
+
+# Remember the last command for 'cmdfy fix', keeping $? for other hooks
+__cmdfy_hook() {
+  local status=$?
+  export CMDFY_LAST_COMMAND="$(HISTTIMEFORMAT= builtin history 1 | sed 's/^ *[0-9]*[* ] *//')"
+  return $status
+}
+PROMPT_COMMAND="${PROMPT_COMMAND:+$PROMPT_COMMAND;}__cmdfy_hook"
+`
+
+const zshInit = `# cmdfy shell integration for zsh, add to ~/.zshrc:
+#   eval "$(cmdfy shell-init zsh)"
+# Type a request and press Ctrl-G to replace it with the command.
+_cmdfy_widget() {
+  [[ -n "$BUFFER" ]] || return
+  local cmd
+  cmd="$(SHELL=zsh cmdfy --raw -- "$BUFFER" </dev/null)"
+  if [[ $? -eq 0 && -n "$cmd" ]]; then
+    BUFFER="$cmd"
+    CURSOR=${#BUFFER}
+  fi
+  zle reset-prompt
+}
+zle -N _cmdfy_widget
+bindkey '^G' _cmdfy_widget
+
+# Remember the last command for 'cmdfy fix'
+_cmdfy_preexec() { _cmdfy_current="$1" }
+_cmdfy_precmd() { [[ -n "$_cmdfy_current" ]] && export CMDFY_LAST_COMMAND="$_cmdfy_current"; return 0 }
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _cmdfy_preexec
+add-zsh-hook precmd _cmdfy_precmd
+`
+
+const fishInit = `# cmdfy shell integration for fish, add to ~/.config/fish/config.fish:
+#   cmdfy shell-init fish | source
+# Type a request and press Ctrl-G to replace it with the command.
+function __cmdfy_widget
+    set -l query (commandline)
+    test -n "$query"; or return
+    set -l cmd (SHELL=fish cmdfy --raw -- "$query" </dev/null | string collect)
+    and test -n "$cmd"
+    and commandline -r -- $cmd
+    commandline -f repaint
+end
+bind \cg __cmdfy_widget
+
+# Remember the last command for 'cmdfy fix'
+function __cmdfy_postexec --on-event fish_postexec
+    set -gx CMDFY_LAST_COMMAND $argv[1]
+end
+`
+
+var shellInitCmd = &cobra.Command{
+	Use:       "shell-init <bash|zsh|fish>",
+	Short:     "Print a key binding that turns the current line into a command",
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Args:      cobra.ExactArgs(1),
+	Long: `Prints widget code for your shell. Once loaded, type a request at the prompt
+and press Ctrl-G: the line is replaced with the generated command, ready to
+edit and run with Enter, and it lands in your shell history like any other.
+It also remembers the last command you ran for 'cmdfy fix'.
+
+  bash: eval "$(cmdfy shell-init bash)"   in ~/.bashrc
+  zsh:  eval "$(cmdfy shell-init zsh)"    in ~/.zshrc
+  fish: cmdfy shell-init fish | source    in ~/.config/fish/config.fish`,
+	Run: func(cmd *cobra.Command, args []string) {
+		switch args[0] {
+		case "bash":
+			fmt.Print(bashInit)
+		case "zsh":
+			fmt.Print(zshInit)
+		case "fish":
+			fmt.Print(fishInit)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unsupported shell %q, expected bash, zsh or fish\n", args[0])
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shellInitCmd)
+}
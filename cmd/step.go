@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/brain"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/runner"
+)
+
+// runStages executes the pipeline one stage at a time, asking before each
+// one. Stages are joined with the shell's own &&, || and ; semantics.
+func runStages(result *model.CommandResult, meta llm.SystemMetadata, query string, risk model.Risk, gen generator) {
+	shell := targetShell(meta)
+	stages := result.Stages()
+	r := runner.New(meta.Shell)
+	in := bufio.NewReader(os.Stdin)
+
+	fmt.Printf("Running %d stage(s) one at a time: %s\n", len(stages), result.Explanation)
+	if risk.Level != model.RiskNone {
+		fmt.Printf("RISK: %s\n", riskSummary(risk))
+	}
+
+	snapshot := takeSnapshot(result, result.Render(shell))
+
+	status := 0
+	skipped := false
+	for n, stage := range stages {
+		line := stage.Render(shell)
+		fmt.Printf("\n[%d/%d] %s\n", n+1, len(stages), line)
+
+		if n > 0 && !shouldRunAfter(stages[n-1].Op, status) {
+			fmt.Printf("  Skipped: previous stage exited with %d (%s)\n", status, stages[n-1].Op)
+			continue
+		}
+
+		if explanation := stage.Explanation(); explanation != "" {
+			fmt.Printf("  %s\n", explanation)
+		}
+		for i := range stage.Steps {
+			for _, reason := range risk.StepReasons(stage.Start + i) {
+				fmt.Printf("  [WARNING] %s\n", reason)
+			}
+		}
+
+		switch askStage(in) {
+		case "skip":
+			// A skipped stage counts as successful so the chain carries on
+			skipped = true
+			status = 0
+			continue
+		case "abort":
+			fmt.Println("Aborted.")
+			os.Exit(0)
+		}
+
+		if dir, ok := cdTarget(stage); ok {
+			status = 0
+			if err := r.Chdir(dir); err != nil {
+				fmt.Fprintf(os.Stderr, "  %v\n", err)
+				status = 1
+			}
+			fmt.Printf("  exit %d\n", status)
+			continue
+		}
+
+		res, err := r.Run(context.Background(), line)
+		sealSnapshot(snapshot)
+		recordAudit(gen, query, line, risk, r.Dir, res, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  Execution failed: %v\n", err)
+			status = 127
+			continue
+		}
+		status = res.ExitCode
+		fmt.Printf("  exit %d (%s)\n", status, res.Duration.Round(time.Millisecond))
+	}
+
+	if status != 0 {
+		os.Exit(1)
+	}
+
+	// Only a run that went through every stage is worth learning from
+	if !skipped {
+		if b, err := brain.NewBrain(); err == nil {
+			_ = b.Record(withTemplate(brain.BrainEntry{
+				Query:       query,
+				Command:     result.Render(shell),
+				Explanation: result.Explanation,
+				Provider:    "system",
+				Context:     fixContext(meta),
+				Risk:        risk.Level,
+			}, result, shell))
+		}
+	}
+}
+
+// shouldRunAfter applies the operator joining the previous stage, given the
+// exit status of the last stage that ran.
+func shouldRunAfter(op string, status int) bool {
+	switch op {
+	case "&&":
+		return status == 0
+	case "||":
+		return status != 0
+	default:
+		return true
+	}
+}
+
+// askStage prompts until the user picks run, skip or abort. End of input
+// aborts.
+func askStage(in *bufio.Reader) string {
+	for {
+		fmt.Print("Run this stage? [r]un / [s]kip / [a]bort: ")
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Println()
+			return "abort"
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "r", "run", "y", "yes":
+			return "run"
+		case "s", "skip", "n", "no":
+			return "skip"
+		case "a", "abort", "q", "quit":
+			return "abort"
+		}
+	}
+}
+
+// cdTarget reports whether the stage is a plain "cd". Each stage runs in its
+// own shell, so the directory change has to be kept by the runner instead.
+func cdTarget(stage model.Stage) (string, bool) {
+	if len(stage.Steps) != 1 {
+		return "", false
+	}
+	step := stage.Steps[0]
+	if step.Tool != "cd" || len(step.Subshell) > 0 || len(step.Redirects) > 0 || len(step.Env) > 0 || step.Background {
+		return "", false
+	}
+	if len(step.Args) == 0 {
+		return "", true
+	}
+	return step.Args[0], true
+}
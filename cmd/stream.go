@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// streamWidth is how much of the response the progress line shows
+const streamWidth = 76
+
+var noStreamFlag bool
+
+// generate asks the provider for a command. When the provider can stream and
+// stderr is a terminal, the response is shown on one line as it arrives: the
+// explanation once it has started, the raw JSON before that.
+func generate(ctx context.Context, provider llm.Provider, query string, meta llm.SystemMetadata) (*model.CommandResult, error) {
+	sp, ok := provider.(llm.StreamingProvider)
+	if !ok || noStreamFlag || !stderrIsTerminal() {
+		return provider.GenerateCommand(ctx, query, meta)
+	}
+
+	var text strings.Builder
+	shown := false
+	result, err := sp.GenerateCommandStream(ctx, query, meta, func(token string) {
+		text.WriteString(token)
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", streamLine(text.String()))
+		shown = true
+	})
+	if shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	return result, err
+}
+
+// streamLine is the progress line for a partial response
+func streamLine(text string) string {
+	line, ok := llm.PartialString(text, "explanation")
+	if !ok {
+		line = text
+	}
+	line = strings.Join(strings.Fields(line), " ")
+
+	runes := []rune(line)
+	if len(runes) > streamWidth {
+		line = "..." + string(runes[len(runes)-streamWidth+3:])
+	}
+	return line
+}
+
+func stderrIsTerminal() bool {
+	stat, err := os.Stderr.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&noStreamFlag, "no-stream", false, "Wait for the whole response instead of showing it as it arrives")
+}
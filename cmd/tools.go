@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/system"
+)
+
+var strictToolsFlag bool
+
+// generateWithTools generates a command and, when it uses tools that are not
+// installed, asks the provider once more with those tools ruled out. If the
+// second attempt fails the first result is kept and checkTools warns about it.
+func generateWithTools(ctx context.Context, provider llm.Provider, query string, meta llm.SystemMetadata) (*model.CommandResult, error) {
+	result, err := generate(ctx, provider, query, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := system.MissingTools(result, meta.AvailableCommands, targetShell(meta))
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Not installed: %s. Regenerating...\n", strings.Join(missing, ", "))
+	meta.UnavailableTools = append(meta.UnavailableTools, missing...)
+	retry, err := generate(ctx, provider, query, meta)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to regenerate: %v\n", err)
+		return result, nil
+	}
+	return retry, nil
+}
+
+// checkTools warns about tools the command uses that are not installed,
+// suggesting a standard alternative where one is. With --strict-tools such a
+// command is refused when it is about to be executed.
+func checkTools(result *model.CommandResult, meta llm.SystemMetadata, executing bool) {
+	missing := system.MissingTools(result, meta.AvailableCommands, targetShell(meta))
+	if len(missing) == 0 {
+		return
+	}
+
+	installed := make(map[string]bool, len(meta.AvailableCommands))
+	for _, c := range meta.AvailableCommands {
+		installed[c] = true
+	}
+	for _, tool := range missing {
+		alt, ok := system.Alternatives[tool]
+		if ok && installed[strings.Fields(alt)[0]] {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not installed, try %s instead\n", tool, alt)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not installed\n", tool)
+		}
+	}
+
+	if strictToolsFlag && executing {
+		fmt.Fprintln(os.Stderr, "Refusing to execute: --strict-tools is set and the command uses tools that are not installed")
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&strictToolsFlag, "strict-tools", false, "Refuse to execute commands that use tools which are not installed")
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/undo"
+)
+
+var undoListFlag bool
+
+var undoCmd = &cobra.Command{
+	Use:   "undo [id]",
+	Short: "Restore the files changed by an executed command",
+	Long: `Restore the snapshot taken before an executed command ran.
+Without an id, the most recent execution that has not been undone is reverted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		j, err := undo.NewJournal()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening undo journal: %v\n", err)
+			os.Exit(1)
+		}
+
+		if undoListFlag {
+			listUndo(j)
+			return
+		}
+
+		var entry *undo.Entry
+		if len(args) == 1 {
+			entry, err = j.Load(args[0])
+		} else {
+			entry, err = j.Latest()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		summary, err := j.Restore(entry.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring %s: %v\n", entry.ID, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Reverted %s: %s\n", entry.ID, entry.Command)
+		printPaths("Created (removed)", summary.Created)
+		printPaths("Modified (restored)", summary.Modified)
+		printPaths("Deleted (recreated)", summary.Deleted)
+		if len(summary.Created)+len(summary.Modified)+len(summary.Deleted) == 0 {
+			fmt.Println("Nothing had changed.")
+		}
+	},
+}
+
+func listUndo(j *undo.Journal) {
+	entries, err := j.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading undo journal: %v\n", err)
+		os.Exit(1)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No executions recorded.")
+		return
+	}
+	for _, e := range entries {
+		status := ""
+		if e.Undone {
+			status = " (undone)"
+		}
+		fmt.Printf("%s  %d path(s)%s  %s\n", e.ID, len(e.Files), status, e.Command)
+	}
+}
+
+func printPaths(label string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", label)
+	for _, p := range paths {
+		fmt.Printf("  %s\n", p)
+	}
+}
+
+// takeSnapshot journals the execution and saves the files it is predicted to
+// touch so that 'cmdfy undo' can bring them back. It only warns on failure,
+// returning nil. Pass the entry to sealSnapshot once the command is done.
+func takeSnapshot(result *model.CommandResult, command string) *undo.Entry {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	paths := safety.AffectedPaths(result, dir)
+
+	j, err := undo.NewJournal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to open undo journal: %v\n", err)
+		return nil
+	}
+	entry, err := j.Snapshot(command, dir, paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to snapshot files: %v\n", err)
+		return nil
+	}
+
+	if len(entry.Files) > 0 {
+		fmt.Printf("Saved %d path(s) before running. Revert with 'cmdfy undo %s'.\n", len(paths)-len(entry.Skipped), entry.ID)
+	}
+	for _, p := range entry.Skipped {
+		fmt.Fprintf(os.Stderr, "Warning: %s is too large to snapshot and can't be undone\n", p)
+	}
+	return entry
+}
+
+// sealSnapshot records what the command created, so that undo removes that
+// and nothing written afterwards
+func sealSnapshot(entry *undo.Entry) {
+	if entry == nil {
+		return
+	}
+	j, err := undo.NewJournal()
+	if err == nil {
+		err = j.Seal(entry.ID)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to record created files: %v\n", err)
+	}
+}
+
+func init() {
+	undoCmd.Flags().BoolVarP(&undoListFlag, "list", "l", false, "List recorded executions")
+	rootCmd.AddCommand(undoCmd)
+}
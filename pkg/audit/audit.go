@@ -0,0 +1,263 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// Record is one executed command. Every record carries the hash of the one
+// before it, so editing or removing a record breaks the chain.
+type Record struct {
+	Seq        int             `json:"seq"`
+	Timestamp  time.Time       `json:"timestamp"` // When the run started
+	Query      string          `json:"query"`
+	Command    string          `json:"command"`
+	Provider   string          `json:"provider,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Dir        string          `json:"cwd"`
+	User       string          `json:"user"`
+	ExitCode   int             `json:"exit_code"` // -1 when the command could not be started
+	DurationMS int64           `json:"duration_ms"`
+	Risk       model.RiskLevel `json:"risk,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// Duration returns how long the command ran
+func (r Record) Duration() time.Duration {
+	return time.Duration(r.DurationMS) * time.Millisecond
+}
+
+// computeHash hashes the record with its own Hash field left empty
+func (r Record) computeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is an append-only, hash-chained JSONL file. The sequence number and
+// hash of its last record are kept in a separate head file, so removing
+// records from the end of the log is detected too.
+type Log struct {
+	filePath string
+	headPath string
+}
+
+// head anchors the end of the chain
+type head struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// NewLog opens the audit log in ~/.cmdfy/audit.jsonl
+func NewLog() (*Log, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %w", err)
+	}
+	return NewLogAt(filepath.Join(home, ".cmdfy", "audit.jsonl"))
+}
+
+// NewLogAt opens an audit log stored at path
+func NewLogAt(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit dir: %w", err)
+	}
+	return &Log{filePath: path, headPath: path + ".head"}, nil
+}
+
+// Append chains the record to the last one and writes it. Seq, PrevHash and
+// Hash are filled in. The log is locked while it is read and appended to, so
+// concurrent runs can't fork the chain.
+func (l *Log) Append(rec Record) (Record, error) {
+	f, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return rec, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if err := lock(f); err != nil {
+		return rec, fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer unlock(f)
+
+	records, err := l.List()
+	if err != nil {
+		return rec, err
+	}
+	rec.Seq = 1
+	rec.PrevHash = ""
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		rec.Seq = last.Seq + 1
+		rec.PrevHash = last.Hash
+	}
+	if rec.Hash, err = rec.computeHash(); err != nil {
+		return rec, err
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return rec, fmt.Errorf("failed to write to audit log: %w", err)
+	}
+	if err := l.writeHead(head{Seq: rec.Seq, Hash: rec.Hash}); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// writeHead replaces the head file, through a rename so it is never left
+// half written
+func (l *Log) writeHead(h head) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit head: %w", err)
+	}
+	tmp := l.headPath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	if err := os.Rename(tmp, l.headPath); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	return nil
+}
+
+// readHead returns the head file, or nil when there is none
+func (l *Log) readHead() (*head, error) {
+	data, err := os.ReadFile(l.headPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit head: %w", err)
+	}
+	var h head
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("malformed audit head: %w", err)
+	}
+	return &h, nil
+}
+
+// List returns every record, oldest first. Unlike the brain, a malformed
+// line is an error: the log must be read exactly as written.
+func (l *Log) List() ([]Record, error) {
+	f, err := os.Open(l.filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return nil, fmt.Errorf("malformed audit record on line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+	return records, nil
+}
+
+// Get returns the record with the given sequence number
+func (l *Log) Get(seq int) (*Record, error) {
+	records, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if rec.Seq == seq {
+			return &rec, nil
+		}
+	}
+	return nil, fmt.Errorf("no audit record %d", seq)
+}
+
+// VerifyError points at the first record that breaks the chain
+type VerifyError struct {
+	Seq    int
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("audit record %d: %s", e.Seq, e.Reason)
+}
+
+// Verify walks the chain and returns the number of valid records. It fails
+// with a *VerifyError at the first record that was edited, removed or
+// inserted out of order, and when the log doesn't end where the head file
+// says it does. Anyone able to rewrite both files can still forge a whole
+// new chain; the log makes tampering evident, it doesn't prevent it.
+func (l *Log) Verify() (int, error) {
+	records, err := l.List()
+	if err != nil {
+		return 0, err
+	}
+	h, err := l.readHead()
+	if err != nil {
+		return 0, err
+	}
+
+	prevHash := ""
+	for i, rec := range records {
+		if rec.Seq != i+1 {
+			return i, &VerifyError{Seq: rec.Seq, Reason: fmt.Sprintf("expected sequence number %d", i+1)}
+		}
+		if rec.PrevHash != prevHash {
+			return i, &VerifyError{Seq: rec.Seq, Reason: "does not link to the previous record"}
+		}
+		hash, err := rec.computeHash()
+		if err != nil {
+			return i, err
+		}
+		if hash != rec.Hash {
+			return i, &VerifyError{Seq: rec.Seq, Reason: "content does not match its hash"}
+		}
+		prevHash = rec.Hash
+	}
+
+	n := len(records)
+	switch {
+	case h == nil && n > 0:
+		return n, &VerifyError{Seq: n, Reason: "the audit head is missing, so records removed from the end can't be detected"}
+	case h == nil:
+		return 0, nil
+	case h.Seq > n:
+		return n, &VerifyError{Seq: n + 1, Reason: fmt.Sprintf("missing, the log ends at record %d but its head is record %d", n, h.Seq)}
+	case h.Seq < n:
+		return h.Seq, &VerifyError{Seq: h.Seq + 1, Reason: "was added without updating the audit head"}
+	case n > 0 && h.Hash != prevHash:
+		return n - 1, &VerifyError{Seq: n, Reason: "does not match the audit head"}
+	}
+	return n, nil
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLog(t *testing.T) (*Log, string) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogAt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"ls -la", "rm notes.txt", "make test"} {
+		if _, err := l.Append(Record{Timestamp: time.Now(), Query: "q", Command: cmd, User: "dev", Dir: "/src", DurationMS: 12}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	return l, path
+}
+
+func TestAppendAndVerify(t *testing.T) {
+	l, _ := newTestLog(t)
+
+	records, err := l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	if records[0].PrevHash != "" || records[1].PrevHash != records[0].Hash || records[2].Seq != 3 {
+		t.Errorf("Expected records to be chained, got %+v", records)
+	}
+
+	n, err := l.Verify()
+	if err != nil || n != 3 {
+		t.Errorf("Expected 3 valid records, got %d, %v", n, err)
+	}
+
+	rec, err := l.Get(2)
+	if err != nil || rec.Command != "rm notes.txt" {
+		t.Errorf("Expected record 2, got %+v, %v", rec, err)
+	}
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		seq    int
+	}{
+		{"edited", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "rm notes.txt", "ls", 1)
+			return lines
+		}, 2},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, 3},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, 3},
+		{"truncated", func(lines []string) []string {
+			return lines[:2]
+		}, 3},
+		{"emptied", func(lines []string) []string {
+			return nil
+		}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, path := newTestLog(t)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = l.Verify()
+			var verr *VerifyError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected a VerifyError, got %v", err)
+			}
+			if verr.Seq != tt.seq {
+				t.Errorf("Expected record %d to be flagged, got %d (%s)", tt.seq, verr.Seq, verr.Reason)
+			}
+		})
+	}
+}
+
+func TestList_Empty(t *testing.T) {
+	l, err := NewLogAt(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := l.Verify(); n != 0 || err != nil {
+		t.Errorf("Expected an empty log to verify, got %d, %v", n, err)
+	}
+}
+
+func TestVerify_MissingHead(t *testing.T) {
+	l, path := newTestLog(t)
+	if err := os.Remove(path + ".head"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := l.Verify()
+	var verr *VerifyError
+	if !errors.As(err, &verr) || n != 3 {
+		t.Errorf("Expected a VerifyError after 3 records, got %d, %v", n, err)
+	}
+}
+
+func TestAppend_Concurrent(t *testing.T) {
+	l, err := NewLogAt(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Append(Record{Timestamp: time.Now(), Command: "true"}); err != nil {
+				t.Errorf("Append failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := l.Verify(); n != 20 || err != nil {
+		t.Errorf("Expected 20 valid records, got %d, %v", n, err)
+	}
+}
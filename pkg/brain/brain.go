@@ -8,17 +8,40 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
 )
 
 // BrainEntry represents a single record in the brain
 type BrainEntry struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Query       string    `json:"query"`
-	Context     string    `json:"context,omitempty"` // Captured stdin / error logs
-	Command     string    `json:"command"`
-	Explanation string    `json:"explanation"`
-	Provider    string    `json:"provider"`
-	Model       string    `json:"model"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Query       string          `json:"query"`
+	Context     string          `json:"context,omitempty"` // Captured stdin / error logs
+	Command     string          `json:"command"`
+	Explanation string          `json:"explanation"`
+	Provider    string          `json:"provider"`
+	Model       string          `json:"model"`
+	Risk        model.RiskLevel `json:"risk,omitempty"` // Empty for entries recorded before risk levels existed
+	// Template is the command with its {{name}} placeholders, for reuse with
+	// other values. Empty when the command had no parameters.
+	Template   string            `json:"template,omitempty"`
+	Parameters []model.Parameter `json:"parameters,omitempty"`
+	// Rejected marks a candidate the user passed over for another one. It is
+	// never offered as an example, only used to rank later candidates down.
+	Rejected bool `json:"rejected,omitempty"`
+}
+
+// Result parses the stored command line back into structured steps
+func (e BrainEntry) Result() (*model.CommandResult, error) {
+	result, err := model.Parse(e.Command)
+	if err != nil {
+		return nil, err
+	}
+	result.Explanation = e.Explanation
+	if e.Risk != "" {
+		result.Risk.Level = e.Risk
+	}
+	return result, nil
 }
 
 // Brain handles the persistence and retrieval of command history
@@ -74,6 +97,52 @@ func (b *Brain) Record(entry BrainEntry) error {
 // For now, it returns the most recent entries.
 // In the future, we can implement semantic search or fuzzy matching.
 func (b *Brain) GetExamples(query string, limit int) ([]BrainEntry, error) {
+	all, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]BrainEntry, 0, len(all))
+	for _, entry := range all {
+		if !entry.Rejected {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Reverse to get most recent first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	// Filter or rank?
+	// For simplicity in this phase, we just return the most recent `limit` entries.
+	// We can add simple keyword matching later.
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
+}
+
+// RejectedCommands returns the commands the user passed over for the same
+// query before
+func (b *Brain) RejectedCommands(query string) (map[string]bool, error) {
+	entries, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+
+	rejected := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Rejected && entry.Query == query {
+			rejected[entry.Command] = true
+		}
+	}
+	return rejected, nil
+}
+
+// load reads every entry, oldest first
+func (b *Brain) load() ([]BrainEntry, error) {
 	f, err := os.Open(b.filePath)
 	if os.IsNotExist(err) {
 		return []BrainEntry{}, nil
@@ -105,18 +174,5 @@ func (b *Brain) GetExamples(query string, limit int) ([]BrainEntry, error) {
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error reading brain file: %w", err)
 	}
-
-	// Reverse to get most recent first
-	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
-		entries[i], entries[j] = entries[j], entries[i]
-	}
-
-	// Filter or rank?
-	// For simplicity in this phase, we just return the most recent `limit` entries.
-	// We can add simple keyword matching later.
-	if len(entries) > limit {
-		entries = entries[:limit]
-	}
-
 	return entries, nil
 }
@@ -0,0 +1,188 @@
+package history
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvVar is set by the shell-init hook to the last command the user ran
+const EnvVar = "CMDFY_LAST_COMMAND"
+
+// LastCommand returns the last command run in the given shell (bash, zsh or
+// fish), other than cmdfy itself. The shell-init hook's environment variable
+// is preferred, as shells often write their history file only on exit.
+func LastCommand(shell string) (string, error) {
+	if cmd := strings.TrimSpace(os.Getenv(EnvVar)); cmd != "" && !isCmdfy(cmd) {
+		return cmd, nil
+	}
+
+	path, err := File(shell)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var commands []string
+	switch shell {
+	case "bash":
+		commands = ParseBash(data)
+	case "zsh":
+		commands = ParseZsh(data)
+	case "fish":
+		commands = ParseFish(data)
+	}
+
+	for i := len(commands) - 1; i >= 0; i-- {
+		if cmd := strings.TrimSpace(commands[i]); cmd != "" && !isCmdfy(cmd) {
+			return cmd, nil
+		}
+	}
+	return "", fmt.Errorf("no command found in %s", path)
+}
+
+// File returns the history file of the shell, honouring $HISTFILE for bash
+// and zsh and $XDG_DATA_HOME for fish
+func File(shell string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home dir: %w", err)
+	}
+
+	switch shell {
+	case "bash", "zsh":
+		if f := os.Getenv("HISTFILE"); f != "" {
+			return f, nil
+		}
+		if shell == "bash" {
+			return filepath.Join(home, ".bash_history"), nil
+		}
+		return filepath.Join(home, ".zsh_history"), nil
+	case "fish":
+		data := os.Getenv("XDG_DATA_HOME")
+		if data == "" {
+			data = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(data, "fish", "fish_history"), nil
+	}
+	return "", fmt.Errorf("reading the history of %s is not supported, use the cmdfy shell-init hook", shell)
+}
+
+// isCmdfy reports whether the command runs cmdfy, which is never the one to fix
+func isCmdfy(cmd string) bool {
+	fields := strings.Fields(cmd)
+	return len(fields) > 0 && filepath.Base(fields[0]) == "cmdfy"
+}
+
+// ParseBash splits a ~/.bash_history, skipping the "#<epoch>" lines written
+// with HISTTIMEFORMAT set
+func ParseBash(data []byte) []string {
+	var commands []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if isTimestamp(line) || strings.TrimSpace(line) == "" {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	return commands
+}
+
+func isTimestamp(line string) bool {
+	if len(line) < 2 || line[0] != '#' {
+		return false
+	}
+	for _, c := range line[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseZsh splits a ~/.zsh_history in either the plain or the extended
+// ": <start>:<elapsed>;command" format. Lines ending in a backslash continue
+// a multi-line command.
+func ParseZsh(data []byte) []string {
+	data = unmetafy(data)
+
+	var commands []string
+	var current []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(current) == 0 {
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, ": ") {
+				if i := strings.IndexByte(line, ';'); i >= 0 {
+					line = line[i+1:]
+				}
+			}
+		}
+		if strings.HasSuffix(line, `\`) {
+			current = append(current, strings.TrimSuffix(line, `\`))
+			continue
+		}
+		current = append(current, line)
+		commands = append(commands, strings.Join(current, "\n"))
+		current = nil
+	}
+	if len(current) > 0 {
+		commands = append(commands, strings.Join(current, "\n"))
+	}
+	return commands
+}
+
+// unmetafy undoes zsh's escaping of bytes >= 0x83 in the history file
+func unmetafy(data []byte) []byte {
+	const meta = 0x83
+	if !bytes.Contains(data, []byte{meta}) {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == meta && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^32)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// ParseFish reads the "- cmd: ..." entries of fish's history file
+func ParseFish(data []byte) []string {
+	var commands []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if cmd, ok := strings.CutPrefix(line, "- cmd: "); ok {
+			commands = append(commands, unescapeFish(cmd))
+		}
+	}
+	return commands
+}
+
+// unescapeFish decodes the \\ and \n escapes fish writes in its history
+func unescapeFish(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				sb.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
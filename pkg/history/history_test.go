@@ -0,0 +1,62 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseBash(t *testing.T) {
+	data := "ls -la\n#1700000000\ngit status\n\nmake test\n"
+	want := []string{"ls -la", "git status", "make test"}
+	if got := ParseBash([]byte(data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestParseZsh(t *testing.T) {
+	data := ": 1700000000:0;ls -la\n: 1700000005:2;for f in *; do\\\n  echo $f\\\ndone\nplain command\n"
+	want := []string{"ls -la", "for f in *; do\n  echo $f\ndone", "plain command"}
+	if got := ParseZsh([]byte(data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// "é" is 0xC3 0xA9, which zsh stores metafied as 0x83 0xE3 0x83 0x89
+	meta := []byte(": 1:0;echo caf\x83\xe3\x83\x89\n")
+	if got := ParseZsh(meta); len(got) != 1 || got[0] != "echo café" {
+		t.Errorf("Expected the command to be unmetafied, got %q", got)
+	}
+}
+
+func TestParseFish(t *testing.T) {
+	data := "- cmd: ls -la\n  when: 1700000000\n- cmd: echo a\\nb \\\\ c\n  when: 1700000001\n  paths:\n    - a\n"
+	want := []string{"ls -la", "echo a\nb \\ c"}
+	if got := ParseFish([]byte(data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestLastCommand(t *testing.T) {
+	dir := t.TempDir()
+	histfile := filepath.Join(dir, "history")
+	if err := os.WriteFile(histfile, []byte("make build\ncmdfy fix\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HISTFILE", histfile)
+
+	t.Setenv(EnvVar, "")
+	if got, err := LastCommand("bash"); err != nil || got != "make build" {
+		t.Errorf("Expected the last command other than cmdfy, got %q, %v", got, err)
+	}
+
+	t.Setenv(EnvVar, "go test ./...")
+	if got, err := LastCommand("bash"); err != nil || got != "go test ./..." {
+		t.Errorf("Expected the hook's command to win, got %q, %v", got, err)
+	}
+
+	t.Setenv(EnvVar, "")
+	if _, err := LastCommand("powershell"); err == nil {
+		t.Error("Expected an error for a shell without history support")
+	}
+}
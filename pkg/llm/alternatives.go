@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// AlternativesProvider is implemented by providers that can return several
+// candidates from a single request
+type AlternativesProvider interface {
+	// GenerateAlternatives returns up to n candidate commands, which may repeat
+	GenerateAlternatives(ctx context.Context, query string, meta SystemMetadata, n int) ([]*model.CommandResult, error)
+}
+
+// GenerateAlternatives asks p for up to n distinct commands. Providers that
+// implement AlternativesProvider are asked for all of them at once. The rest,
+// and any shortfall once duplicates are dropped, are sampled one at a time,
+// at most 2n times, each time told which commands were already suggested.
+// An error is only returned when no command was generated at all.
+func GenerateAlternatives(ctx context.Context, p Provider, query string, meta SystemMetadata, n int) ([]*model.CommandResult, error) {
+	meta.Alternatives = n
+	shell := model.ShellFromPath(meta.Shell)
+
+	var results []*model.CommandResult
+	seen := make(map[string]bool)
+	add := func(r *model.CommandResult) {
+		line := r.Render(shell)
+		if len(results) < n && !seen[line] {
+			seen[line] = true
+			results = append(results, r)
+			meta.AvoidCommands = append(meta.AvoidCommands, line)
+		}
+	}
+
+	var firstErr error
+	if ap, ok := p.(AlternativesProvider); ok {
+		batch, err := ap.GenerateAlternatives(ctx, query, meta, n)
+		if err != nil {
+			firstErr = err
+		}
+		for _, r := range batch {
+			add(r)
+		}
+	}
+
+	for attempt := 0; len(results) < n && attempt < 2*n; attempt++ {
+		r, err := p.GenerateCommand(ctx, query, meta)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		add(r)
+	}
+
+	if len(results) == 0 {
+		return nil, firstErr
+	}
+	return results, nil
+}
@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// sampler returns its commands in turn, recording what it was asked to avoid
+type sampler struct {
+	tools []string
+	calls int
+	avoid [][]string
+}
+
+func (s *sampler) GenerateCommand(_ context.Context, _ string, meta SystemMetadata) (*model.CommandResult, error) {
+	s.avoid = append(s.avoid, append([]string(nil), meta.AvoidCommands...))
+	if s.calls >= len(s.tools) {
+		return nil, errors.New("out of samples")
+	}
+	tool := s.tools[s.calls]
+	s.calls++
+	return &model.CommandResult{Steps: []model.CommandStep{{Tool: tool}}}, nil
+}
+
+func (s *sampler) ExplainCommand(context.Context, string, SystemMetadata) (*model.CommandExplanation, error) {
+	return nil, errors.New("not implemented")
+}
+
+// batcher answers GenerateAlternatives with a fixed batch
+type batcher struct {
+	sampler
+	batch []string
+}
+
+func (b *batcher) GenerateAlternatives(_ context.Context, _ string, meta SystemMetadata, n int) ([]*model.CommandResult, error) {
+	if meta.Alternatives != n {
+		return nil, errors.New("alternatives not set")
+	}
+	var out []*model.CommandResult
+	for _, tool := range b.batch {
+		out = append(out, &model.CommandResult{Steps: []model.CommandStep{{Tool: tool}}})
+	}
+	return out, nil
+}
+
+func tools(results []*model.CommandResult) []string {
+	var out []string
+	for _, r := range results {
+		out = append(out, r.Steps[0].Tool)
+	}
+	return out
+}
+
+func TestGenerateAlternatives_Sampling(t *testing.T) {
+	s := &sampler{tools: []string{"find", "find", "fd", "locate"}}
+	results, err := GenerateAlternatives(context.Background(), s, "q", SystemMetadata{Shell: "/bin/bash"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tools(results); len(got) != 3 || got[0] != "find" || got[1] != "fd" || got[2] != "locate" {
+		t.Errorf("Expected the duplicate to be dropped, got %v", got)
+	}
+	if len(s.avoid[1]) != 1 || s.avoid[1][0] != "find" {
+		t.Errorf("Expected the second sample to avoid the first, got %v", s.avoid[1])
+	}
+}
+
+func TestGenerateAlternatives_Batch(t *testing.T) {
+	b := &batcher{batch: []string{"find", "find"}, sampler: sampler{tools: []string{"fd"}}}
+	results, err := GenerateAlternatives(context.Background(), b, "q", SystemMetadata{Shell: "/bin/bash"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tools(results); len(got) != 2 || got[0] != "find" || got[1] != "fd" {
+		t.Errorf("Expected the batch to be topped up by sampling, got %v", got)
+	}
+}
+
+func TestGenerateAlternatives_Errors(t *testing.T) {
+	s := &sampler{tools: []string{"find"}}
+	results, err := GenerateAlternatives(context.Background(), s, "q", SystemMetadata{Shell: "/bin/bash"}, 3)
+	if err != nil || len(results) != 1 {
+		t.Errorf("Expected the one command that was generated, got %v, %v", results, err)
+	}
+
+	if _, err := GenerateAlternatives(context.Background(), &sampler{}, "q", SystemMetadata{}, 2); err == nil {
+		t.Error("Expected an error when nothing was generated")
+	}
+}
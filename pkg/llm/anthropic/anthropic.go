@@ -1,24 +1,27 @@
 package anthropic
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-
 	"time"
 
 	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
 	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/prompt"
 )
 
 const (
 	defaultBaseURL = "https://api.anthropic.com/v1/messages"
 	defaultModel   = "claude-3-5-sonnet-latest"
 	apiVersion     = "2023-06-01"
+	// responseTool is the tool the model is made to call with its answer,
+	// which holds the answer to the tool's input schema
+	responseTool = "respond"
 )
 
 type AnthropicProvider struct {
@@ -26,6 +29,10 @@ type AnthropicProvider struct {
 	model   string
 	baseURL string
 	client  *http.Client
+	prompts *prompt.Templates
+	// promptOnly is set once the model rejected the response tool, after
+	// which only the prompt asks for JSON
+	promptOnly bool
 }
 
 // NewAnthropicProvider creates a new instance of AnthropicProvider
@@ -40,11 +47,17 @@ func NewAnthropicProvider(config llm.ProviderConfig) (llm.Provider, error) {
 		modelName = defaultModel
 	}
 
+	prompts, err := prompt.Load()
+	if err != nil {
+		return nil, err
+	}
+
 	return &AnthropicProvider{
 		apiKey:  config.APIKey,
 		model:   modelName,
 		baseURL: baseURL,
 		client:  &http.Client{},
+		prompts: prompts,
 	}, nil
 }
 
@@ -53,24 +66,44 @@ func init() {
 	llm.RegisterProvider("claude", NewAnthropicProvider)
 }
 
+// maxTokens caps the length of a reply. A reply that reaches it is cut off
+// and can't be used, so it leaves room for long multi-step commands.
+const maxTokens = 4096
+
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
 type MessagesRequest struct {
-	Model     string    `json:"model"`
-	Messages  []Message `json:"messages"`
-	System    string    `json:"system,omitempty"`
-	MaxTokens int       `json:"max_tokens"`
+	Model      string      `json:"model"`
+	Messages   []Message   `json:"messages"`
+	System     string      `json:"system,omitempty"`
+	MaxTokens  int         `json:"max_tokens"`
+	Stream     bool        `json:"stream,omitempty"`
+	Tools      []Tool      `json:"tools,omitempty"`
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+}
+
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
 }
 
 type MessagesResponse struct {
 	Content []struct {
-		Text string `json:"text"`
-		Type string `json:"type"`
+		Text  string          `json:"text"`
+		Type  string          `json:"type"`
+		Input json.RawMessage `json:"input,omitempty"` // Set for tool_use
 	} `json:"content"`
-	Usage struct {
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
 		InputTokens  int `json:"input_tokens"`
 		OutputTokens int `json:"output_tokens"`
 	} `json:"usage"`
@@ -80,121 +113,270 @@ type MessagesResponse struct {
 	} `json:"error,omitempty"`
 }
 
+// StreamEvent is the data of one server-sent event of a streamed response.
+// Only the fields cmdfy reads are decoded.
+type StreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
 func (p *AnthropicProvider) GenerateCommand(ctx context.Context, query string, meta llm.SystemMetadata) (*model.CommandResult, error) {
-	commandsList := strings.Join(meta.AvailableCommands, ", ")
-	filesList := strings.Join(meta.CurrentDirFiles, ", ")
+	return p.generate(ctx, query, meta, nil)
+}
+
+// GenerateCommandStream generates a command using Anthropic, handing out the
+// response as it is generated
+func (p *AnthropicProvider) GenerateCommandStream(ctx context.Context, query string, meta llm.SystemMetadata, onToken func(string)) (*model.CommandResult, error) {
+	return p.generate(ctx, query, meta, onToken)
+}
+
+// generate asks for a command, streaming the reply to onToken unless it is nil
+func (p *AnthropicProvider) generate(ctx context.Context, query string, meta llm.SystemMetadata, onToken func(string)) (*model.CommandResult, error) {
+	pr, err := p.prompts.Generate(query, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	onToken, firstToken := llm.TimeFirstToken(startTime, onToken)
 
-	previousErrorSection := ""
-	if meta.PreviousError != "" {
-		previousErrorSection = fmt.Sprintf("\n\nTHE USER IS TRYING TO FIX A COMMAND THAT FAILED.\nError output:\n%s\n\nAnalyze this error and generate a fixed command.", meta.PreviousError)
+	text, tokens, err := p.complete(ctx, pr.System, pr.User, llm.CommandSchema, onToken)
+	if err != nil {
+		return nil, err
 	}
 
-	examplesSection := ""
-	if len(meta.FewShotExamples) > 0 {
-		examplesSection = "\n\nReference - Here are similar commands the user has used before:\n"
-		for _, ex := range meta.FewShotExamples {
-			examplesSection += fmt.Sprintf("- Query: %s\n  Command: %s\n  Origin: %s\n", ex.Query, ex.Command, ex.Provider)
-		}
+	var result model.CommandResult
+	err = llm.ParseOrRetry(text, llm.CommandSchema, &result, func(problem error) (string, error) {
+		text, more, err := p.repair(ctx, pr, text, problem, llm.CommandSchema, onToken)
+		tokens += more
+		return text, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latency := time.Since(startTime)
+
+	result.Metrics = model.Metrics{
+		Latency:    latency.Round(time.Millisecond).String(),
+		TokenCount: tokens,
+	}
+	if ttft := firstToken(); ttft > 0 {
+		result.Metrics.TimeToFirstToken = ttft.Round(time.Millisecond).String()
+	}
+
+	return &result, nil
+}
+
+// ExplainCommand explains an existing command using Anthropic
+func (p *AnthropicProvider) ExplainCommand(ctx context.Context, command string, meta llm.SystemMetadata) (*model.CommandExplanation, error) {
+	pr, err := p.prompts.Explain(command, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+
+	text, tokens, err := p.complete(ctx, pr.System, pr.User, llm.ExplanationSchema, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var exp model.CommandExplanation
+	err = llm.ParseOrRetry(text, llm.ExplanationSchema, &exp, func(problem error) (string, error) {
+		text, more, err := p.repair(ctx, pr, text, problem, llm.ExplanationSchema, nil)
+		tokens += more
+		return text, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	systemPrompt := fmt.Sprintf(`
-You are a command line expert. 
-Your task is to translate the following natural language request into a shell command or a pipeline of commands.
-Respond ONLY with a valid JSON object matching this schema:
-{
-  "steps": [
-    {
-      "tool": "string (the primary command, e.g. git, grep)",
-      "args": ["string", "arguments"],
-      "op": "string (operator to connect to next step: | (pipe), && (and), ; (seq), || (or), > (redirect), >> (append). Empty for last step.)"
-    }
-  ],
-  "explanation": "string (brief explanation of the entire pipeline)",
-  "dangerous": boolean (true if ANY step modifies files significantly, deletes data, or has destructive side effects)
-}
-
-Operating System: %s
-Shell: %s
-Available Tools: %s
-Current Directory Files: %s%s%s
-`, meta.OS, meta.Shell, commandsList, filesList, examplesSection, previousErrorSection)
+	latency := time.Since(startTime)
+
+	exp.Metrics = model.Metrics{
+		Latency:    latency.Round(time.Millisecond).String(),
+		TokenCount: tokens,
+	}
+
+	return &exp, nil
+}
+
+// repair asks again for a reply that could not be used, telling the model
+// what was wrong with it
+func (p *AnthropicProvider) repair(ctx context.Context, pr prompt.Prompt, text string, problem error, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	repair, err := p.prompts.Repair(pr, text, problem)
+	if err != nil {
+		return "", 0, err
+	}
+	return p.complete(ctx, repair.System, repair.User, schema, onToken)
+}
+
+// complete sends a system prompt and a user message and returns the reply
+// and the number of tokens used. The model is made to answer through a tool
+// whose input schema is schema, and the tool input is the reply. Models that
+// reject the tool are asked again with the prompt alone. With onToken set the
+// reply is streamed, and each piece is passed to it as it arrives.
+func (p *AnthropicProvider) complete(ctx context.Context, system, message string, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	if p.promptOnly {
+		schema = nil
+	}
 
 	userMessage := Message{
 		Role:    "user",
-		Content: query,
+		Content: message,
 	}
 
 	reqBody := MessagesRequest{
 		Model:     p.model,
 		Messages:  []Message{userMessage},
-		System:    systemPrompt,
-		MaxTokens: 1024,
+		System:    system,
+		MaxTokens: maxTokens,
+		Stream:    onToken != nil,
+	}
+	if schema != nil {
+		reqBody.Tools = []Tool{{
+			Name:        responseTool,
+			Description: "Return the answer in the required structure",
+			InputSchema: schema.JSON(),
+		}}
+		reqBody.ToolChoice = &ToolChoice{Type: "tool", Name: responseTool}
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return "", 0, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	header := http.Header{
+		"Content-Type":      {"application/json"},
+		"X-Api-Key":         {p.apiKey},
+		"Anthropic-Version": {apiVersion},
+	}
+	resp, err := llm.Post(ctx, p.client, "anthropic", p.baseURL, header, jsonBody)
+	if schema != nil && llm.Rejected(err, "tool", "input_schema") {
+		p.promptOnly = true
+		return p.complete(ctx, system, message, nil, onToken)
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", p.apiKey)
-	req.Header.Set("anthropic-version", apiVersion)
-
-	startTime := time.Now()
-
-	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	if onToken != nil {
+		return readStream(resp.Body, onToken)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("anthropic api error (status %d): %s", resp.StatusCode, string(bodyBytes))
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, llm.RequestError("anthropic", err)
 	}
 
 	var response MessagesResponse
 	if err := json.Unmarshal(bodyBytes, &response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return "", 0, llm.BadResponse("anthropic", fmt.Sprintf("failed to decode response: %v", err))
 	}
 
-	latency := time.Since(startTime)
-
 	if response.Error != nil {
-		return nil, fmt.Errorf("anthropic api error: %s - %s", response.Error.Type, response.Error.Message)
+		return "", 0, streamError(response.Error.Type, response.Error.Message)
 	}
 
-	if len(response.Content) == 0 {
-		return nil, fmt.Errorf("empty response from anthropic")
+	if response.StopReason == "max_tokens" {
+		return "", 0, llm.CutOff("anthropic")
+	}
+	tokens := response.Usage.InputTokens + response.Usage.OutputTokens
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "tool_use" {
+			return string(block.Input), tokens, nil
+		}
+		text.WriteString(block.Text)
 	}
 
-	text := response.Content[0].Text
+	if text.Len() == 0 {
+		return "", 0, llm.BadResponse("anthropic", "empty response")
+	}
+	return text.String(), tokens, nil
+}
 
-	// Sanitize output (remove markdown blocks if present)
-	text = strings.TrimSpace(text)
-	text = strings.TrimPrefix(text, "```json")
-	text = strings.TrimPrefix(text, "```")
-	text = strings.TrimSuffix(text, "```")
-	text = strings.TrimSpace(text)
+// readStream reads a server-sent event stream, passing each piece of text or
+// tool input to onToken, and returns the whole reply and the number of tokens
+// used
+func readStream(r io.Reader, onToken func(string)) (string, int, error) {
+	var sb strings.Builder
+	var tokens int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
 
-	var result model.CommandResult
-	if err := json.Unmarshal([]byte(text), &result); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w\nResponse was: %s", err, text)
-	}
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return "", 0, llm.BadResponse("anthropic", fmt.Sprintf("failed to decode stream event: %v", err))
+		}
 
-	result.Metrics = model.Metrics{
-		Latency:    latency.Round(time.Millisecond).String(),
-		TokenCount: response.Usage.InputTokens + response.Usage.OutputTokens,
+		switch event.Type {
+		case "message_start":
+			tokens += event.Message.Usage.InputTokens
+		case "content_block_delta":
+			piece := event.Delta.Text
+			if event.Delta.Type == "input_json_delta" {
+				piece = event.Delta.PartialJSON
+			}
+			if piece != "" {
+				sb.WriteString(piece)
+				onToken(piece)
+			}
+		case "message_delta":
+			if event.Delta.StopReason == "max_tokens" {
+				return "", 0, llm.CutOff("anthropic")
+			}
+			tokens += event.Usage.OutputTokens
+		case "message_stop":
+			if sb.Len() == 0 {
+				return "", 0, llm.BadResponse("anthropic", "empty response")
+			}
+			return sb.String(), tokens, nil
+		case "error":
+			if event.Error != nil {
+				return "", 0, streamError(event.Error.Type, event.Error.Message)
+			}
+			return "", 0, streamError("", data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, llm.RequestError("anthropic", err)
 	}
+	return "", 0, llm.BadResponse("anthropic", "the stream ended before the response was done")
+}
 
-	return &result, nil
+// streamError is an error the API reported in the body of a response that
+// had started successfully, like an overloaded_error midway through a stream
+func streamError(errType, message string) *llm.ProviderError {
+	kind := llm.Classify(0, errType+" "+message)
+	if errType != "" {
+		message = errType + ": " + message
+	}
+	return &llm.ProviderError{Provider: "anthropic", Kind: kind, Message: message}
 }
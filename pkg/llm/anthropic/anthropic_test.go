@@ -0,0 +1,168 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+)
+
+func TestAnthropicProvider_GenerateCommand_ToolUse(t *testing.T) {
+	var received MessagesRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{
+			"content": [{"type": "tool_use", "name": "respond", "input": {"steps": [{"tool": "ls", "args": ["-a"]}], "explanation": "List all files", "risk": {"level": "none"}}}],
+			"usage": {"input_tokens": 10, "output_tokens": 5}
+		}`))
+	}))
+	defer ts.Close()
+
+	provider, err := NewAnthropicProvider(llm.ProviderConfig{BaseURL: ts.URL, APIKey: "key"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	result, err := provider.GenerateCommand(context.Background(), "list all files", llm.SystemMetadata{})
+	if err != nil {
+		t.Fatalf("GenerateCommand failed: %v", err)
+	}
+
+	if len(received.Tools) != 1 || received.ToolChoice == nil || received.ToolChoice.Name != received.Tools[0].Name {
+		t.Errorf("Expected the model to be made to call the response tool, got %+v", received)
+	}
+	if !strings.Contains(string(received.Tools[0].InputSchema), `"steps"`) {
+		t.Errorf("Expected the command schema, got %s", received.Tools[0].InputSchema)
+	}
+	if result.Explanation != "List all files" || result.Steps[0].Tool != "ls" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.Metrics.TokenCount != 15 {
+		t.Errorf("Expected 15 tokens, got %d", result.Metrics.TokenCount)
+	}
+}
+
+func TestAnthropicProvider_GenerateCommand_ToolRejected(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req MessagesRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Tools) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"type": "error", "error": {"type": "invalid_request_error", "message": "tools are not supported"}}`))
+			return
+		}
+		w.Write([]byte(`{"content": [{"type": "text", "text": "{\"steps\": [{\"tool\": \"ls\"}], \"explanation\": \"List files\"}"}]}`))
+	}))
+	defer ts.Close()
+
+	provider, _ := NewAnthropicProvider(llm.ProviderConfig{BaseURL: ts.URL, APIKey: "key"})
+	result, err := provider.GenerateCommand(context.Background(), "list files", llm.SystemMetadata{})
+	if err != nil {
+		t.Fatalf("GenerateCommand failed: %v", err)
+	}
+	if requests != 2 || result.Steps[0].Tool != "ls" {
+		t.Errorf("Expected a retry without the tool, got %d requests and %+v", requests, result)
+	}
+}
+
+func TestAnthropicProvider_GenerateCommand_BadRequest(t *testing.T) {
+	// A bad request that has nothing to do with the tool is not retried
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type": "error", "error": {"type": "invalid_request_error", "message": "prompt is too long: 210000 tokens > 200000 maximum"}}`))
+	}))
+	defer ts.Close()
+
+	provider, _ := NewAnthropicProvider(llm.ProviderConfig{BaseURL: ts.URL, APIKey: "key"})
+	if _, err := provider.GenerateCommand(context.Background(), "list files", llm.SystemMetadata{}); err == nil {
+		t.Fatal("Expected the bad request to fail")
+	}
+	if requests != 1 || provider.(*AnthropicProvider).promptOnly {
+		t.Errorf("Expected no fallback, got %d requests", requests)
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	stream := `event: message_start
+data: {"type": "message_start", "message": {"usage": {"input_tokens": 25, "output_tokens": 1}}}
+
+event: content_block_start
+data: {"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}
+
+event: ping
+data: {"type": "ping"}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "{\"explanation\": "}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "\"Say hello\"}"}}
+
+event: content_block_stop
+data: {"type": "content_block_stop", "index": 0}
+
+event: message_delta
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 15}}
+
+event: message_stop
+data: {"type": "message_stop"}
+`
+	var tokens []string
+	text, count, err := readStream(strings.NewReader(stream), func(s string) { tokens = append(tokens, s) })
+	if err != nil {
+		t.Fatalf("readStream failed: %v", err)
+	}
+	if text != `{"explanation": "Say hello"}` {
+		t.Errorf("Unexpected text: %q", text)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("Expected 2 tokens, got %q", tokens)
+	}
+	if count != 40 {
+		t.Errorf("Expected 40 tokens, got %d", count)
+	}
+}
+
+func TestReadStream_ToolInput(t *testing.T) {
+	stream := `data: {"type": "content_block_start", "index": 0, "content_block": {"type": "tool_use", "name": "respond", "input": {}}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "input_json_delta", "partial_json": "{\"explanation\": \"Li"}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "input_json_delta", "partial_json": "st\"}"}}
+data: {"type": "message_stop"}
+`
+	text, _, err := readStream(strings.NewReader(stream), func(string) {})
+	if err != nil {
+		t.Fatalf("readStream failed: %v", err)
+	}
+	if text != `{"explanation": "List"}` {
+		t.Errorf("Unexpected text: %q", text)
+	}
+}
+
+func TestReadStream_Error(t *testing.T) {
+	stream := `event: error
+data: {"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}
+`
+	_, _, err := readStream(strings.NewReader(stream), func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Errorf("Expected the stream error, got %v", err)
+	}
+}
+
+func TestReadStream_MaxTokens(t *testing.T) {
+	stream := `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "input_json_delta", "partial_json": "{\"steps\": [{\"tool\": \"rm\", \"args\": [\"-rf\", \"/home/me/pro"}}
+data: {"type": "message_delta", "delta": {"stop_reason": "max_tokens"}, "usage": {"output_tokens": 4096}}
+data: {"type": "message_stop"}
+`
+	_, _, err := readStream(strings.NewReader(stream), func(string) {})
+	if !errors.Is(err, llm.ErrBadResponse) || !strings.Contains(err.Error(), "cut off") {
+		t.Errorf("Expected a cut off reply to be a bad response, got %v", err)
+	}
+}
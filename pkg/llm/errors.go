@@ -0,0 +1,225 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The kinds of provider failure the CLI tells apart. Check for them with
+// errors.Is.
+var (
+	ErrAuth          = errors.New("authentication failed")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrModelNotFound = errors.New("model not found")
+	ErrTimeout       = errors.New("request timed out")
+	ErrBadResponse   = errors.New("unusable response")
+)
+
+// ProviderError is a request to a provider that failed
+type ProviderError struct {
+	Provider string
+	// Kind is one of the Err values above, nil when none of them fits
+	Kind error
+	// Status is the HTTP status, 0 when no response came back
+	Status int
+	// Message is what the provider said, or the transport error
+	Message string
+	// RetryAfter is how long the provider asked to wait before trying
+	// again, 0 if it didn't say
+	RetryAfter time.Duration
+	// Err is the error this one was made from, if any
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Provider)
+	if e.Kind != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Kind.Error())
+	} else {
+		sb.WriteString(" request failed")
+	}
+	if e.Status != 0 {
+		fmt.Fprintf(&sb, " (status %d)", e.Status)
+	}
+	if e.Message != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Message)
+	}
+	return sb.String()
+}
+
+// Unwrap lets errors.Is match both the kind and the underlying error
+func (e *ProviderError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// Temporary reports whether trying again later may succeed: the provider
+// is rate limiting, overloaded or failing, or could not be reached
+func (e *ProviderError) Temporary() bool {
+	switch {
+	case e.Kind == ErrRateLimited, e.Kind == ErrTimeout:
+		return true
+	case e.Kind != nil:
+		return false
+	case e.Status == 0:
+		// Nothing listening won't change in a few seconds
+		return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, syscall.ECONNREFUSED)
+	}
+	return e.Status >= 500
+}
+
+// HTTPError classifies an unsuccessful response from a provider's API by
+// its status, the error in its body and its Retry-After header
+func HTTPError(provider string, status int, header http.Header, body []byte) *ProviderError {
+	code, message := errorBody(body)
+	return &ProviderError{
+		Provider:   provider,
+		Kind:       Classify(status, code+" "+message),
+		Status:     status,
+		Message:    message,
+		RetryAfter: RetryAfter(header),
+	}
+}
+
+// RequestError wraps an error that kept a request from getting a response.
+// Timeouts are told apart from the rest.
+func RequestError(provider string, err error) *ProviderError {
+	var netErr net.Error
+	kind := error(nil)
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		kind = ErrTimeout
+	}
+	return &ProviderError{Provider: provider, Kind: kind, Message: err.Error(), Err: err}
+}
+
+// BadResponse is a reply from provider that cmdfy could not read
+func BadResponse(provider, message string) *ProviderError {
+	return &ProviderError{Provider: provider, Kind: ErrBadResponse, Message: message}
+}
+
+// CutOff is the error for a reply that stopped at the provider's token
+// limit. Whatever it holds is unfinished, so it isn't used.
+func CutOff(provider string) *ProviderError {
+	return BadResponse(provider, "the reply was cut off at the token limit")
+}
+
+// Rejected reports whether err is a bad request that none of the kinds fit
+// and whose message mentions one of words. Providers answer that way when a
+// model doesn't support a part of the request, like a response schema, and
+// the words tell that part apart from other mistakes.
+func Rejected(err error, words ...string) bool {
+	var perr *ProviderError
+	if !errors.As(err, &perr) || perr.Status != http.StatusBadRequest || perr.Kind != nil {
+		return false
+	}
+	return containsAny(strings.ToLower(perr.Message), words...)
+}
+
+// Classify maps an HTTP status and the provider's description of the error
+// to one of the kinds, or nil when none fits. Providers word some failures
+// differently, so the description decides between quota and rate limits and
+// spots a missing model behind a generic status.
+func Classify(status int, description string) error {
+	description = strings.ToLower(description)
+	switch {
+	case containsAny(description, "insufficient_quota", "quota", "billing", "credit balance"):
+		if status == http.StatusTooManyRequests || status == http.StatusPaymentRequired || status == http.StatusBadRequest || status == http.StatusForbidden {
+			return ErrQuotaExceeded
+		}
+	case strings.Contains(description, "model") && containsAny(description, "not found", "does not exist", "not_found", "unknown model"):
+		return ErrModelNotFound
+	}
+
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAuth
+	case http.StatusNotFound:
+		return ErrModelNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+
+	// Errors reported inside a response come without a status of their own
+	switch {
+	case containsAny(description, "rate_limit", "rate limit", "resource_exhausted"):
+		return ErrRateLimited
+	case containsAny(description, "authentication", "invalid_api_key", "invalid api key", "api key not valid", "permission"):
+		return ErrAuth
+	}
+	return nil
+}
+
+// RetryAfter reads how long a Retry-After header asks to wait, given in
+// seconds or as a date. It is 0 when there is no such header.
+func RetryAfter(header http.Header) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// errorBody pulls the error code and message out of the bodies providers
+// send with a failed request: {"error": {"type": ..., "message": ...}} or
+// {"error": "..."}. Anything else is returned as the message.
+func errorBody(body []byte) (code, message string) {
+	var parsed struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && len(parsed.Error) > 0 {
+		var text string
+		if json.Unmarshal(parsed.Error, &text) == nil {
+			return "", text
+		}
+		var detail struct {
+			Type    string `json:"type"`
+			Code    any    `json:"code"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(parsed.Error, &detail) == nil && detail.Message != "" {
+			if detail.Code != nil {
+				code = fmt.Sprint(detail.Code)
+			}
+			return strings.Join([]string{detail.Type, code, detail.Status}, " "), detail.Message
+		}
+	}
+	return "", strings.TrimSpace(string(body))
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
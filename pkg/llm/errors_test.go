@@ -0,0 +1,140 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		status      int
+		description string
+		want        error
+	}{
+		{401, "authentication_error invalid x-api-key", ErrAuth},
+		{403, "PERMISSION_DENIED", ErrAuth},
+		{429, "rate_limit_error", ErrRateLimited},
+		{429, "insufficient_quota You exceeded your current quota", ErrQuotaExceeded},
+		{400, "Your credit balance is too low", ErrQuotaExceeded},
+		{404, "not_found_error model: claude-9", ErrModelNotFound},
+		{400, "model 'llama9' not found, try pulling it first", ErrModelNotFound},
+		{408, "", ErrTimeout},
+		{200, "overloaded_error rate limit", ErrRateLimited},
+		{500, "internal error", nil},
+		{400, "invalid schema", nil},
+	}
+
+	for _, tt := range tests {
+		if got := Classify(tt.status, tt.description); got != tt.want {
+			t.Errorf("Classify(%d, %q) = %v, expected %v", tt.status, tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"7"}}
+	body := []byte(`{"type": "error", "error": {"type": "rate_limit_error", "message": "Too many requests"}}`)
+
+	err := HTTPError("anthropic", http.StatusTooManyRequests, header, body)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected a rate limit, got %v", err)
+	}
+	if err.RetryAfter != 7*time.Second {
+		t.Errorf("Expected to wait 7s, got %s", err.RetryAfter)
+	}
+	if want := "anthropic: rate limited (status 429): Too many requests"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	if !err.Temporary() {
+		t.Error("Expected a rate limit to be temporary")
+	}
+
+	// Ollama sends the error as a plain string
+	err = HTTPError("ollama", http.StatusNotFound, nil, []byte(`{"error": "model \"llama9\" not found"}`))
+	if !errors.Is(err, ErrModelNotFound) || err.Message != `model "llama9" not found` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err.Temporary() {
+		t.Error("Expected a missing model not to be temporary")
+	}
+
+	if err := HTTPError("openai", http.StatusBadGateway, nil, []byte("<html>bad gateway</html>")); !err.Temporary() || err.Kind != nil {
+		t.Errorf("Expected an unclassified temporary error, got %v", err)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	err := RequestError("ollama", context.DeadlineExceeded)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout that still unwraps to the cause, got %v", err)
+	}
+
+	if err := RequestError("ollama", context.Canceled); err.Temporary() {
+		t.Error("Expected a canceled request not to be retried")
+	}
+}
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"mentions the schema", HTTPError("p", 400, nil, []byte(`{"error": {"message": "Invalid JSON Schema in format"}}`)), true},
+		{"other bad request", HTTPError("p", 400, nil, []byte(`{"error": {"message": "prompt is too long"}}`)), false},
+		{"classified", HTTPError("p", 400, nil, []byte(`{"error": {"message": "invalid api key for schema"}}`)), false},
+		{"other status", HTTPError("p", 500, nil, []byte(`{"error": "schema"}`)), false},
+		{"not a provider error", errors.New("schema"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Rejected(tt.err, "format", "schema"); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{"soon", 0},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.value != "" {
+			header.Set("Retry-After", tt.value)
+		}
+		if got := RetryAfter(header); got != tt.want {
+			t.Errorf("RetryAfter(%q) = %s, expected %s", tt.value, got, tt.want)
+		}
+	}
+
+	// A date in the future is waited for until then
+	header := http.Header{"Retry-After": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}
+	if got := RetryAfter(header); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("Expected about an hour, got %s", got)
+	}
+}
+
+func TestParseOrRetry_BadResponse(t *testing.T) {
+	var v struct{}
+	err := ParseOrRetry("I can't help with that", CommandSchema, &v, func(error) (string, error) {
+		return "Still no", nil
+	})
+	if !errors.Is(err, ErrBadResponse) || !strings.Contains(err.Error(), "Still no") {
+		t.Errorf("Expected an ErrBadResponse with the last reply, got %v", err)
+	}
+}
@@ -2,20 +2,25 @@ package gemini
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
 	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/prompt"
 	"google.golang.org/genai"
 )
 
 // GeminiProvider implements the llm.Provider interface
 type GeminiProvider struct {
-	client *genai.Client
-	model  string
+	client  *genai.Client
+	model   string
+	prompts *prompt.Templates
+	// promptOnly is set once the model rejected a response schema, after
+	// which only the prompt asks for JSON
+	promptOnly bool
 }
 
 func init() {
@@ -39,93 +44,320 @@ func NewGeminiProvider(cfg llm.ProviderConfig) (llm.Provider, error) {
 		return nil, fmt.Errorf("failed to create gemini client: %w", err)
 	}
 
+	prompts, err := prompt.Load()
+	if err != nil {
+		return nil, err
+	}
+
 	return &GeminiProvider{
-		client: client,
-		model:  model,
+		client:  client,
+		model:   model,
+		prompts: prompts,
 	}, nil
 }
 
 // GenerateCommand generates a command using Gemini
 func (p *GeminiProvider) GenerateCommand(ctx context.Context, query string, meta llm.SystemMetadata) (*model.CommandResult, error) {
-	commandsList := strings.Join(meta.AvailableCommands, ", ")
-	filesList := strings.Join(meta.CurrentDirFiles, ", ")
+	results, err := p.generate(ctx, query, meta, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// GenerateCommandStream generates a command using Gemini, handing out the
+// response as it is generated
+func (p *GeminiProvider) GenerateCommandStream(ctx context.Context, query string, meta llm.SystemMetadata, onToken func(string)) (*model.CommandResult, error) {
+	results, err := p.generate(ctx, query, meta, 1, onToken)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
 
-	previousErrorSection := ""
-	if meta.PreviousError != "" {
-		previousErrorSection = fmt.Sprintf("\n\nTHE USER IS TRYING TO FIX A COMMAND THAT FAILED.\nError output:\n%s\n\nAnalyze this error and generate a fixed command.", meta.PreviousError)
+// GenerateAlternatives asks Gemini for n candidates in a single request
+func (p *GeminiProvider) GenerateAlternatives(ctx context.Context, query string, meta llm.SystemMetadata, n int) ([]*model.CommandResult, error) {
+	return p.generate(ctx, query, meta, n, nil)
+}
+
+// generate sends the prompt for the query and parses each of the n
+// candidates it returns. The latency and tokens are those of the whole
+// request. With onToken set a single candidate is streamed to it.
+func (p *GeminiProvider) generate(ctx context.Context, query string, meta llm.SystemMetadata, n int, onToken func(string)) ([]*model.CommandResult, error) {
+	pr, err := p.prompts.Generate(query, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	onToken, firstToken := llm.TimeFirstToken(startTime, onToken)
+
+	var texts []string
+	var tokens int
+	if onToken != nil {
+		var text string
+		text, tokens, err = p.completeStream(ctx, pr.System, pr.User, llm.CommandSchema, onToken)
+		texts = []string{text}
+	} else {
+		texts, tokens, err = p.complete(ctx, pr.System, pr.User, llm.CommandSchema, n)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	examplesSection := ""
-	if len(meta.FewShotExamples) > 0 {
-		examplesSection = "\n\nReference - Here are similar commands the user has used before:\n"
-		for _, ex := range meta.FewShotExamples {
-			examplesSection += fmt.Sprintf("- Query: %s\n  Command: %s\n  Origin: %s\n", ex.Query, ex.Command, ex.Provider)
+	results := make([]*model.CommandResult, 0, len(texts))
+	for _, text := range texts {
+		var cmd model.CommandResult
+		err := llm.ParseOrRetry(text, llm.CommandSchema, &cmd, func(problem error) (string, error) {
+			text, more, err := p.repair(ctx, pr, text, problem, llm.CommandSchema, onToken)
+			tokens += more
+			return text, err
+		})
+		if err != nil {
+			return nil, err
 		}
+		results = append(results, &cmd)
 	}
 
-	prompt := fmt.Sprintf(`
-You are a command line expert. 
-Your task is to translate the following natural language request into a shell command or a pipeline of commands.
-Respond ONLY with a valid JSON object matching this schema:
-{
-  "steps": [
-    {
-      "tool": "string (the primary command, e.g. git, grep)",
-      "args": ["string", "arguments"],
-      "op": "string (operator to connect to next step: | (pipe), && (and), ; (seq), || (or), > (redirect), >> (append). Empty for last step.)"
-    }
-  ],
-  "explanation": "string (brief explanation of the entire pipeline)",
-  "dangerous": boolean (true if ANY step modifies files significantly, deletes data, or has destructive side effects)
+	latency := time.Since(startTime)
+	for _, cmd := range results {
+		cmd.Metrics = model.Metrics{
+			Latency:    latency.Round(time.Millisecond).String(),
+			TokenCount: tokens,
+		}
+		if ttft := firstToken(); ttft > 0 {
+			cmd.Metrics.TimeToFirstToken = ttft.Round(time.Millisecond).String()
+		}
+	}
+	return results, nil
 }
 
-Operating System: %s
-Shell: %s
-Available Tools: %s
-Current Directory Files: %s%s%s
-Request: %s
-`, meta.OS, meta.Shell, commandsList, filesList, examplesSection, previousErrorSection, query)
+// ExplainCommand explains an existing command using Gemini
+func (p *GeminiProvider) ExplainCommand(ctx context.Context, command string, meta llm.SystemMetadata) (*model.CommandExplanation, error) {
+	pr, err := p.prompts.Explain(command, meta)
+	if err != nil {
+		return nil, err
+	}
 
-	// Capture start time
 	startTime := time.Now()
 
-	resp, err := p.client.Models.GenerateContent(ctx, p.model, genai.Text(prompt), nil)
+	texts, tokens, err := p.complete(ctx, pr.System, pr.User, llm.ExplanationSchema, 1)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate content: %w", err)
+		return nil, err
+	}
+	text := texts[0]
+
+	var exp model.CommandExplanation
+	err = llm.ParseOrRetry(text, llm.ExplanationSchema, &exp, func(problem error) (string, error) {
+		text, more, err := p.repair(ctx, pr, text, problem, llm.ExplanationSchema, nil)
+		tokens += more
+		return text, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	latency := time.Since(startTime)
 
-	if len(resp.Candidates) == 0 {
-		return nil, fmt.Errorf("no response candidates received")
+	exp.Metrics = model.Metrics{
+		Latency:    latency.Round(time.Millisecond).String(),
+		TokenCount: tokens,
+	}
+
+	return &exp, nil
+}
+
+// repair asks again for a reply that could not be used, telling the model
+// what was wrong with it
+func (p *GeminiProvider) repair(ctx context.Context, pr prompt.Prompt, text string, problem error, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	repair, err := p.prompts.Repair(pr, text, problem)
+	if err != nil {
+		return "", 0, err
+	}
+	if onToken != nil {
+		return p.completeStream(ctx, repair.System, repair.User, schema, onToken)
+	}
+	texts, tokens, err := p.complete(ctx, repair.System, repair.User, schema, 1)
+	if err != nil {
+		return "", 0, err
+	}
+	return texts[0], tokens, nil
+}
+
+// complete sends the prompt, with an optional system instruction, and
+// returns n candidate replies and the number of tokens used. The replies
+// follow schema, unless the model rejects it, when it is asked again with
+// the prompt alone.
+func (p *GeminiProvider) complete(ctx context.Context, system, prompt string, schema *llm.Schema, n int) ([]string, int, error) {
+	config := p.config(system, schema)
+	if n > 1 {
+		config.CandidateCount = int32(n)
+	}
+
+	var resp *genai.GenerateContentResponse
+	send := func() error {
+		return llm.DefaultBackoff.Retry(ctx, func() (err error) {
+			resp, err = p.client.Models.GenerateContent(ctx, p.model, genai.Text(prompt), config)
+			return providerError(err)
+		})
+	}
+
+	err := send()
+	if config.ResponseSchema != nil && p.rejected(err) {
+		config.ResponseMIMEType, config.ResponseSchema = "", nil
+		err = send()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var texts []string
+	for _, candidate := range resp.Candidates {
+		if candidate.FinishReason == genai.FinishReasonMaxTokens {
+			return nil, 0, llm.CutOff("gemini")
+		}
+		if candidate.Content == nil {
+			continue
+		}
+		var sb strings.Builder
+		for _, part := range candidate.Content.Parts {
+			if part.Text != "" {
+				sb.WriteString(part.Text)
+			}
+		}
+		texts = append(texts, sb.String())
+	}
+
+	if len(texts) == 0 {
+		return nil, 0, llm.BadResponse("gemini", "no response candidates received")
+	}
+
+	tokens := 0
+	if resp.UsageMetadata != nil {
+		tokens = int(resp.UsageMetadata.TotalTokenCount)
 	}
+	return texts, tokens, nil
+}
+
+// completeStream sends the prompt, with an optional system instruction, and
+// streams the reply to onToken as it arrives. It returns the whole reply and
+// the number of tokens used. The schema is handled as in complete.
+func (p *GeminiProvider) completeStream(ctx context.Context, system, prompt string, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	config := p.config(system, schema)
 
 	var sb strings.Builder
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if part.Text != "" {
-			sb.WriteString(part.Text)
+	tokens := 0
+	err := llm.DefaultBackoff.Retry(ctx, func() error {
+		for resp, err := range p.client.Models.GenerateContentStream(ctx, p.model, genai.Text(prompt), config) {
+			if err != nil && sb.Len() > 0 {
+				// Trying again would repeat what was already shown
+				return llm.BadResponse("gemini", fmt.Sprintf("the stream broke off: %v", err))
+			}
+			if err != nil {
+				return providerError(err)
+			}
+			if text := resp.Text(); text != "" {
+				sb.WriteString(text)
+				onToken(text)
+			}
+			// Each chunk carries the usage so far
+			if resp.UsageMetadata != nil {
+				tokens = int(resp.UsageMetadata.TotalTokenCount)
+			}
+			for _, candidate := range resp.Candidates {
+				if candidate.FinishReason == genai.FinishReasonMaxTokens {
+					return llm.CutOff("gemini")
+				}
+			}
 		}
+		return nil
+	})
+	// A rejected schema fails the first chunk, before anything was shown
+	if config.ResponseSchema != nil && sb.Len() == 0 && p.rejected(err) {
+		return p.completeStream(ctx, system, prompt, nil, onToken)
 	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if sb.Len() == 0 {
+		return "", 0, llm.BadResponse("gemini", "no response candidates received")
+	}
+	return sb.String(), tokens, nil
+}
 
-	result := strings.TrimSpace(sb.String())
-	// Strip markdown code blocks
-	result = strings.TrimPrefix(result, "```json")
-	result = strings.TrimPrefix(result, "```")
-	result = strings.TrimSuffix(result, "```")
+// config builds the request options for the system instruction and, unless
+// the model rejected one before, the response schema
+func (p *GeminiProvider) config(system string, schema *llm.Schema) *genai.GenerateContentConfig {
+	config := &genai.GenerateContentConfig{}
+	if system != "" {
+		config.SystemInstruction = genai.NewContentFromText(system, genai.RoleUser)
+	}
+	if schema != nil && !p.promptOnly {
+		config.ResponseMIMEType = "application/json"
+		config.ResponseSchema = toSchema(schema.MapsAsLists())
+	}
+	return config
+}
 
-	var cmd model.CommandResult
-	if err := json.Unmarshal([]byte(result), &cmd); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w. raw: %s", err, result)
+// rejected reports whether err is the API refusing the response schema, as
+// it does for models without structured output. The provider then stops
+// sending a schema. Other bad requests are returned as they are.
+func (p *GeminiProvider) rejected(err error) bool {
+	if llm.Rejected(err, "schema", "mime") {
+		p.promptOnly = true
+		return true
 	}
+	return false
+}
 
-	// Populate Metrics
-	cmd.Metrics = model.Metrics{
-		Latency: latency.Round(time.Millisecond).String(),
-		// TokenCount: TODO: extract from UsageMetadata if available
+// providerError maps an error from the Gemini client to an
+// *llm.ProviderError. Gemini reports both rate limits and exhausted quotas
+// as RESOURCE_EXHAUSTED, only the former with a delay to retry after.
+func providerError(err error) error {
+	if err == nil {
+		return nil
 	}
-	if resp.UsageMetadata != nil {
-		cmd.Metrics.TokenCount = int(resp.UsageMetadata.TotalTokenCount)
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return llm.RequestError("gemini", err)
 	}
 
-	return &cmd, nil
+	perr := &llm.ProviderError{
+		Provider: "gemini",
+		Kind:     llm.Classify(apiErr.Code, apiErr.Status+" "+apiErr.Message),
+		Status:   apiErr.Code,
+		Message:  apiErr.Message,
+		Err:      err,
+	}
+	for _, detail := range apiErr.Details {
+		if delay, ok := detail["retryDelay"].(string); ok {
+			if d, err := time.ParseDuration(delay); err == nil {
+				perr.Kind = llm.ErrRateLimited
+				perr.RetryAfter = d
+			}
+		}
+	}
+	return perr
+}
+
+// toSchema converts a schema to Gemini's own format. Gemini cannot describe
+// an object without fixed properties, so free-form maps must already be
+// lists, see llm.Schema.MapsAsLists.
+func toSchema(s *llm.Schema) *genai.Schema {
+	out := &genai.Schema{
+		Type:     genai.Type(strings.ToUpper(s.Type)),
+		Enum:     s.Enum,
+		Required: s.Required,
+	}
+	if s.Items != nil {
+		out.Items = toSchema(s.Items)
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			out.Properties[name] = toSchema(prop)
+		}
+	}
+	return out
 }
@@ -0,0 +1,70 @@
+package gemini
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"google.golang.org/genai"
+)
+
+func TestToSchema(t *testing.T) {
+	s := toSchema(llm.CommandSchema.MapsAsLists())
+
+	if s.Type != genai.TypeObject {
+		t.Errorf("Expected an object, got %s", s.Type)
+	}
+	step := s.Properties["steps"].Items
+	if step == nil || step.Properties["tool"].Type != genai.TypeString {
+		t.Fatalf("Expected steps with a tool, got %+v", s.Properties["steps"])
+	}
+	if env := step.Properties["env"]; env == nil || env.Type != genai.TypeArray || env.Items.Properties["value"] == nil {
+		t.Errorf("Expected the env map as a list of entries, got %+v", env)
+	}
+	if step.Properties["args"].Items.Type != genai.TypeString {
+		t.Errorf("Expected args to be strings, got %+v", step.Properties["args"].Items)
+	}
+	if got := s.Properties["risk"].Properties["level"].Enum; len(got) != 5 || got[0] != "none" {
+		t.Errorf("Expected the risk levels, got %v", got)
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			t.Errorf("Required property %s is missing", name)
+		}
+	}
+}
+
+func TestRejected(t *testing.T) {
+	p := &GeminiProvider{}
+	if p.rejected(providerError(genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "Request contains an invalid argument."})) || p.promptOnly {
+		t.Error("Expected a bad request without a schema problem to be returned as it is")
+	}
+	if !p.rejected(providerError(genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "Invalid JSON payload: response_schema is not supported for this model"})) || !p.promptOnly {
+		t.Error("Expected a refused schema to switch to prompts only")
+	}
+}
+
+func TestProviderError(t *testing.T) {
+	// Gemini says how long to wait in the details of a rate limit
+	err := providerError(genai.APIError{
+		Code:    429,
+		Status:  "RESOURCE_EXHAUSTED",
+		Message: "Resource has been exhausted",
+		Details: []map[string]any{{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "38s"}},
+	})
+	var perr *llm.ProviderError
+	if !errors.As(err, &perr) || !errors.Is(err, llm.ErrRateLimited) || perr.RetryAfter != 38*time.Second {
+		t.Errorf("Expected a rate limit with a delay, got %+v", err)
+	}
+
+	err = providerError(genai.APIError{Code: 429, Status: "RESOURCE_EXHAUSTED", Message: "You exceeded your current quota"})
+	if !errors.Is(err, llm.ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	err = providerError(genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "API key not valid. Please pass a valid API key."})
+	if !errors.Is(err, llm.ErrAuth) {
+		t.Errorf("Expected ErrAuth, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// Post sends body to url for the named provider, retrying with
+// DefaultBackoff, and returns the response once it is a success. Failures
+// come back as a *ProviderError. The caller closes the response body.
+func Post(ctx context.Context, client *http.Client, provider, url string, header http.Header, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	err := DefaultBackoff.Retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header = header.Clone()
+
+		resp, err = client.Do(req)
+		if err != nil {
+			return RequestError(provider, err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return HTTPError(provider, resp.StatusCode, resp.Header, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
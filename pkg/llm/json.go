@@ -0,0 +1,169 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ExtractJSON strips the markdown code fences and any chatter models
+// sometimes wrap around the JSON object they were asked for. The object ends
+// where its braces balance, so braces in the chatter after it don't matter,
+// and a reply that was cut off is returned up to where it stops.
+func ExtractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' || !objectStart.MatchString(text[i+1:]) {
+			continue
+		}
+		if end := objectEnd(text[i:]); end > 0 {
+			return strings.TrimSpace(text[i : i+end])
+		}
+		return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text[i:]), "```"))
+	}
+	return strings.TrimSpace(text)
+}
+
+// objectStart matches the text after a { that opens an object rather than a
+// {placeholder} in prose: a key, quoted or not, a comment or the closing
+// brace
+var objectStart = regexp.MustCompile(`^\s*(["'“‘}]|//|/\*|[A-Za-z_]\w*\s*:|$)`)
+
+// objectEnd returns the length of the object text starts with, or 0 when its
+// braces never balance
+func objectEnd(text string) int {
+	depth := 0
+	inString := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// literals maps the Python spellings models slip into to their JSON ones
+var literals = map[string]string{"True": "true", "False": "false", "None": "null"}
+
+// RepairJSON fixes the mistakes models make when writing JSON by hand:
+// curly or single quotes, unquoted keys, trailing commas, comments, Python
+// literals and raw newlines inside strings. Valid JSON comes back unchanged.
+// A reply that was cut off is left open: closing it would keep whatever the
+// model was in the middle of writing, like half a path.
+func RepairJSON(text string) string {
+	var sb strings.Builder
+	var quote rune // The rune that opened the current string, 0 outside one
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		if quote != 0 {
+			switch {
+			case r == '\\' && i+size < len(text):
+				next, nsize := utf8.DecodeRuneInString(text[i+size:])
+				if next == '\'' && quote != '"' {
+					sb.WriteRune(next) // \' is not a JSON escape
+				} else {
+					sb.WriteRune(r)
+					sb.WriteRune(next)
+				}
+				i += size + nsize
+				continue
+			case closes(quote, r):
+				sb.WriteByte('"')
+				quote = 0
+			case r == '"':
+				sb.WriteString(`\"`)
+			case r == '\n':
+				sb.WriteString(`\n`)
+			case r == '\r':
+				sb.WriteString(`\r`)
+			case r == '\t':
+				sb.WriteString(`\t`)
+			default:
+				sb.WriteRune(r)
+			}
+			i += size
+			continue
+		}
+
+		switch {
+		case r == '"' || r == '“' || r == '”' || r == '\'' || r == '‘' || r == '’':
+			quote = r
+			sb.WriteByte('"')
+		case strings.HasPrefix(text[i:], "//"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			i += end
+			continue
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				i = len(text)
+			} else {
+				i += end + 4
+			}
+			continue
+		case r == ',':
+			rest := strings.TrimLeftFunc(text[i+1:], unicode.IsSpace)
+			if rest != "" && rest[0] != '}' && rest[0] != ']' {
+				sb.WriteByte(',')
+			}
+		case unicode.IsLetter(r) || r == '_':
+			end := i + size
+			for end < len(text) {
+				next, nsize := utf8.DecodeRuneInString(text[end:])
+				if !unicode.IsLetter(next) && !unicode.IsDigit(next) && next != '_' {
+					break
+				}
+				end += nsize
+			}
+			word := text[i:end]
+			if lit, ok := literals[word]; ok {
+				word = lit
+			} else if strings.HasPrefix(strings.TrimLeftFunc(text[end:], unicode.IsSpace), ":") {
+				word = `"` + word + `"` // An unquoted key
+			}
+			sb.WriteString(word)
+			i = end
+			continue
+		default:
+			sb.WriteRune(r)
+		}
+		i += size
+	}
+
+	return strings.TrimRightFunc(sb.String(), unicode.IsSpace)
+}
+
+// closes reports whether r ends a string opened with quote. Curly quotes are
+// often mismatched, so any double quote ends a string a curly one opened.
+func closes(quote, r rune) bool {
+	switch quote {
+	case '"':
+		return r == '"'
+	case '\'', '‘', '’':
+		return r == '\'' || r == '’' || r == '‘'
+	default:
+		return r == '"' || r == '”' || r == '“'
+	}
+}
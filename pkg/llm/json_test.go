@@ -0,0 +1,55 @@
+package llm
+
+import "testing"
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", `{"a": 1}`, `{"a": 1}`},
+		{"fenced", "```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"bare fence", "```\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"chatty", "Sure! Here it is:\n{\"a\": {\"b\": 2}}\nHope this helps.", `{"a": {"b": 2}}`},
+		{"no object", "no json here", "no json here"},
+		{"braces after", "{\"a\": 1}\nReplace {name} with yours.", `{"a": 1}`},
+		{"placeholder before", "Fill in {name}:\n{\"a\": \"}\"}", `{"a": "}"}`},
+		{"cut off", "```json\n{\"a\": [1, 2", `{"a": [1, 2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractJSON(tt.in); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"valid", `{"a": [1, "x, ]"]}`, `{"a": [1, "x, ]"]}`},
+		{"trailing commas", `{"a": [1, 2,], "b": 3,}`, `{"a": [1, 2], "b": 3}`},
+		{"smart quotes", `{“a”: “it’s”}`, `{"a": "it’s"}`},
+		{"smart quotes inside a string", `{"a": "say “hi”"}`, `{"a": "say “hi”"}`},
+		{"single quotes", `{'a': 'say "hi"', 'b': 'it\'s'}`, `{"a": "say \"hi\"", "b": "it's"}`},
+		{"python literals", `{"a": True, "b": None, "c": "True"}`, `{"a": true, "b": null, "c": "True"}`},
+		{"unquoted keys", `{a: 1, b_2 : "x"}`, `{"a": 1, "b_2" : "x"}`},
+		{"comments", "{\"a\": 1, // one\n/* two */ \"b\": \"//x\"}", "{\"a\": 1, \n \"b\": \"//x\"}"},
+		{"raw newline", "{\"a\": \"one\ntwo\"}", `{"a": "one\ntwo"}`},
+		{"cut off stays open", `{"a": [{"b": "te`, `{"a": [{"b": "te`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RepairJSON(tt.in); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
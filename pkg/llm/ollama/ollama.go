@@ -1,7 +1,6 @@
 package ollama
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,11 +12,17 @@ import (
 
 	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
 	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/prompt"
 )
 
 type OllamaProvider struct {
+	client  *http.Client
 	baseURL string
 	model   string
+	prompts *prompt.Templates
+	// promptOnly is set once the server rejected a response schema, after
+	// which only the prompt asks for JSON
+	promptOnly bool
 }
 
 type ChatMessage struct {
@@ -29,13 +34,15 @@ type ChatRequest struct {
 	Model    string        `json:"model"`
 	Messages []ChatMessage `json:"messages"`
 	Stream   bool          `json:"stream"`
-	Format   string        `json:"format,omitempty"`
+	// Format is "json", or a JSON schema the reply must follow
+	Format json.RawMessage `json:"format,omitempty"`
 }
 
 type ChatResponse struct {
 	Model      string      `json:"model"`
 	Message    ChatMessage `json:"message"`
 	Done       bool        `json:"done"`
+	DoneReason string      `json:"done_reason"` // "length" when the reply hit the token limit
 	EvalCount  int         `json:"eval_count"`
 	TotalQueue int         `json:"total_duration"` // nanoseconds
 }
@@ -50,134 +57,193 @@ func NewOllamaProvider(cfg llm.ProviderConfig) (llm.Provider, error) {
 		baseURL = "http://localhost:11434"
 	}
 
+	prompts, err := prompt.Load()
+	if err != nil {
+		return nil, err
+	}
+	// Keep the tool list short for small local models
+	prompts.MaxTools = 50
+
 	return &OllamaProvider{
+		client:  &http.Client{},
 		baseURL: baseURL,
 		model:   cfg.Model,
+		prompts: prompts,
 	}, nil
 }
 
 // GenerateCommand generates a command using Ollama
 func (p *OllamaProvider) GenerateCommand(ctx context.Context, query string, meta llm.SystemMetadata) (*model.CommandResult, error) {
+	return p.generate(ctx, query, meta, nil)
+}
+
+// GenerateCommandStream generates a command using Ollama, handing out the
+// response as it is generated
+func (p *OllamaProvider) GenerateCommandStream(ctx context.Context, query string, meta llm.SystemMetadata, onToken func(string)) (*model.CommandResult, error) {
+	return p.generate(ctx, query, meta, onToken)
+}
+
+// generate asks for a command, streaming the reply to onToken unless it is nil
+func (p *OllamaProvider) generate(ctx context.Context, query string, meta llm.SystemMetadata, onToken func(string)) (*model.CommandResult, error) {
 	if p.model == "" {
 		p.model = "llama3" // Default
 	}
-	// Limit available commands to avoid overwhelming the context
-	maxCommands := 50
-	truncated := false
-	if len(meta.AvailableCommands) > maxCommands {
-		meta.AvailableCommands = meta.AvailableCommands[:maxCommands]
-		truncated = true
+	pr, err := p.prompts.Generate(query, meta)
+	if err != nil {
+		return nil, err
 	}
-	commandsList := strings.Join(meta.AvailableCommands, ", ")
 
-	availableToolsSuffix := ""
-	if truncated {
-		availableToolsSuffix = " (and others)"
+	startTime := time.Now()
+	onToken, firstToken := llm.TimeFirstToken(startTime, onToken)
+
+	text, tokens, err := p.complete(ctx, pr.System, pr.User, llm.CommandSchema, onToken)
+	if err != nil {
+		return nil, err
 	}
 
-	previousErrorSection := ""
-	if meta.PreviousError != "" {
-		previousErrorSection = fmt.Sprintf("\n\nTHE USER IS TRYING TO FIX A COMMAND THAT FAILED.\nError output:\n%s\n\nAnalyze this error and generate a fixed command.", meta.PreviousError)
+	var cmd model.CommandResult
+	err = llm.ParseOrRetry(text, llm.CommandSchema, &cmd, func(problem error) (string, error) {
+		text, more, err := p.repair(ctx, pr, text, problem, llm.CommandSchema, onToken)
+		tokens += more
+		return text, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	examplesSection := ""
-	if len(meta.FewShotExamples) > 0 {
-		examplesSection = "\n\nReference - Here are similar commands the user has used before:\n"
-		for _, ex := range meta.FewShotExamples {
-			examplesSection += fmt.Sprintf("- Query: %s\n  Command: %s\n  Origin: %s\n", ex.Query, ex.Command, ex.Provider)
-		}
+	latency := time.Since(startTime)
+
+	cmd.Metrics = model.Metrics{
+		Latency:    latency.Round(time.Millisecond).String(),
+		TokenCount: tokens,
+	}
+	if ttft := firstToken(); ttft > 0 {
+		cmd.Metrics.TimeToFirstToken = ttft.Round(time.Millisecond).String()
 	}
 
-	prompt := fmt.Sprintf(`
-You are a command line expert.
-Your task is to translate the following natural language request into a shell command or a pipeline of commands.
-You MUST return a JSON object with strictly these fields: "steps", "explanation", "dangerous".
-Do NOT list files or answer the question directly. Generate the command to do it.
-
-Schema:
-{
-  "steps": [
-    {
-      "tool": "string",
-      "args": ["string"],
-      "op": "string"
-    }
-  ],
-  "explanation": "string",
-  "dangerous": boolean
+	return &cmd, nil
 }
 
-Operating System: %s
-Shell: %s
-Available Tools: %s%s%s%s
-Request: %s
-`, meta.OS, meta.Shell, commandsList, availableToolsSuffix, examplesSection, previousErrorSection, query)
-
-	reqBody := ChatRequest{
-		Model: p.model,
-		Messages: []ChatMessage{
-			{Role: "system", Content: "You are a helpful assistant that generates structured shell commands in JSON. Use the schema provided."},
-			{Role: "user", Content: prompt},
-		},
-		Stream: false,
-		Format: "json",
+// ExplainCommand explains an existing command using Ollama
+func (p *OllamaProvider) ExplainCommand(ctx context.Context, command string, meta llm.SystemMetadata) (*model.CommandExplanation, error) {
+	if p.model == "" {
+		p.model = "llama3" // Default
 	}
 
-	jsonData, err := json.Marshal(reqBody)
+	pr, err := p.prompts.Explain(command, meta)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
 	}
 
-	url := fmt.Sprintf("%s/api/chat", strings.TrimRight(p.baseURL, "/"))
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	startTime := time.Now()
+
+	text, tokens, err := p.complete(ctx, pr.System, pr.User, llm.ExplanationSchema, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	startTime := time.Now()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	var exp model.CommandExplanation
+	err = llm.ParseOrRetry(text, llm.ExplanationSchema, &exp, func(problem error) (string, error) {
+		text, more, err := p.repair(ctx, pr, text, problem, llm.ExplanationSchema, nil)
+		tokens += more
+		return text, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request to ollama: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ollama request failed with status %d: %s", resp.StatusCode, string(body))
+	latency := time.Since(startTime)
+
+	exp.Metrics = model.Metrics{
+		Latency:    latency.Round(time.Millisecond).String(),
+		TokenCount: tokens,
 	}
 
-	var chatResp ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	return &exp, nil
+}
+
+// repair asks again for a reply that could not be used, telling the model
+// what was wrong with it
+func (p *OllamaProvider) repair(ctx context.Context, pr prompt.Prompt, text string, problem error, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	repair, err := p.prompts.Repair(pr, text, problem)
+	if err != nil {
+		return "", 0, err
 	}
+	return p.complete(ctx, repair.System, repair.User, schema, onToken)
+}
 
-	latency := time.Since(startTime)
+// complete sends a system and user message to /api/chat and returns the
+// reply and the number of tokens evaluated. The reply is held to schema,
+// unless the server is too old to accept one, when it is asked again for
+// plain JSON. With onToken set the reply is streamed, and each piece is
+// passed to it as it arrives.
+func (p *OllamaProvider) complete(ctx context.Context, system, prompt string, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	if p.promptOnly {
+		schema = nil
+	}
+	format := json.RawMessage(`"json"`)
+	if schema != nil {
+		format = schema.JSON()
+	}
 
-	result := strings.TrimSpace(chatResp.Message.Content)
-	// Strip markdown code blocks
-	result = strings.TrimPrefix(result, "```json")
-	result = strings.TrimPrefix(result, "```")
-	result = strings.TrimSuffix(result, "```")
+	reqBody := ChatRequest{
+		Model: p.model,
+		Messages: []ChatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+		Stream: onToken != nil,
+		Format: format,
+	}
 
-	// Sometimes Ollama might still be chatty, finding the first '{' and last '}' might be safer
-	start := strings.Index(result, "{")
-	end := strings.LastIndex(result, "}")
-	if start != -1 && end != -1 && end > start {
-		result = result[start : end+1]
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	var cmd model.CommandResult
-	if err := json.Unmarshal([]byte(result), &cmd); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w. raw: %s", err, result)
+	url := fmt.Sprintf("%s/api/chat", strings.TrimRight(p.baseURL, "/"))
+	header := http.Header{"Content-Type": {"application/json"}}
+	resp, err := llm.Post(ctx, p.client, "ollama", url, header, jsonData)
+	if schema != nil && llm.Rejected(err, "format", "schema") {
+		p.promptOnly = true
+		return p.complete(ctx, system, prompt, nil, onToken)
 	}
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
 
-	cmd.Metrics = model.Metrics{
-		Latency:    latency.Round(time.Millisecond).String(),
-		TokenCount: chatResp.EvalCount,
+	if onToken == nil {
+		var chatResp ChatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+			return "", 0, llm.BadResponse("ollama", fmt.Sprintf("failed to decode response: %v", err))
+		}
+		if chatResp.DoneReason == "length" {
+			return "", 0, llm.CutOff("ollama")
+		}
+		return chatResp.Message.Content, chatResp.EvalCount, nil
 	}
 
-	return &cmd, nil
+	// A stream is one JSON object per line, the last one marked done
+	var sb strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk ChatResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return "", 0, llm.BadResponse("ollama", "the stream ended before the response was done")
+			}
+			return "", 0, llm.BadResponse("ollama", fmt.Sprintf("failed to decode response: %v", err))
+		}
+		if chunk.Message.Content != "" {
+			sb.WriteString(chunk.Message.Content)
+			onToken(chunk.Message.Content)
+		}
+		if chunk.Done && chunk.DoneReason == "length" {
+			return "", 0, llm.CutOff("ollama")
+		}
+		if chunk.Done {
+			return sb.String(), chunk.EvalCount, nil
+		}
+	}
 }
@@ -3,8 +3,10 @@ package ollama
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -83,3 +85,277 @@ func TestOllamaProvider_GenerateCommand(t *testing.T) {
 		t.Error("Expected latency to be recorded")
 	}
 }
+
+func TestOllamaProvider_ExplainCommand(t *testing.T) {
+	mockResponse := ChatResponse{
+		Model: "llama3",
+		Message: ChatMessage{
+			Role: "assistant",
+			Content: `{
+				"summary": "Counts the lines of every Go file",
+				"steps": [
+					{"command": "find . -name '*.go'", "summary": "Lists Go files", "op": "|",
+					 "args": [{"arg": "find", "meaning": "search a directory tree"}, {"arg": "-name '*.go'", "meaning": "only files ending in .go"}]},
+					{"command": "xargs wc -l", "summary": "Counts their lines",
+					 "args": [{"arg": "xargs", "meaning": "pass the file names as arguments"}]}
+				],
+				"risk": {"level": "none"}
+			}`,
+		},
+		Done:      true,
+		EvalCount: 7,
+	}
+
+	var received ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(mockResponse)
+	}))
+	defer ts.Close()
+
+	provider, err := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	command := "find . -name '*.go' | xargs wc -l"
+	exp, err := provider.ExplainCommand(context.Background(), command, llm.SystemMetadata{OS: "linux", Shell: "bash"})
+	if err != nil {
+		t.Fatalf("ExplainCommand failed: %v", err)
+	}
+
+	if len(received.Messages) != 2 || !strings.Contains(received.Messages[1].Content, command) {
+		t.Errorf("Expected the command in the prompt, got %+v", received.Messages)
+	}
+	if len(exp.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(exp.Steps))
+	}
+	if exp.Steps[0].Op != "|" || len(exp.Steps[0].Args) != 2 {
+		t.Errorf("Unexpected first step: %+v", exp.Steps[0])
+	}
+	if exp.Metrics.TokenCount != 7 {
+		t.Errorf("Expected 7 tokens, got %d", exp.Metrics.TokenCount)
+	}
+}
+
+func TestOllamaProvider_GenerateCommandWithConversation(t *testing.T) {
+	var received ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ChatResponse{
+			Message: ChatMessage{Role: "assistant", Content: `{"steps": [{"tool": "find", "args": [".", "-name", "*.log"]}], "explanation": "Find logs"}`},
+			Done:    true,
+		})
+	}))
+	defer ts.Close()
+
+	provider, err := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	meta := llm.SystemMetadata{
+		OS:    "linux",
+		Shell: "bash",
+		Conversation: []llm.ConversationTurn{
+			{Query: "list large files", Command: "find . -size +100M", Outcome: "ran successfully"},
+		},
+	}
+	if _, err := provider.GenerateCommand(context.Background(), "now only .log files", meta); err != nil {
+		t.Fatalf("GenerateCommand failed: %v", err)
+	}
+
+	prompt := received.Messages[len(received.Messages)-1].Content
+	for _, want := range []string{"Request: list large files", "Command: find . -size +100M", "Result: ran successfully", "Request: now only .log files"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected %q in the prompt", want)
+		}
+	}
+}
+
+func TestOllamaProvider_GenerateCommandStream(t *testing.T) {
+	chunks := []string{`{"steps": [{"tool": "echo", `, `"args": ["hello"]}], `, `"explanation": "Say hello"}`}
+
+	var received ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, c := range chunks {
+			enc.Encode(ChatResponse{Message: ChatMessage{Role: "assistant", Content: c}})
+		}
+		enc.Encode(ChatResponse{Done: true, EvalCount: 12})
+	}))
+	defer ts.Close()
+
+	provider, err := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	var tokens []string
+	result, err := provider.(llm.StreamingProvider).GenerateCommandStream(context.Background(), "say hello", llm.SystemMetadata{}, func(s string) {
+		tokens = append(tokens, s)
+	})
+	if err != nil {
+		t.Fatalf("GenerateCommandStream failed: %v", err)
+	}
+
+	if !received.Stream {
+		t.Error("Expected a streaming request")
+	}
+	if strings.Join(tokens, "") != strings.Join(chunks, "") {
+		t.Errorf("Expected the chunks in order, got %q", tokens)
+	}
+	if result.Explanation != "Say hello" || len(result.Steps) != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.Metrics.TokenCount != 12 {
+		t.Errorf("Expected 12 tokens, got %d", result.Metrics.TokenCount)
+	}
+	if result.Metrics.TimeToFirstToken == "" {
+		t.Error("Expected the time to first token to be recorded")
+	}
+}
+
+func TestOllamaProvider_GenerateCommandStream_Cut(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ChatResponse{Message: ChatMessage{Content: `{"steps": [`}})
+	}))
+	defer ts.Close()
+
+	provider, _ := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	_, err := provider.(llm.StreamingProvider).GenerateCommandStream(context.Background(), "q", llm.SystemMetadata{}, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "ended") {
+		t.Errorf("Expected an error for a stream that ends early, got %v", err)
+	}
+}
+
+func TestOllamaProvider_GenerateCommand_Length(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ChatResponse{Message: ChatMessage{Content: `{"steps": [{"tool": "rm", "args": ["-rf", "/home/me/pro`}, Done: true, DoneReason: "length"})
+	}))
+	defer ts.Close()
+
+	provider, _ := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	_, err := provider.GenerateCommand(context.Background(), "q", llm.SystemMetadata{})
+	if !errors.Is(err, llm.ErrBadResponse) || !strings.Contains(err.Error(), "cut off") {
+		t.Errorf("Expected a cut off reply to be a bad response, got %v", err)
+	}
+}
+
+func TestOllamaProvider_SchemaFallback(t *testing.T) {
+	var formats []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		formats = append(formats, string(req.Format))
+		if string(req.Format) != `"json"` {
+			http.Error(w, `{"error": "invalid format"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Message: ChatMessage{Content: `{"steps": [{"tool": "ls", "args": []}], "explanation": "List files"}`},
+			Done:    true,
+		})
+	}))
+	defer ts.Close()
+
+	provider, err := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := provider.GenerateCommand(context.Background(), "list files", llm.SystemMetadata{})
+		if err != nil {
+			t.Fatalf("GenerateCommand failed: %v", err)
+		}
+		if result.Steps[0].Tool != "ls" {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	}
+
+	// The schema is tried once, then the provider sticks to plain JSON
+	if len(formats) != 3 || !strings.Contains(formats[0], `"properties"`) {
+		t.Errorf("Unexpected formats: %q", formats)
+	}
+}
+
+func TestOllamaProvider_GenerateCommand_Repair(t *testing.T) {
+	replies := []string{
+		`Here you go: {"steps": [{"tool": "ls", "args": "-la"}], "explanation": "List files"}`,
+		`{"steps": [{"tool": "ls", "args": ["-la"]}], "explanation": "List files"}`,
+	}
+	var prompts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompts = append(prompts, req.Messages[1].Content)
+		json.NewEncoder(w).Encode(ChatResponse{
+			Message:   ChatMessage{Content: replies[len(prompts)-1]},
+			Done:      true,
+			EvalCount: 10,
+		})
+	}))
+	defer ts.Close()
+
+	provider, _ := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	result, err := provider.GenerateCommand(context.Background(), "list files", llm.SystemMetadata{})
+	if err != nil {
+		t.Fatalf("GenerateCommand failed: %v", err)
+	}
+
+	if len(prompts) != 2 || !strings.Contains(prompts[1], "steps[0].args should be an array") {
+		t.Errorf("Expected the model to be asked again with the problem, got %q", prompts)
+	}
+	if result.Steps[0].Args[0] != "-la" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.Metrics.TokenCount != 20 {
+		t.Errorf("Expected the tokens of both requests, got %d", result.Metrics.TokenCount)
+	}
+}
+
+func TestOllamaProvider_GenerateCommand_Retry(t *testing.T) {
+	defer func(b llm.Backoff) { llm.DefaultBackoff = b }(llm.DefaultBackoff)
+	llm.DefaultBackoff.Base = time.Millisecond
+
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "server busy, please try again"})
+			return
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Message: ChatMessage{Content: `{"steps": [{"tool": "ls"}], "explanation": "List files"}`},
+			Done:    true,
+		})
+	}))
+	defer ts.Close()
+
+	provider, _ := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama3"})
+	if _, err := provider.GenerateCommand(context.Background(), "list files", llm.SystemMetadata{}); err != nil || calls != 2 {
+		t.Errorf("Expected success after a retry, got %v after %d calls", err, calls)
+	}
+}
+
+func TestOllamaProvider_GenerateCommand_ModelNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": `model "llama9" not found, try pulling it first`})
+	}))
+	defer ts.Close()
+
+	provider, _ := NewOllamaProvider(llm.ProviderConfig{BaseURL: ts.URL, Model: "llama9"})
+	_, err := provider.GenerateCommand(context.Background(), "list files", llm.SystemMetadata{})
+	if !errors.Is(err, llm.ErrModelNotFound) {
+		t.Errorf("Expected ErrModelNotFound, got %v", err)
+	}
+}
@@ -2,21 +2,26 @@ package openai
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
-
 	"time"
 
 	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
 	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/prompt"
 	openai "github.com/sashabaranov/go-openai"
 )
 
 // OpenAIProvider implements the llm.Provider interface
 type OpenAIProvider struct {
-	client *openai.Client
-	model  string
+	client  *openai.Client
+	model   string
+	prompts *prompt.Templates
+	// promptOnly is set once the model rejected a response schema, after
+	// which only the prompt asks for JSON
+	promptOnly bool
 }
 
 func init() {
@@ -38,99 +43,316 @@ func NewOpenAIProvider(cfg llm.ProviderConfig) (llm.Provider, error) {
 	config := openai.DefaultConfig(cfg.APIKey)
 	client := openai.NewClientWithConfig(config)
 
+	prompts, err := prompt.Load()
+	if err != nil {
+		return nil, err
+	}
+
 	return &OpenAIProvider{
-		client: client,
-		model:  model,
+		client:  client,
+		model:   model,
+		prompts: prompts,
 	}, nil
 }
 
 // GenerateCommand generates a command using OpenAI
 func (p *OpenAIProvider) GenerateCommand(ctx context.Context, query string, meta llm.SystemMetadata) (*model.CommandResult, error) {
-	commandsList := strings.Join(meta.AvailableCommands, ", ")
-	filesList := strings.Join(meta.CurrentDirFiles, ", ")
+	results, err := p.generate(ctx, query, meta, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// GenerateCommandStream generates a command using OpenAI, handing out the
+// response as it is generated
+func (p *OpenAIProvider) GenerateCommandStream(ctx context.Context, query string, meta llm.SystemMetadata, onToken func(string)) (*model.CommandResult, error) {
+	results, err := p.generate(ctx, query, meta, 1, onToken)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
 
-	previousErrorSection := ""
-	if meta.PreviousError != "" {
-		previousErrorSection = fmt.Sprintf("\n\nTHE USER IS TRYING TO FIX A COMMAND THAT FAILED.\nError output:\n%s\n\nAnalyze this error and generate a fixed command.", meta.PreviousError)
+// GenerateAlternatives asks OpenAI for n candidates in a single request
+func (p *OpenAIProvider) GenerateAlternatives(ctx context.Context, query string, meta llm.SystemMetadata, n int) ([]*model.CommandResult, error) {
+	return p.generate(ctx, query, meta, n, nil)
+}
+
+// generate sends the prompt for the query and parses each of the n
+// candidates it returns. The latency and tokens are those of the whole
+// request. With onToken set a single candidate is streamed to it.
+func (p *OpenAIProvider) generate(ctx context.Context, query string, meta llm.SystemMetadata, n int, onToken func(string)) ([]*model.CommandResult, error) {
+	pr, err := p.prompts.Generate(query, meta)
+	if err != nil {
+		return nil, err
 	}
 
-	examplesSection := ""
-	if len(meta.FewShotExamples) > 0 {
-		examplesSection = "\n\nReference - Here are similar commands the user has used before:\n"
-		for _, ex := range meta.FewShotExamples {
-			examplesSection += fmt.Sprintf("- Query: %s\n  Command: %s\n  Origin: %s\n", ex.Query, ex.Command, ex.Provider)
+	startTime := time.Now()
+	onToken, firstToken := llm.TimeFirstToken(startTime, onToken)
+
+	var texts []string
+	var tokens int
+	if onToken != nil {
+		var text string
+		text, tokens, err = p.completeStream(ctx, pr.System, pr.User, llm.CommandSchema, onToken)
+		texts = []string{text}
+	} else {
+		texts, tokens, err = p.complete(ctx, pr.System, pr.User, llm.CommandSchema, n)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*model.CommandResult, 0, len(texts))
+	for _, text := range texts {
+		var cmd model.CommandResult
+		err := llm.ParseOrRetry(text, llm.CommandSchema, &cmd, func(problem error) (string, error) {
+			text, more, err := p.repair(ctx, pr, text, problem, llm.CommandSchema, onToken)
+			tokens += more
+			return text, err
+		})
+		if err != nil {
+			return nil, err
 		}
+		results = append(results, &cmd)
 	}
 
-	prompt := fmt.Sprintf(`
-You are a command line expert. 
-Your task is to translate the following natural language request into a shell command or a pipeline of commands.
-Respond ONLY with a valid JSON object matching this schema:
-{
-  "steps": [
-    {
-      "tool": "string (the primary command, e.g. git, grep)",
-      "args": ["string", "arguments"],
-      "op": "string (operator to connect to next step: | (pipe), && (and), ; (seq), || (or), > (redirect), >> (append). Empty for last step.)"
-    }
-  ],
-  "explanation": "string (brief explanation of the entire pipeline)",
-  "dangerous": boolean (true if ANY step modifies files significantly, deletes data, or has destructive side effects)
+	latency := time.Since(startTime)
+	for _, cmd := range results {
+		cmd.Metrics = model.Metrics{
+			Latency:    latency.Round(time.Millisecond).String(),
+			TokenCount: tokens,
+		}
+		if ttft := firstToken(); ttft > 0 {
+			cmd.Metrics.TimeToFirstToken = ttft.Round(time.Millisecond).String()
+		}
+	}
+	return results, nil
 }
 
-Operating System: %s
-Shell: %s
-Available Tools: %s
-Current Directory Files: %s%s%s
-Request: %s
-`, meta.OS, meta.Shell, commandsList, filesList, examplesSection, previousErrorSection, query)
+// ExplainCommand explains an existing command using OpenAI
+func (p *OpenAIProvider) ExplainCommand(ctx context.Context, command string, meta llm.SystemMetadata) (*model.CommandExplanation, error) {
+	pr, err := p.prompts.Explain(command, meta)
+	if err != nil {
+		return nil, err
+	}
 
 	startTime := time.Now()
 
-	resp, err := p.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: p.model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: "You are a helpful assistant that generates structured shell commands in JSON.",
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-			// Note: We could use ResponseFormat: { Type: "json_object" } for newer models,
-			// but to keep it simple and compatible with older/cheaper ones, we prompt instruction.
-		},
-	)
+	texts, tokens, err := p.complete(ctx, pr.System, pr.User, llm.ExplanationSchema, 1)
+	if err != nil {
+		return nil, err
+	}
+	text := texts[0]
 
+	var exp model.CommandExplanation
+	err = llm.ParseOrRetry(text, llm.ExplanationSchema, &exp, func(problem error) (string, error) {
+		text, more, err := p.repair(ctx, pr, text, problem, llm.ExplanationSchema, nil)
+		tokens += more
+		return text, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate content: %w", err)
+		return nil, err
 	}
 
 	latency := time.Since(startTime)
 
+	exp.Metrics = model.Metrics{
+		Latency:    latency.Round(time.Millisecond).String(),
+		TokenCount: tokens,
+	}
+
+	return &exp, nil
+}
+
+// repair asks again for a reply that could not be used, telling the model
+// what was wrong with it
+func (p *OpenAIProvider) repair(ctx context.Context, pr prompt.Prompt, text string, problem error, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	repair, err := p.prompts.Repair(pr, text, problem)
+	if err != nil {
+		return "", 0, err
+	}
+	if onToken != nil {
+		return p.completeStream(ctx, repair.System, repair.User, schema, onToken)
+	}
+	texts, tokens, err := p.complete(ctx, repair.System, repair.User, schema, 1)
+	if err != nil {
+		return "", 0, err
+	}
+	return texts[0], tokens, nil
+}
+
+// complete sends a system and user message and returns n replies and the
+// number of tokens used. The replies follow schema, unless the model rejects
+// it, when it is asked again with the prompt alone.
+func (p *OpenAIProvider) complete(ctx context.Context, system, prompt string, schema *llm.Schema, n int) ([]string, int, error) {
+	req := openai.ChatCompletionRequest{
+		Model: p.model,
+		N:     n,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: system,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
+			},
+		},
+		ResponseFormat: p.responseFormat(schema),
+	}
+
+	var resp openai.ChatCompletionResponse
+	send := func() error {
+		return llm.DefaultBackoff.Retry(ctx, func() (err error) {
+			resp, err = p.client.CreateChatCompletion(ctx, req)
+			return providerError(err)
+		})
+	}
+
+	err := send()
+	if req.ResponseFormat != nil && p.rejected(err) {
+		req.ResponseFormat = nil
+		err = send()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
 	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("no response choices received")
+		return nil, 0, llm.BadResponse("openai", "no response choices received")
 	}
 
-	result := strings.TrimSpace(resp.Choices[0].Message.Content)
-	// Strip markdown code blocks
-	result = strings.TrimPrefix(result, "```json")
-	result = strings.TrimPrefix(result, "```")
-	result = strings.TrimSuffix(result, "```")
+	texts := make([]string, len(resp.Choices))
+	for i, choice := range resp.Choices {
+		if choice.FinishReason == openai.FinishReasonLength {
+			return nil, 0, llm.CutOff("openai")
+		}
+		texts[i] = choice.Message.Content
+	}
+	return texts, resp.Usage.TotalTokens, nil
+}
+
+// completeStream sends a system and user message and streams the reply to
+// onToken as it arrives. It returns the whole reply and the number of tokens
+// used. The schema is handled as in complete.
+func (p *OpenAIProvider) completeStream(ctx context.Context, system, prompt string, schema *llm.Schema, onToken func(string)) (string, int, error) {
+	req := openai.ChatCompletionRequest{
+		Model: p.model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: system,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
+			},
+		},
+		ResponseFormat: p.responseFormat(schema),
+		Stream:         true,
+		StreamOptions:  &openai.StreamOptions{IncludeUsage: true},
+	}
 
-	var cmd model.CommandResult
-	if err := json.Unmarshal([]byte(result), &cmd); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w. raw: %s", err, result)
+	var stream *openai.ChatCompletionStream
+	send := func() error {
+		return llm.DefaultBackoff.Retry(ctx, func() (err error) {
+			stream, err = p.client.CreateChatCompletionStream(ctx, req)
+			return providerError(err)
+		})
 	}
 
-	cmd.Metrics = model.Metrics{
-		Latency:    latency.Round(time.Millisecond).String(),
-		TokenCount: resp.Usage.TotalTokens,
+	err := send()
+	if req.ResponseFormat != nil && p.rejected(err) {
+		req.ResponseFormat = nil
+		err = send()
+	}
+	if err != nil {
+		return "", 0, err
 	}
+	defer stream.Close()
 
-	return &cmd, nil
+	var sb strings.Builder
+	var tokens int
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", 0, providerError(err)
+		}
+		// The usage comes in a last chunk without choices
+		if resp.Usage != nil {
+			tokens = resp.Usage.TotalTokens
+		}
+		for _, choice := range resp.Choices {
+			if choice.Delta.Content != "" {
+				sb.WriteString(choice.Delta.Content)
+				onToken(choice.Delta.Content)
+			}
+			if choice.FinishReason == openai.FinishReasonLength {
+				return "", 0, llm.CutOff("openai")
+			}
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "", 0, llm.BadResponse("openai", "no response choices received")
+	}
+	return sb.String(), tokens, nil
+}
+
+// responseFormat asks for replies that follow schema, enforced exactly in
+// strict mode. Strict mode can't express free-form maps, so the environment
+// of a step is left out of what is sent.
+func (p *OpenAIProvider) responseFormat(schema *llm.Schema) *openai.ChatCompletionResponseFormat {
+	if schema == nil || p.promptOnly {
+		return nil
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   "response",
+			Schema: schema.Strict(),
+			Strict: true,
+		},
+	}
+}
+
+// rejected reports whether err is the API refusing the response format, as
+// it does for models without structured output. The provider then stops
+// sending a schema. Other bad requests are returned as they are.
+func (p *OpenAIProvider) rejected(err error) bool {
+	if llm.Rejected(err, "response_format", "json_schema") {
+		p.promptOnly = true
+		return true
+	}
+	return false
+}
+
+// providerError maps an error from the OpenAI client to an
+// *llm.ProviderError
+func providerError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return &llm.ProviderError{
+			Provider: "openai",
+			Kind:     llm.Classify(apiErr.HTTPStatusCode, fmt.Sprintf("%v %s %s", apiErr.Code, apiErr.Type, apiErr.Message)),
+			Status:   apiErr.HTTPStatusCode,
+			Message:  apiErr.Message,
+			Err:      err,
+		}
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		perr := llm.HTTPError("openai", reqErr.HTTPStatusCode, nil, reqErr.Body)
+		perr.Err = err
+		return perr
+	}
+	return llm.RequestError("openai", err)
 }
@@ -0,0 +1,45 @@
+package openai
+
+import (
+	"errors"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+)
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		rejected bool
+	}{
+		{"unsupported response format", &openai.APIError{HTTPStatusCode: 400, Message: "Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model."}, true},
+		{"other bad request", &openai.APIError{HTTPStatusCode: 400, Message: "This model's maximum context length is 8192 tokens."}, false},
+		{"auth", &openai.APIError{HTTPStatusCode: 401, Message: "Incorrect API key provided"}, false},
+		{"network", errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &OpenAIProvider{}
+			if got := p.rejected(providerError(tt.err)); got != tt.rejected || p.promptOnly != tt.rejected {
+				t.Errorf("Expected rejected=%v, got %v (promptOnly %v)", tt.rejected, got, p.promptOnly)
+			}
+		})
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	p := &OpenAIProvider{}
+	format := p.responseFormat(llm.CommandSchema)
+	if format == nil || format.JSONSchema == nil || !format.JSONSchema.Strict {
+		t.Fatalf("Expected a strict JSON schema, got %+v", format)
+	}
+
+	p.promptOnly = true
+	if format := p.responseFormat(llm.CommandSchema); format != nil {
+		t.Errorf("Expected no response format once rejected, got %+v", format)
+	}
+}
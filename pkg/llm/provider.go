@@ -15,13 +15,28 @@ type SystemMetadata struct {
 	AvailableCommands []string
 	CurrentDirFiles   []string
 	PreviousError     string
+	PreviousCommand   string             // The command that produced PreviousError, if known
+	UnavailableTools  []string           // Tools a previous answer used that are not installed
+	Alternatives      int                // How many candidates are being asked for, when more than one
+	AvoidCommands     []string           // Candidates already suggested, to get a different one
+	Conversation      []ConversationTurn // Earlier turns of a chat session, oldest first
 	FewShotExamples   []brain.BrainEntry
 }
 
+// ConversationTurn is an earlier request in a chat session and the command
+// it produced, which the current request refines
+type ConversationTurn struct {
+	Query   string
+	Command string
+	Outcome string // How running it went, empty if it wasn't run
+}
+
 // Provider defines the interface for an LLM provider
 type Provider interface {
 	// GenerateCommand generates a shell command based on the query and system metadata
 	GenerateCommand(ctx context.Context, query string, meta SystemMetadata) (*model.CommandResult, error)
+	// ExplainCommand breaks down an existing command step by step and argument by argument
+	ExplainCommand(ctx context.Context, command string, meta SystemMetadata) (*model.CommandExplanation, error)
 }
 
 // ProviderConfig holds configuration for creating a provider
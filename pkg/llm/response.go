@@ -0,0 +1,238 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseResponse decodes a model's reply into v, which schema describes. The
+// JSON object is cut out of any chatter around it and repaired if it is
+// malformed. Its values are then checked against the schema, converting
+// those that are only written the wrong way, like "false" for false. The
+// error says what is wrong in words the model can act on.
+func ParseResponse(text string, schema *Schema, v any) error {
+	text = ExtractJSON(text)
+	if !strings.HasPrefix(text, "{") {
+		return errors.New("the reply contains no JSON object")
+	}
+
+	var data any
+	if err := json.Unmarshal([]byte(text), &data); err != nil {
+		repaired := RepairJSON(text)
+		if objectEnd(repaired) == 0 {
+			return errors.New("the reply was cut off before its JSON object was complete")
+		}
+		if json.Unmarshal([]byte(repaired), &data) != nil {
+			return fmt.Errorf("the reply is not valid JSON: %w", err)
+		}
+	}
+
+	data, err := schema.coerce(data, "")
+	if err != nil {
+		return err
+	}
+	fixed, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	return json.Unmarshal(fixed, v)
+}
+
+// ParseOrRetry is ParseResponse, but when the reply can't be used retry is
+// called with the reason to get another one, once. If the second reply can't
+// be used either the error is an ErrBadResponse. If retry itself fails its
+// error is returned, so that its kind still matches with errors.Is, with the
+// first problem added.
+func ParseOrRetry(text string, schema *Schema, v any, retry func(problem error) (string, error)) error {
+	err := ParseResponse(text, schema, v)
+	if err == nil {
+		return nil
+	}
+
+	again, retryErr := retry(err)
+	if retryErr != nil {
+		return fmt.Errorf("%w (after: %v)", retryErr, err)
+	}
+	if err := ParseResponse(again, schema, v); err != nil {
+		return fmt.Errorf("%w: %w. raw: %s", ErrBadResponse, err, again)
+	}
+	return nil
+}
+
+// coerce checks value against the schema and converts what can be, naming
+// the offending field by its path when something can't
+func (s *Schema) coerce(value any, path string) (any, error) {
+	switch s.Type {
+	case "object":
+		// Some models send the object again as a JSON string
+		if text, ok := value.(string); ok {
+			var inner map[string]any
+			if json.Unmarshal([]byte(text), &inner) == nil {
+				value = inner
+			}
+		}
+		if list, ok := value.([]any); ok && s.AdditionalProperties != nil {
+			value = entries(list)
+		}
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, mismatch(path, "an object", value)
+		}
+		for key, val := range m {
+			sub := s.Properties[key]
+			if sub == nil {
+				sub = s.AdditionalProperties
+			}
+			if sub == nil {
+				continue
+			}
+			if val == nil {
+				delete(m, key)
+				continue
+			}
+			coerced, err := sub.coerce(val, field(path, key))
+			if err != nil {
+				return nil, err
+			}
+			m[key] = coerced
+		}
+		for _, key := range s.Required {
+			if _, ok := m[key]; !ok {
+				return nil, fmt.Errorf("%s is missing", field(path, key))
+			}
+		}
+		return m, nil
+
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			// A single object where a list of them was asked for
+			if _, isObject := value.(map[string]any); isObject && s.Items != nil && s.Items.Type == "object" {
+				items = []any{value}
+			} else {
+				return nil, mismatch(path, "an array", value)
+			}
+		}
+		if s.Items == nil {
+			return items, nil
+		}
+		out := items[:0]
+		for i, item := range items {
+			if item == nil {
+				continue
+			}
+			coerced, err := s.Items.coerce(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, coerced)
+		}
+		return out, nil
+
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return s.normalize(v), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		return nil, mismatch(path, "a string", value)
+
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes":
+				return true, nil
+			case "false", "no", "":
+				return false, nil
+			}
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		}
+		return nil, mismatch(path, "true or false", value)
+
+	case "integer", "number":
+		switch v := value.(type) {
+		case float64:
+			if s.Type == "integer" && v != float64(int64(v)) {
+				return nil, mismatch(path, "a whole number", value)
+			}
+			return v, nil
+		case string:
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return s.coerce(n, path)
+			}
+		}
+		return nil, mismatch(path, "a number", value)
+	}
+	return value, nil
+}
+
+// entries turns a map sent as a list of {"name", "value"} objects, the way
+// MapsAsLists describes it, back into a map. Anything else is returned as it
+// was.
+func entries(list []any) any {
+	m := make(map[string]any, len(list))
+	for _, item := range list {
+		entry, ok := item.(map[string]any)
+		if !ok {
+			return list
+		}
+		name, ok := entry["name"].(string)
+		if !ok {
+			return list
+		}
+		m[name] = entry["value"]
+	}
+	return m
+}
+
+// normalize matches a string to the enum value it differs from only in case
+// or spacing. Other values are kept, for cmdfy's own checks to judge.
+func (s *Schema) normalize(v string) string {
+	for _, e := range s.Enum {
+		if strings.EqualFold(strings.TrimSpace(v), e) {
+			return e
+		}
+	}
+	return v
+}
+
+func field(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func mismatch(path, want string, got any) error {
+	if path == "" {
+		path = "the reply"
+	}
+	var kind string
+	switch got.(type) {
+	case map[string]any:
+		kind = "an object"
+	case []any:
+		kind = "an array"
+	case string:
+		kind = fmt.Sprintf("the string %q", got)
+	case float64:
+		kind = fmt.Sprintf("the number %v", got)
+	case bool:
+		kind = fmt.Sprintf("%v", got)
+	default:
+		kind = fmt.Sprintf("%v", got)
+	}
+	return fmt.Errorf("%s should be %s, not %s", path, want, kind)
+}
@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+func readFixture(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join("testdata", "responses", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestParseResponse_Fixtures parses replies models really sent, each broken
+// in its own way, that cmdfy should still understand
+func TestParseResponse_Fixtures(t *testing.T) {
+	tests := []struct {
+		file        string
+		command     string
+		explanation string
+		risk        model.RiskLevel
+	}{
+		{"chatter.txt", "du -ah . | sort -rh", "Lists every file with its size, largest first", model.RiskNone},
+		{"fenced_with_note.txt", "grep -rn TODO .", "Searches every file below the current directory for TODO", model.RiskNone},
+		{"trailing_commas.txt", "tar -czf backup.tar.gz src", "Archives the src folder", model.RiskLow},
+		{"smart_quotes.txt", "ls -la", "Lists all files, including hidden ones", model.RiskNone},
+		{"dangerous_string.txt", "rm -rf build", "Deletes the build folder", model.RiskHigh},
+		{"python_dict.txt", "git log --oneline", "Shows the commit history, one line per commit", model.RiskNone},
+		{"comments.txt", "ps aux | grep node", "Finds running node processes", model.RiskNone},
+		{"raw_newlines.txt", "df -h", "Shows disk usage.\nSizes are human readable.", model.RiskNone},
+		{"wrong_types.txt", "head -n 20 log.txt 2> /dev/null", "Shows the first 20 lines of the log", model.RiskLow},
+		{"unquoted_keys.txt", "pwd", "Prints the working directory", model.RiskNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			var result model.CommandResult
+			if err := ParseResponse(readFixture(t, tt.file), CommandSchema, &result); err != nil {
+				t.Fatalf("ParseResponse failed: %v", err)
+			}
+			if got := result.Render(model.ShellFromPath("/bin/bash")); got != tt.command {
+				t.Errorf("Expected command %q, got %q", tt.command, got)
+			}
+			if result.Explanation != tt.explanation {
+				t.Errorf("Expected explanation %q, got %q", tt.explanation, result.Explanation)
+			}
+			if result.Risk.Level != tt.risk {
+				t.Errorf("Expected risk %s, got %s", tt.risk, result.Risk.Level)
+			}
+		})
+	}
+}
+
+// TestParseResponse_Unusable checks that replies which can't be repaired
+// fail with a reason the model can act on when it is asked again
+func TestParseResponse_Unusable(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"refusal.txt", "no JSON object"},
+		{"args_as_string.txt", "steps[0].args should be an array, not the string \"-la\""},
+		{"missing_steps.txt", "steps is missing"},
+		{"truncated.txt", "cut off"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			var result model.CommandResult
+			err := ParseResponse(readFixture(t, tt.file), CommandSchema, &result)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseResponse_Coercion(t *testing.T) {
+	var result model.CommandResult
+	text := `{"steps": [{"tool": "sleep", "args": [5], "background": "yes", "redirects": {"op": ">", "target": "out.log"}}], "explanation": "Waits", "risk": {"level": " HIGH ", "categories": ["Irreversible", "made_up"]}}`
+	if err := ParseResponse(text, CommandSchema, &result); err != nil {
+		t.Fatalf("ParseResponse failed: %v", err)
+	}
+
+	step := result.Steps[0]
+	if len(step.Args) != 1 || step.Args[0] != "5" {
+		t.Errorf("Expected the number as a string argument, got %q", step.Args)
+	}
+	if !step.Background {
+		t.Error("Expected \"yes\" to be read as true")
+	}
+	if len(step.Redirects) != 1 || step.Redirects[0].Target != "out.log" {
+		t.Errorf("Expected a single redirect to become a list, got %+v", step.Redirects)
+	}
+	if result.Risk.Level != model.RiskHigh {
+		t.Errorf("Expected the level to be normalized, got %q", result.Risk.Level)
+	}
+	if got := result.Risk.Categories; len(got) != 2 || got[0] != model.CategoryIrreversible || got[1] != "made_up" {
+		t.Errorf("Expected known categories normalized and unknown ones kept, got %v", got)
+	}
+}
+
+func TestParseResponse_MapAsList(t *testing.T) {
+	// Strict schemas describe env as a list of entries
+	var result model.CommandResult
+	text := `{"steps": [{"tool": "make", "env": [{"name": "CC", "value": "clang"}, {"name": "DEBUG", "value": "1"}]}], "explanation": "Builds"}`
+	if err := ParseResponse(text, CommandSchema, &result); err != nil {
+		t.Fatalf("ParseResponse failed: %v", err)
+	}
+	if env := result.Steps[0].Env; len(env) != 2 || env["CC"] != "clang" || env["DEBUG"] != "1" {
+		t.Errorf("Expected the entries as a map, got %v", env)
+	}
+}
+
+func TestParseOrRetry(t *testing.T) {
+	var problems []string
+	retry := func(problem error) (string, error) {
+		problems = append(problems, problem.Error())
+		return `{"steps": [{"tool": "ls", "args": ["-la"]}], "explanation": "Lists files"}`, nil
+	}
+
+	var result model.CommandResult
+	if err := ParseOrRetry(readFixture(t, "args_as_string.txt"), CommandSchema, &result, retry); err != nil {
+		t.Fatalf("ParseOrRetry failed: %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "steps[0].args") {
+		t.Errorf("Expected one retry with the problem, got %q", problems)
+	}
+	if result.Steps[0].Args[0] != "-la" {
+		t.Errorf("Expected the retried reply, got %+v", result)
+	}
+
+	// A reply that parses is not retried
+	problems = nil
+	if err := ParseOrRetry(readFixture(t, "chatter.txt"), CommandSchema, &result, retry); err != nil || len(problems) != 0 {
+		t.Errorf("Expected no retry, got %v and %q", err, problems)
+	}
+
+	// When the retry fails too, its error is returned with the first problem
+	err := ParseOrRetry(readFixture(t, "refusal.txt"), CommandSchema, &result, func(error) (string, error) {
+		return "", &ProviderError{Provider: "p", Kind: ErrRateLimited, Message: "slow down"}
+	})
+	if !errors.Is(err, ErrRateLimited) || !strings.Contains(err.Error(), "no JSON object") {
+		t.Errorf("Expected the retry's error and the parse error, got %v", err)
+	}
+}
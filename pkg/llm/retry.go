@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff says how often and how patiently failed requests are retried
+type Backoff struct {
+	// Attempts is how many times a request is tried in all
+	Attempts int
+	// Base is the wait before the first retry, doubled for each one after
+	Base time.Duration
+	// Max caps the wait, including one asked for with Retry-After
+	Max time.Duration
+	// OnRetry, if set, is called before each wait
+	OnRetry func(err error, wait time.Duration)
+}
+
+// DefaultBackoff is what providers retry with
+var DefaultBackoff = Backoff{
+	Attempts: 3,
+	Base:     500 * time.Millisecond,
+	Max:      30 * time.Second,
+}
+
+// Retry calls fn until it succeeds or fails with an error that trying again
+// won't fix, at most b.Attempts times. Between tries it waits as long as the
+// provider asked, or else a jittered exponential backoff. The wait ends early
+// when ctx is done.
+func (b Backoff) Retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		var perr *ProviderError
+		if !errors.As(err, &perr) || !perr.Temporary() || attempt+1 >= b.Attempts {
+			return err
+		}
+
+		wait := b.wait(attempt, perr.RetryAfter)
+		if b.OnRetry != nil {
+			b.OnRetry(err, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// wait is how long to sleep before retry number attempt+1. Without a
+// Retry-After the delay is drawn from the upper half of the backoff, so that
+// clients that failed together don't retry together.
+func (b Backoff) wait(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, b.Max)
+	}
+	d := min(b.Base<<attempt, b.Max)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
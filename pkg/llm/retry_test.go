@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Retry(t *testing.T) {
+	var waits []time.Duration
+	b := Backoff{Attempts: 3, Base: time.Millisecond, Max: 10 * time.Millisecond, OnRetry: func(err error, wait time.Duration) {
+		waits = append(waits, wait)
+	}}
+
+	calls := 0
+	err := b.Retry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return HTTPError("test", http.StatusServiceUnavailable, nil, nil)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third call, got %v after %d", err, calls)
+	}
+	if len(waits) != 2 || waits[0] > time.Millisecond || waits[1] > 2*time.Millisecond {
+		t.Errorf("Unexpected waits: %v", waits)
+	}
+}
+
+func TestBackoff_Retry_GivesUp(t *testing.T) {
+	b := Backoff{Attempts: 3, Base: time.Millisecond, Max: time.Millisecond}
+
+	calls := 0
+	err := b.Retry(context.Background(), func() error {
+		calls++
+		return HTTPError("test", http.StatusTooManyRequests, nil, nil)
+	})
+	if !errors.Is(err, ErrRateLimited) || calls != 3 {
+		t.Errorf("Expected the last error after 3 calls, got %v after %d", err, calls)
+	}
+
+	// Errors that won't go away are returned at once
+	for _, fail := range []error{HTTPError("test", http.StatusUnauthorized, nil, nil), errors.New("bad request")} {
+		calls = 0
+		if err := b.Retry(context.Background(), func() error { calls++; return fail }); err != fail || calls != 1 {
+			t.Errorf("Expected %v without retrying, got %v after %d calls", fail, err, calls)
+		}
+	}
+}
+
+func TestBackoff_Retry_RetryAfter(t *testing.T) {
+	var waits []time.Duration
+	b := Backoff{Attempts: 2, Base: time.Millisecond, Max: 20 * time.Millisecond, OnRetry: func(err error, wait time.Duration) {
+		waits = append(waits, wait)
+	}}
+
+	// The provider's wait is honored, but no longer than Max
+	for _, asked := range []string{"0.01", "60"} {
+		waits = nil
+		calls := 0
+		b.Retry(context.Background(), func() error {
+			calls++
+			if calls == 1 {
+				return HTTPError("test", http.StatusTooManyRequests, http.Header{"Retry-After": []string{asked}}, nil)
+			}
+			return nil
+		})
+		if want := min(RetryAfter(http.Header{"Retry-After": []string{asked}}), b.Max); len(waits) != 1 || waits[0] != want {
+			t.Errorf("Retry-After %s: expected to wait %s, got %v", asked, want, waits)
+		}
+	}
+}
+
+func TestBackoff_Retry_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := Backoff{Attempts: 5, Base: time.Hour, Max: time.Hour, OnRetry: func(error, time.Duration) { cancel() }}
+
+	calls := 0
+	start := time.Now()
+	err := b.Retry(ctx, func() error {
+		calls++
+		return HTTPError("test", http.StatusServiceUnavailable, nil, nil)
+	})
+	if err == nil || calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected to stop waiting once canceled, got %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,189 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// Schema is the subset of JSON schema cmdfy generates for the responses it
+// asks for. Providers that can enforce a schema send it with the request.
+type Schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+}
+
+// JSON returns the schema as a JSON document
+func (s *Schema) JSON() json.RawMessage {
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// Strict returns the schema in the form OpenAI's strict structured outputs
+// accept: objects allow no other properties and list every property as
+// required, the optional ones as nullable. Free-form maps, like the
+// environment of a step, become lists of entries as in MapsAsLists.
+func (s *Schema) Strict() json.RawMessage {
+	data, _ := json.Marshal(s.MapsAsLists().strict(false))
+	return data
+}
+
+// MapsAsLists returns a copy of the schema with every free-form map written
+// as an array of {"name", "value"} objects, for backends that only accept
+// objects with fixed properties. ParseResponse turns such lists back into
+// maps.
+func (s *Schema) MapsAsLists() *Schema {
+	if s.Type == "object" && s.AdditionalProperties != nil && len(s.Properties) == 0 {
+		return &Schema{Type: "array", Items: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"name":  {Type: "string"},
+				"value": s.AdditionalProperties.MapsAsLists(),
+			},
+			Required: []string{"name", "value"},
+		}}
+	}
+
+	out := *s
+	if s.Items != nil {
+		out.Items = s.Items.MapsAsLists()
+	}
+	if s.Properties != nil {
+		out.Properties = make(map[string]*Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			out.Properties[name] = prop.MapsAsLists()
+		}
+	}
+	return &out
+}
+
+func (s *Schema) strict(nullable bool) map[string]any {
+	out := map[string]any{"type": s.Type}
+	if nullable {
+		out["type"] = []string{s.Type, "null"}
+	}
+	if len(s.Enum) > 0 {
+		enum := make([]any, 0, len(s.Enum)+1)
+		for _, e := range s.Enum {
+			enum = append(enum, e)
+		}
+		if nullable {
+			enum = append(enum, nil)
+		}
+		out["enum"] = enum
+	}
+	if s.Items != nil {
+		out["items"] = s.Items.strict(false)
+	}
+	if s.Type == "object" {
+		properties := make(map[string]any, len(s.Properties))
+		required := make([]string, 0, len(s.Properties))
+		for name, prop := range s.Properties {
+			properties[name] = prop.strict(!slices.Contains(s.Required, name))
+			required = append(required, name)
+		}
+		sort.Strings(required)
+		out["properties"] = properties
+		out["required"] = required
+		out["additionalProperties"] = false
+	}
+	return out
+}
+
+// Enumerated is implemented by string types that only take a fixed set of
+// values, which the schema then lists
+type Enumerated interface {
+	Enum() []string
+}
+
+var (
+	// CommandSchema describes the response to a generate prompt
+	CommandSchema = SchemaOf(model.CommandResult{})
+	// ExplanationSchema describes the response to an explain prompt
+	ExplanationSchema = SchemaOf(model.CommandExplanation{})
+)
+
+var enumerated = reflect.TypeOf((*Enumerated)(nil)).Elem()
+
+// maxNesting is how many times a type that contains itself, like the steps
+// of a subshell, is nested before the recursive field is left out. Not every
+// backend supports references, so the schema is written out in full.
+const maxNesting = 2
+
+// SchemaOf generates the schema of v's type from its json tags. Fields
+// tagged json:"-" or schema:"-" are left out, and fields are required unless
+// they are omitempty or tagged schema:"optional".
+func SchemaOf(v any) *Schema {
+	return schemaOf(reflect.TypeOf(v), map[reflect.Type]int{})
+}
+
+func schemaOf(t reflect.Type, visiting map[reflect.Type]int) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Implements(enumerated) {
+		return &Schema{Type: "string", Enum: reflect.Zero(t).Interface().(Enumerated).Enum()}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		visiting[t]++
+		defer func() { visiting[t]-- }()
+
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" || f.Tag.Get("schema") == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if recursive(f.Type, visiting) {
+				continue
+			}
+			s.Properties[name] = schemaOf(f.Type, visiting)
+			if !strings.Contains(opts, "omitempty") && f.Tag.Get("schema") != "optional" {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	return &Schema{Type: "string"}
+}
+
+// recursive reports whether t is, or is a collection of, a struct that is
+// already nested as deep as it may be
+func recursive(t reflect.Type, visiting map[reflect.Type]int) bool {
+	for {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		default:
+			return visiting[t] >= maxNesting
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaOf(t *testing.T) {
+	type item struct {
+		Name  string            `json:"name"`
+		Tags  map[string]string `json:"tags,omitempty"`
+		Count int               `json:"count,omitempty"`
+		Items []item            `json:"items,omitempty"`
+		Skip  string            `json:"-"`
+		Extra string            `json:"extra" schema:"-"`
+	}
+	s := SchemaOf(item{})
+
+	if s.Type != "object" || len(s.Required) != 1 || s.Required[0] != "name" {
+		t.Errorf("Expected only name to be required, got %v", s.Required)
+	}
+	if _, ok := s.Properties["Skip"]; ok {
+		t.Error("Expected json:\"-\" fields to be left out")
+	}
+	if _, ok := s.Properties["extra"]; ok {
+		t.Error("Expected schema:\"-\" fields to be left out")
+	}
+	if s.Properties["count"].Type != "integer" {
+		t.Errorf("Expected an integer, got %s", s.Properties["count"].Type)
+	}
+	if tags := s.Properties["tags"]; tags.Type != "object" || tags.AdditionalProperties.Type != "string" {
+		t.Errorf("Expected a map of strings, got %+v", tags)
+	}
+
+	// Nested once, then the recursive field is left out
+	nested := s.Properties["items"].Items
+	if nested == nil || nested.Properties["name"] == nil {
+		t.Fatalf("Expected nested items, got %+v", s.Properties["items"])
+	}
+	if _, ok := nested.Properties["items"]; ok {
+		t.Error("Expected the recursion to stop at the nested items")
+	}
+}
+
+func TestCommandSchema(t *testing.T) {
+	if _, ok := CommandSchema.Properties["metrics"]; ok {
+		t.Error("Expected metrics to be left out")
+	}
+	step := CommandSchema.Properties["steps"].Items
+	if step.Properties["subshell"] == nil {
+		t.Error("Expected subshells to be described")
+	}
+	level := CommandSchema.Properties["risk"].Properties["level"]
+	if len(level.Enum) != 5 {
+		t.Errorf("Expected the risk levels as an enum, got %v", level.Enum)
+	}
+	if _, ok := ExplanationSchema.Properties["summary"]; !ok {
+		t.Error("Expected the explanation schema to have a summary")
+	}
+}
+
+func TestSchema_Strict(t *testing.T) {
+	var s map[string]any
+	if err := json.Unmarshal(CommandSchema.Strict(), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every object must be closed and require all of its properties
+	var check func(path string, s map[string]any)
+	check = func(path string, s map[string]any) {
+		if items, ok := s["items"].(map[string]any); ok {
+			check(path+"[]", items)
+		}
+		props, ok := s["properties"].(map[string]any)
+		if !ok {
+			return
+		}
+		if s["additionalProperties"] != false {
+			t.Errorf("%s: expected additionalProperties false", path)
+		}
+		required, _ := s["required"].([]any)
+		if len(required) != len(props) {
+			t.Errorf("%s: expected all %d properties to be required, got %v", path, len(props), required)
+		}
+		for name, p := range props {
+			check(path+"."+name, p.(map[string]any))
+		}
+	}
+	check("response", s)
+
+	step := s["properties"].(map[string]any)["steps"].(map[string]any)["items"].(map[string]any)
+	props := step["properties"].(map[string]any)
+	env, _ := props["env"].(map[string]any)
+	entry, _ := env["items"].(map[string]any)
+	if entry == nil || entry["properties"].(map[string]any)["name"] == nil {
+		t.Errorf("Expected the env map as a list of entries, got %v", props["env"])
+	}
+	redirect := props["redirects"].(map[string]any)["items"].(map[string]any)["properties"].(map[string]any)
+	if got := redirect["target"].(map[string]any)["type"]; got != "string" {
+		t.Errorf("Expected the required target to be a plain string, got %v", got)
+	}
+	if got, _ := props["op"].(map[string]any)["type"].([]any); len(got) != 2 || got[1] != "null" {
+		t.Errorf("Expected the optional op to be nullable, got %v", props["op"])
+	}
+}
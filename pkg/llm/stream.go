@@ -0,0 +1,84 @@
+package llm
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// StreamingProvider is implemented by providers that can hand out the
+// response while it is being generated
+type StreamingProvider interface {
+	// GenerateCommandStream is GenerateCommand, calling onToken with each
+	// piece of the response as it arrives. The result is parsed at the end.
+	GenerateCommandStream(ctx context.Context, query string, meta SystemMetadata, onToken func(string)) (*model.CommandResult, error)
+}
+
+// TimeFirstToken wraps onToken to note how long after start the first piece
+// of the response arrived, which the returned function reports. A nil
+// onToken stays nil, and the time is zero until something arrived.
+func TimeFirstToken(start time.Time, onToken func(string)) (func(string), func() time.Duration) {
+	var first time.Duration
+	elapsed := func() time.Duration { return first }
+	if onToken == nil {
+		return nil, elapsed
+	}
+	return func(s string) {
+		if first == 0 {
+			first = time.Since(start)
+		}
+		onToken(s)
+	}, elapsed
+}
+
+// fieldPattern finds the start of a JSON string value by its key
+var fieldPattern = regexp.MustCompile(`"([A-Za-z_]+)"\s*:\s*"`)
+
+// PartialString returns the value of the last string field named key in a
+// JSON document that may be cut off, decoded as far as it goes. It is meant
+// for showing a response while it streams in.
+func PartialString(text, key string) (string, bool) {
+	start := -1
+	for _, m := range fieldPattern.FindAllStringSubmatchIndex(text, -1) {
+		if text[m[2]:m[3]] == key {
+			start = m[1]
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+
+	var sb strings.Builder
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			return sb.String(), true
+		case c != '\\':
+			sb.WriteByte(c)
+		case i+1 >= len(text):
+			// The escape is cut off
+		default:
+			i++
+			switch text[i] {
+			case 'n', 'r', 't':
+				sb.WriteByte(' ')
+			case 'u':
+				if i+4 >= len(text) {
+					return sb.String(), true
+				}
+				if r, err := strconv.ParseUint(text[i+1:i+5], 16, 32); err == nil {
+					sb.WriteRune(rune(r))
+				}
+				i += 4
+			default:
+				sb.WriteByte(text[i])
+			}
+		}
+	}
+	return sb.String(), true
+}
@@ -0,0 +1,30 @@
+package llm
+
+import "testing"
+
+func TestPartialString(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		key  string
+		want string
+		ok   bool
+	}{
+		{"missing", `{"steps": [`, "explanation", "", false},
+		{"cut off", `{"steps": [], "explanation": "Lists every fi`, "explanation", "Lists every fi", true},
+		{"complete", `{"explanation": "Lists files", "risk": {}}`, "explanation", "Lists files", true},
+		{"last one wins", `{"steps": [{"explanation": "step"}], "explanation": "whole`, "explanation", "whole", true},
+		{"escapes", `{"explanation": "Quotes \"a\\b\"\nand é`, "explanation", `Quotes "a\b" and é`, true},
+		{"escape cut off", `{"explanation": "ends with \`, "explanation", "ends with ", true},
+		{"unicode cut off", `{"explanation": "caf\u00`, "explanation", "caf", true},
+		{"other key", `{"tool": "grep"`, "explanation", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := PartialString(tt.text, tt.key)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("PartialString() = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -1,24 +1,117 @@
 package model
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
 // CommandStep represents a single step in a command pipeline
 type CommandStep struct {
-	Tool string   `json:"tool"`
-	Args []string `json:"args"`
-	// Op is the operator connecting this step to the next (e.g., "|", "&&", ";", "||", ">", ">>")
+	// Tool and Args may be left out by the model, for a subshell or a tool
+	// without arguments
+	Tool string   `json:"tool" schema:"optional"`
+	Args []string `json:"args" schema:"optional"`
+	// Expand lets the shell expand globs, a leading ~ and $NAME or ${NAME}
+	// in Args, Env values and redirect targets, which otherwise reach the
+	// program literally. A backslash keeps the character after it literal.
+	// Command substitution never runs: $(...), backquotes and any other
+	// ${...} stay literal. Shells other than POSIX ones expand nothing.
+	Expand bool `json:"expand,omitempty"`
+	// Env holds variables set for this step only (FOO=bar cmd)
+	Env map[string]string `json:"env,omitempty"`
+	// Redirects are applied to this step, e.g. 2>&1 or < input.txt
+	Redirects []Redirect `json:"redirects,omitempty"`
+	// Stdin is literal text fed to the step's standard input (a heredoc)
+	Stdin string `json:"stdin,omitempty"`
+	// Subshell groups steps that run in their own subshell, (cd x && make).
+	// When set, Tool and Args are ignored.
+	Subshell []CommandStep `json:"subshell,omitempty"`
+	// Background runs the step as a background job (cmd &). As in the shell,
+	// when the step ends an && or || chain the whole chain goes to the background.
+	Background bool `json:"background,omitempty"`
+	// Explanation describes what this step does on its own
+	Explanation string `json:"explanation,omitempty"`
+	// Op is the operator connecting this step to the next (e.g., "|", "&&", ";", "||").
+	// The legacy ">", ">>" and "<" operators make the next step's Tool a filename.
 	Op string `json:"op,omitempty"`
 }
 
+// Redirect is an explicit file descriptor redirection
+type Redirect struct {
+	// FD is the descriptor being redirected. Zero means the operator's
+	// default: stdin for "<", stdout for ">" and ">>".
+	FD int `json:"fd,omitempty"`
+	// Op is one of ">", ">>", ">|", "<", ">&", "<&", "&>" or "&>>"
+	Op string `json:"op"`
+	// Target is a filename, or a descriptor number for ">&" and "<&"
+	Target string `json:"target"`
+}
+
+// Writes reports whether the redirect writes to its target file
+func (r Redirect) Writes() bool {
+	switch r.Op {
+	case ">", ">>", "&>", "&>>", ">|":
+		return true
+	}
+	return false
+}
+
+// Duplicates reports whether the redirect copies another descriptor (2>&1)
+// rather than opening a file.
+func (r Redirect) Duplicates() bool {
+	return r.Op == ">&" || r.Op == "<&"
+}
+
 // Metrics holds performance and cost metrics for the generation
 type Metrics struct {
-	Latency      string `json:"latency"` // e.g., "1.2s"
-	TokenCount   int    `json:"token_count,omitempty"`
-	CostEstimate string `json:"cost_estimate,omitempty"` // Approximation if possible
+	Latency          string `json:"latency"`                       // e.g., "1.2s"
+	TimeToFirstToken string `json:"time_to_first_token,omitempty"` // Set when the response was streamed
+	TokenCount       int    `json:"token_count,omitempty"`
+	CostEstimate     string `json:"cost_estimate,omitempty"` // Approximation if possible
 }
 
 // CommandResult represents the full generated command pipeline
 type CommandResult struct {
 	Steps       []CommandStep `json:"steps"`
 	Explanation string        `json:"explanation"`
-	Dangerous   bool          `json:"dangerous"`
-	Metrics     Metrics       `json:"metrics,omitempty"`
+	// Risk may be left out by the model, in which case the legacy
+	// "dangerous" flag and cmdfy's own assessment decide it
+	Risk Risk `json:"risk,omitempty"`
+	// TradeOff says what sets this command apart from other candidates for
+	// the same request, e.g. portable vs fast
+	TradeOff string `json:"trade_off,omitempty"`
+	// AffectedPaths lists the files the model expects the command to change
+	AffectedPaths []string `json:"affected_paths,omitempty"`
+	// Parameters are the {{name}} placeholders the user fills in before the
+	// command is rendered
+	Parameters []Parameter `json:"parameters,omitempty"`
+	// Metrics are filled in by cmdfy, not the model
+	Metrics Metrics `json:"metrics,omitempty" schema:"-"`
+	// Template is the command before Fill substituted its parameters
+	Template *CommandResult `json:"-"`
+}
+
+// UnmarshalJSON accepts both the structured "risk" object and the legacy
+// "dangerous" boolean that older prompts and brain entries still carry.
+func (r *CommandResult) UnmarshalJSON(data []byte) error {
+	type plain CommandResult
+	aux := struct {
+		*plain
+		// A boolean, or a string some models write instead
+		Dangerous json.RawMessage `json:"dangerous"`
+	}{plain: (*plain)(r)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.Risk.Level = RiskLevel(strings.ToLower(strings.TrimSpace(string(r.Risk.Level))))
+	if r.Risk.Level == "" {
+		r.Risk.Level = RiskNone
+		if dangerous, _ := strconv.ParseBool(strings.Trim(string(aux.Dangerous), `"`)); dangerous {
+			r.Risk.Level = RiskHigh
+		}
+	}
+	return nil
 }
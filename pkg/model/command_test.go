@@ -48,3 +48,78 @@ func TestCommandResult_JSON(t *testing.T) {
 		t.Errorf("Expected token count 150, got %d", result.Metrics.TokenCount)
 	}
 }
+
+func TestCommandResult_RiskJSON(t *testing.T) {
+	jsonStr := `
+	{
+		"steps": [{"tool": "rm", "args": ["-rf", "build"]}],
+		"explanation": "Remove the build directory",
+		"risk": {
+			"level": "High",
+			"categories": ["deletes_data", "irreversible"],
+			"steps": [{"step": 0, "reason": "recursively deletes build"}]
+		}
+	}`
+
+	var result CommandResult
+	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+
+	if result.Risk.Level != RiskHigh {
+		t.Errorf("Expected level 'high', got '%s'", result.Risk.Level)
+	}
+	if !result.Risk.HasCategory(CategoryIrreversible) {
+		t.Errorf("Expected irreversible category, got %v", result.Risk.Categories)
+	}
+	if reasons := result.Risk.StepReasons(0); len(reasons) != 1 {
+		t.Errorf("Expected 1 reason for step 0, got %v", reasons)
+	}
+}
+
+func TestCommandResult_LegacyDangerous(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want RiskLevel
+	}{
+		{"dangerous true", `{"steps": [], "explanation": "", "dangerous": true}`, RiskHigh},
+		{"dangerous false", `{"steps": [], "explanation": "", "dangerous": false}`, RiskNone},
+		{"dangerous as a string", `{"steps": [], "explanation": "", "dangerous": "true"}`, RiskHigh},
+		{"dangerous false as a string", `{"steps": [], "explanation": "", "dangerous": "false"}`, RiskNone},
+		{"no risk at all", `{"steps": [], "explanation": ""}`, RiskNone},
+		{"risk wins over dangerous", `{"steps": [], "dangerous": true, "risk": {"level": "low"}}`, RiskLow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result CommandResult
+			if err := json.Unmarshal([]byte(tt.json), &result); err != nil {
+				t.Fatalf("Failed to unmarshal JSON: %v", err)
+			}
+			if result.Risk.Level != tt.want {
+				t.Errorf("Expected level '%s', got '%s'", tt.want, result.Risk.Level)
+			}
+		})
+	}
+}
+
+func TestRisk_Merge(t *testing.T) {
+	model := Risk{Level: RiskLow, Categories: []RiskCategory{CategoryNetworkEgress}}
+	local := Risk{
+		Level:      RiskCritical,
+		Categories: []RiskCategory{CategoryDeletesData, CategoryNetworkEgress},
+		Steps:      []StepRisk{{Step: 1, Level: RiskCritical, Reason: "deletes /"}},
+	}
+
+	merged := model.Merge(local)
+	if merged.Level != RiskCritical {
+		t.Errorf("Expected level 'critical', got '%s'", merged.Level)
+	}
+	if len(merged.Categories) != 2 {
+		t.Errorf("Expected 2 unique categories, got %v", merged.Categories)
+	}
+	if !merged.IsDangerous() {
+		t.Error("Expected merged risk to be dangerous")
+	}
+}
@@ -0,0 +1,25 @@
+package model
+
+// CommandExplanation is a breakdown of an existing command
+type CommandExplanation struct {
+	Summary string            `json:"summary"`
+	Steps   []StepExplanation `json:"steps"`
+	Risk    Risk              `json:"risk"`
+	Metrics Metrics           `json:"metrics,omitempty" schema:"-"`
+}
+
+// StepExplanation explains one command of a pipeline or list
+type StepExplanation struct {
+	// Command is the step as written, e.g. find . -name '*.log'
+	Command string           `json:"command"`
+	Summary string           `json:"summary"`
+	Args    []ArgExplanation `json:"args"`
+	// Op joins this step to the next, as in CommandStep
+	Op string `json:"op,omitempty"`
+}
+
+// ArgExplanation explains the tool, one option or one operand of a step
+type ArgExplanation struct {
+	Arg     string `json:"arg"`
+	Meaning string `json:"meaning"`
+}
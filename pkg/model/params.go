@@ -0,0 +1,170 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParamType constrains the values a Parameter accepts
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamPath   ParamType = "path"
+	ParamHost   ParamType = "host"
+	ParamNumber ParamType = "number"
+)
+
+// Enum lists the parameter types
+func (ParamType) Enum() []string {
+	return []string{string(ParamString), string(ParamPath), string(ParamHost), string(ParamNumber)}
+}
+
+// Parameter is a value the request left open, written as {{name}} in the
+// command instead of an invented filename or host
+type Parameter struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Default     string    `json:"default,omitempty"`
+	Type        ParamType `json:"type,omitempty"`
+}
+
+// paramName is what a parameter may be called, which is also a valid shell
+// variable name
+const paramName = `[A-Za-z_][A-Za-z0-9_]*`
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{\{\s*(` + paramName + `)\s*\}\}`)
+	paramNamePattern   = regexp.MustCompile(`^` + paramName + `$`)
+	// hostPattern accepts host, user@host and host:port, including [IPv6]
+	hostPattern = regexp.MustCompile(`^([A-Za-z0-9._-]+@)?([A-Za-z0-9.-]+|\[[0-9A-Fa-f:.]+\])(:[0-9]+)?$`)
+)
+
+// Validate checks a value against the parameter's type
+func (p Parameter) Validate(value string) error {
+	switch p.Type {
+	case ParamNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number, got %q", p.Name, value)
+		}
+	case ParamHost:
+		if !hostPattern.MatchString(value) {
+			return fmt.Errorf("%s must be a host name or address, got %q", p.Name, value)
+		}
+	case ParamPath:
+		if value == "" || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("%s must be a path, got %q", p.Name, value)
+		}
+	}
+	return nil
+}
+
+// Params returns the declared parameters followed by any placeholder the
+// command uses without declaring it
+func (r *CommandResult) Params() []Parameter {
+	params := append([]Parameter(nil), r.Parameters...)
+	declared := make(map[string]bool, len(params))
+	for _, p := range params {
+		declared[p.Name] = true
+	}
+
+	r.walk(func(s string, expand bool) string {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			if !declared[m[1]] {
+				declared[m[1]] = true
+				params = append(params, Parameter{Name: m[1], Type: ParamString})
+			}
+		}
+		return s
+	})
+	return params
+}
+
+// Fill returns a copy of the command with every placeholder replaced by its
+// value. Values are checked against the parameter types, and a placeholder
+// without a value is an error. The copy keeps the original as its Template.
+func (r *CommandResult) Fill(values map[string]string) (*CommandResult, error) {
+	params := r.Params()
+	if len(params) == 0 {
+		return r, nil
+	}
+	for _, p := range params {
+		value, ok := values[p.Name]
+		if !ok {
+			return nil, fmt.Errorf("no value for {{%s}}", p.Name)
+		}
+		if err := p.Validate(value); err != nil {
+			return nil, err
+		}
+	}
+
+	filled := *r
+	filled.Steps = copySteps(r.Steps)
+	filled.AffectedPaths = append([]string(nil), r.AffectedPaths...)
+	filled.Template = r
+	filled.walk(func(s string, expand bool) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			value := values[placeholderPattern.FindStringSubmatch(m)[1]]
+			if expand {
+				// A value is used as given, not expanded again
+				return EscapeExpansions(value)
+			}
+			return value
+		})
+	})
+	return &filled, nil
+}
+
+// walk replaces every string a placeholder may appear in with f's result.
+// expand tells f whether the shell expands the string, see
+// CommandStep.Expand.
+func (r *CommandResult) walk(f func(s string, expand bool) string) {
+	r.Explanation = f(r.Explanation, false)
+	for i, p := range r.AffectedPaths {
+		r.AffectedPaths[i] = f(p, false)
+	}
+	walkSteps(r.Steps, f)
+}
+
+func walkSteps(steps []CommandStep, f func(string, bool) string) {
+	for i := range steps {
+		s := &steps[i]
+		s.Tool = f(s.Tool, false)
+		for j, a := range s.Args {
+			s.Args[j] = f(a, s.Expand)
+		}
+		for k, v := range s.Env {
+			s.Env[k] = f(v, s.Expand)
+		}
+		for j, rd := range s.Redirects {
+			s.Redirects[j].Target = f(rd.Target, s.Expand && !rd.Duplicates())
+		}
+		s.Stdin = f(s.Stdin, false)
+		s.Explanation = f(s.Explanation, false)
+		walkSteps(s.Subshell, f)
+	}
+}
+
+// copySteps deep copies steps so that filling them leaves the original alone
+func copySteps(steps []CommandStep) []CommandStep {
+	if steps == nil {
+		return nil
+	}
+	out := make([]CommandStep, len(steps))
+	for i, s := range steps {
+		s.Args = append([]string(nil), s.Args...)
+		s.Redirects = append([]Redirect(nil), s.Redirects...)
+		if s.Env != nil {
+			env := make(map[string]string, len(s.Env))
+			for k, v := range s.Env {
+				env[k] = v
+			}
+			s.Env = env
+		}
+		s.Subshell = copySteps(s.Subshell)
+		out[i] = s
+	}
+	return out
+}
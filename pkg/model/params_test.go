@@ -0,0 +1,132 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func archiveCommand() *CommandResult {
+	return &CommandResult{
+		Steps: []CommandStep{
+			{Tool: "tar", Args: []string{"-czf", "{{archive_name}}.tar.gz", "."}, Op: "&&"},
+			{Tool: "scp", Args: []string{"{{archive_name}}.tar.gz", "{{ host }}:{{dest}}"}, Env: map[string]string{"PORT": "{{port}}"}},
+		},
+		Explanation:   "Archive the folder as {{archive_name}} and upload it",
+		AffectedPaths: []string{"{{archive_name}}.tar.gz"},
+		Parameters: []Parameter{
+			{Name: "archive_name", Description: "Name of the archive", Default: "backup", Type: ParamPath},
+			{Name: "host", Type: ParamHost},
+			{Name: "port", Type: ParamNumber, Default: "22"},
+		},
+	}
+}
+
+func TestParams(t *testing.T) {
+	var names []string
+	for _, p := range archiveCommand().Params() {
+		names = append(names, p.Name)
+	}
+	// dest is used but not declared, so it comes last
+	want := []string{"archive_name", "host", "port", "dest"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+}
+
+func TestFill(t *testing.T) {
+	template := archiveCommand()
+	filled, err := template.Fill(map[string]string{
+		"archive_name": "site backup",
+		"host":         "deploy@example.com",
+		"port":         "2222",
+		"dest":         "/srv",
+	})
+	if err != nil {
+		t.Fatalf("Fill failed: %v", err)
+	}
+
+	if got := filled.Render(ShellBash); got != "tar -czf 'site backup.tar.gz' . && PORT=2222 scp 'site backup.tar.gz' deploy@example.com:/srv" {
+		t.Errorf("Unexpected command: %s", got)
+	}
+	if filled.Explanation != "Archive the folder as site backup and upload it" {
+		t.Errorf("Unexpected explanation: %s", filled.Explanation)
+	}
+	if filled.AffectedPaths[0] != "site backup.tar.gz" {
+		t.Errorf("Unexpected affected path: %s", filled.AffectedPaths[0])
+	}
+	if filled.Template != template {
+		t.Error("Expected the template to be kept")
+	}
+	if template.Steps[0].Args[1] != "{{archive_name}}.tar.gz" || template.Steps[1].Env["PORT"] != "{{port}}" {
+		t.Error("Fill modified the template")
+	}
+}
+
+func TestFill_Expand(t *testing.T) {
+	template := &CommandResult{Steps: []CommandStep{{Tool: "cp", Args: []string{"{{file}}", "~/backup/"}, Expand: true}}}
+	filled, err := template.Fill(map[string]string{"file": "report*.txt"})
+	if err != nil {
+		t.Fatalf("Fill failed: %v", err)
+	}
+	// The value is not expanded again, ~ still is
+	if got := filled.Render(ShellBash); got != "cp 'report*.txt' ~/backup/" {
+		t.Errorf("Unexpected command: %s", got)
+	}
+}
+
+func TestFill_Errors(t *testing.T) {
+	values := map[string]string{"archive_name": "b", "host": "example.com", "port": "22", "dest": "/srv"}
+
+	missing := map[string]string{}
+	for k, v := range values {
+		missing[k] = v
+	}
+	delete(missing, "dest")
+	if _, err := archiveCommand().Fill(missing); err == nil {
+		t.Error("Expected an error for a placeholder without a value")
+	}
+
+	for name, bad := range map[string]string{"port": "twenty", "host": "example.com; rm -rf /", "archive_name": ""} {
+		invalid := map[string]string{}
+		for k, v := range values {
+			invalid[k] = v
+		}
+		invalid[name] = bad
+		if _, err := archiveCommand().Fill(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected for %s", bad, name)
+		}
+	}
+}
+
+func TestFill_NoParameters(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{{Tool: "ls"}}}
+	filled, err := result.Fill(nil)
+	if err != nil || filled != result {
+		t.Errorf("Expected the command back unchanged, got %v, %v", filled, err)
+	}
+}
+
+func TestParameterValidate(t *testing.T) {
+	tests := []struct {
+		typ   ParamType
+		value string
+		ok    bool
+	}{
+		{ParamNumber, "3.5", true},
+		{ParamNumber, "x", false},
+		{ParamHost, "example.com", true},
+		{ParamHost, "user@10.0.0.1:2222", true},
+		{ParamHost, "[::1]:22", true},
+		{ParamHost, "two words", false},
+		{ParamPath, "~/a b/c.txt", true},
+		{ParamPath, "", false},
+		{ParamString, "", true},
+		{"", "anything", true},
+	}
+	for _, tt := range tests {
+		err := Parameter{Name: "p", Type: tt.typ}.Validate(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(%s, %q): expected ok=%v, got %v", tt.typ, tt.value, tt.ok, err)
+		}
+	}
+}
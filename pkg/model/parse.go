@@ -0,0 +1,509 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ErrUnsupported is returned by Parse for valid shell syntax that can't be
+// split into steps, such as loops, conditionals and functions
+var ErrUnsupported = errors.New("unsupported shell construct")
+
+// Parse turns a literal shell command line into a CommandResult. It uses a
+// real POSIX/bash grammar, so quoting, pipelines, &&/||/; lists, redirections,
+// env assignments, heredocs, subshells and background jobs are split into
+// steps the same way the shell would.
+//
+// Variables, globs and a leading ~ are kept as their source text (e.g.
+// "$HOME"), since their value is only known at run time. Steps holding them
+// are marked to expand, with the characters that were quoted in them
+// escaped, see CommandStep.Expand. Command substitutions and the other
+// expansions that can run commands are ErrUnsupported.
+func Parse(command string) (*CommandResult, error) {
+	file, err := syntax.NewParser(syntax.KeepComments(false)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command: %w", err)
+	}
+	if len(file.Stmts) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	p := &commandParser{}
+	if err := p.stmts(file.Stmts); err != nil {
+		return nil, err
+	}
+
+	return &CommandResult{
+		Steps: p.steps,
+		Risk:  Risk{Level: RiskNone},
+	}, nil
+}
+
+// commandParser flattens the syntax tree into CommandSteps
+type commandParser struct {
+	steps []CommandStep
+}
+
+func (p *commandParser) setOp(op string) {
+	if len(p.steps) > 0 {
+		p.steps[len(p.steps)-1].Op = op
+	}
+}
+
+func (p *commandParser) stmts(list []*syntax.Stmt) error {
+	for i, st := range list {
+		if err := p.stmt(st); err != nil {
+			return err
+		}
+		if i < len(list)-1 {
+			p.setOp(";")
+		}
+	}
+	return nil
+}
+
+func (p *commandParser) stmt(st *syntax.Stmt) error {
+	switch {
+	case st.Negated:
+		return unsupported(st, "negation (!)")
+	case st.Coprocess:
+		return unsupported(st, "coprocess")
+	}
+
+	var step CommandStep
+	for _, r := range st.Redirs {
+		step.Expand = step.Expand || (r.Hdoc == nil && r.Op != syntax.WordHdoc && expands(r.Word))
+	}
+	switch cmd := st.Cmd.(type) {
+	case *syntax.CallExpr:
+		if len(cmd.Args) == 0 {
+			return unsupported(st, "assignment or redirection without a command")
+		}
+		for _, w := range cmd.Args[1:] {
+			step.Expand = step.Expand || expands(w)
+		}
+		for _, a := range cmd.Assigns {
+			step.Expand = step.Expand || (a.Value != nil && expands(a.Value))
+		}
+		tool, err := wordValue(cmd.Args[0], false)
+		if err != nil {
+			return err
+		}
+		args, err := wordValues(cmd.Args[1:], step.Expand)
+		if err != nil {
+			return err
+		}
+		env, err := assignments(cmd.Assigns, step.Expand)
+		if err != nil {
+			return err
+		}
+		step.Tool, step.Args, step.Env = tool, args, env
+	case *syntax.Subshell:
+		inner := &commandParser{}
+		if err := inner.stmts(cmd.Stmts); err != nil {
+			return err
+		}
+		step.Subshell = inner.steps
+	case *syntax.BinaryCmd:
+		op, ok := binaryOps[cmd.Op]
+		if !ok {
+			return unsupported(st, cmd.Op.String())
+		}
+		if st.Background || len(st.Redirs) > 0 {
+			// "a && b &" applies to the whole list, keep it together
+			inner := &commandParser{}
+			if err := inner.binary(cmd, op); err != nil {
+				return err
+			}
+			step.Subshell = inner.steps
+			break
+		}
+		return p.binary(cmd, op)
+	default:
+		return unsupported(st, fmt.Sprintf("%T", cmd))
+	}
+
+	for _, r := range st.Redirs {
+		if err := addRedirect(&step, r); err != nil {
+			return err
+		}
+	}
+	step.Background = st.Background
+
+	p.steps = append(p.steps, step)
+	return nil
+}
+
+func (p *commandParser) binary(cmd *syntax.BinaryCmd, op string) error {
+	if err := p.stmt(cmd.X); err != nil {
+		return err
+	}
+	p.setOp(op)
+	return p.stmt(cmd.Y)
+}
+
+func assignments(assigns []*syntax.Assign, expand bool) (map[string]string, error) {
+	if len(assigns) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string, len(assigns))
+	for _, a := range assigns {
+		if a.Append || a.Index != nil || a.Array != nil || a.Name == nil {
+			return nil, unsupported(a, "complex assignment")
+		}
+		value := ""
+		if a.Value != nil {
+			v, err := wordValue(a.Value, expand)
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		}
+		env[a.Name.Value] = value
+	}
+	return env, nil
+}
+
+func addRedirect(step *CommandStep, r *syntax.Redirect) error {
+	switch r.Op {
+	case syntax.Hdoc, syntax.DashHdoc:
+		v, err := heredocValue(r.Hdoc)
+		if err != nil {
+			return err
+		}
+		step.Stdin = v
+		return nil
+	case syntax.WordHdoc:
+		v, err := wordValue(r.Word, false)
+		if err != nil {
+			return err
+		}
+		step.Stdin = v + "\n"
+		return nil
+	}
+
+	op, ok := redirectOps[r.Op]
+	if !ok {
+		return unsupported(r, "redirection "+r.Op.String())
+	}
+
+	rd := Redirect{Op: op}
+	if r.N != nil {
+		fd, err := strconv.Atoi(r.N.Value)
+		if err != nil {
+			return unsupported(r, "named file descriptor "+r.N.Value)
+		}
+		rd.FD = fd
+	}
+
+	target, err := wordValue(r.Word, step.Expand && !rd.Duplicates())
+	if err != nil {
+		return err
+	}
+	rd.Target = target
+
+	step.Redirects = append(step.Redirects, rd)
+	return nil
+}
+
+// heredocValue returns the body of a heredoc. With an unquoted delimiter
+// the body may hold variables, which keep their source text.
+func heredocValue(w *syntax.Word) (string, error) {
+	if w == nil {
+		return "", nil
+	}
+	var sb strings.Builder
+	for _, part := range w.Parts {
+		if lit, ok := part.(*syntax.Lit); ok {
+			sb.WriteString(lit.Value)
+			continue
+		}
+		if err := checkExpansion(part); err != nil {
+			return "", err
+		}
+		sb.WriteString(printNode(part))
+	}
+	return sb.String(), nil
+}
+
+var binaryOps = map[syntax.BinCmdOperator]string{
+	syntax.AndStmt: "&&",
+	syntax.OrStmt:  "||",
+	syntax.Pipe:    "|",
+}
+
+var redirectOps = map[syntax.RedirOperator]string{
+	syntax.RdrOut: ">",
+	syntax.AppOut: ">>",
+	syntax.ClbOut: ">|",
+	syntax.RdrIn:  "<",
+	syntax.DplIn:  "<&",
+	syntax.DplOut: ">&",
+	syntax.RdrAll: "&>",
+	syntax.AppAll: "&>>",
+}
+
+func unsupported(node syntax.Node, what string) error {
+	return fmt.Errorf("%w at %s: %s", ErrUnsupported, node.Pos(), what)
+}
+
+func wordValues(words []*syntax.Word, expand bool) ([]string, error) {
+	values := make([]string, 0, len(words))
+	for _, w := range words {
+		v, err := wordValue(w, expand)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// expands reports whether the shell would expand anything in the word: an
+// unquoted glob or leading ~, or a parameter, command or arithmetic
+// expansion
+func expands(w *syntax.Word) bool {
+	for i, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			if i == 0 && tildeEnd(part.Value) > 0 {
+				return true
+			}
+			for j := 0; j < len(part.Value); j++ {
+				switch part.Value[j] {
+				case '\\':
+					j++
+				case '*', '?', '[':
+					return true
+				}
+			}
+		case *syntax.SglQuoted:
+		case *syntax.DblQuoted:
+			for _, inner := range part.Parts {
+				if _, ok := inner.(*syntax.Lit); !ok {
+					return true
+				}
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// wordValue removes the shell quoting from a word, returning the value the
+// program would receive as its argument. For a step that expands, the
+// expansions are kept and the characters that were quoted are escaped.
+func wordValue(w *syntax.Word, expand bool) (string, error) {
+	var sb strings.Builder
+	literal := func(s string) {
+		if expand {
+			if sb.Len() > 0 && strings.HasPrefix(s, "~") {
+				s = "~" + EscapeExpansions(s[1:])
+			} else {
+				s = EscapeExpansions(s)
+			}
+		}
+		sb.WriteString(s)
+	}
+	for i, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			if expand {
+				sb.WriteString(expandedLit(part.Value, i == 0))
+			} else {
+				sb.WriteString(unescapeUnquoted(part.Value))
+			}
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				v, err := unescapeANSIC(part.Value)
+				if err != nil {
+					return "", err
+				}
+				literal(v)
+			} else {
+				literal(part.Value)
+			}
+		case *syntax.DblQuoted:
+			for _, inner := range part.Parts {
+				if lit, ok := inner.(*syntax.Lit); ok {
+					literal(unescapeDoubleQuoted(lit.Value))
+					continue
+				}
+				if err := checkExpansion(inner); err != nil {
+					return "", err
+				}
+				sb.WriteString(printNode(inner))
+			}
+		default:
+			// Variables keep their source text
+			if err := checkExpansion(part); err != nil {
+				return "", err
+			}
+			sb.WriteString(printNode(part))
+		}
+	}
+	return sb.String(), nil
+}
+
+// checkExpansion refuses expansions other than $NAME and ${NAME}: command,
+// arithmetic and process substitutions run commands the steps wouldn't
+// show, so they can't be checked
+func checkExpansion(part syntax.WordPart) error {
+	switch part := part.(type) {
+	case *syntax.ParamExp:
+		if part.Excl || part.Length || part.Width || part.Index != nil || part.Slice != nil ||
+			part.Repl != nil || part.Names != 0 || part.Exp != nil {
+			return unsupported(part, "parameter expansion "+printNode(part))
+		}
+		return nil
+	case *syntax.CmdSubst:
+		return unsupported(part, "command substitution")
+	case *syntax.ArithmExp:
+		return unsupported(part, "arithmetic expansion")
+	case *syntax.ProcSubst:
+		return unsupported(part, "process substitution")
+	}
+	return unsupported(part, fmt.Sprintf("%T", part))
+}
+
+func printNode(node syntax.Node) string {
+	var buf bytes.Buffer
+	if err := syntax.NewPrinter().Print(&buf, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// expandedLit is an unquoted literal of a step that expands: its globs and
+// leading ~ are kept, the characters escaped with a backslash stay escaped
+// and the others that would expand are escaped
+func expandedLit(s string, atStart bool) string {
+	var sb strings.Builder
+	if end := tildeEnd(s); atStart && end > 0 {
+		sb.WriteString(s[:end])
+		s = s[end:]
+		atStart = false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] != '\n' {
+				sb.WriteByte('\\')
+				sb.WriteByte(s[i])
+			}
+		case c == '*' || c == '?' || c == '[':
+			sb.WriteByte(c)
+		case c == '~' && atStart && i == 0:
+			sb.WriteString(`\~`)
+		case strings.IndexByte("$`", c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// unescapeUnquoted applies backslash removal outside of quotes
+func unescapeUnquoted(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == '\n' {
+				continue // line continuation
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// unescapeDoubleQuoted applies backslash removal inside double quotes, where
+// only $ ` " \ and newline can be escaped.
+func unescapeDoubleQuoted(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+			i++
+			if s[i] == '\n' {
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// unescapeANSIC decodes bash's $'...' strings
+func unescapeANSIC(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'e', 'E':
+			sb.WriteByte(0x1b)
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(c)
+		case 'x':
+			end := i + 1
+			for end < len(s) && end < i+3 && strings.IndexByte("0123456789abcdefABCDEF", s[end]) >= 0 {
+				end++
+			}
+			n, err := strconv.ParseUint(s[i+1:end], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape in $'...': %w", err)
+			}
+			sb.WriteByte(byte(n))
+			i = end - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := i
+			for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			n, err := strconv.ParseUint(s[i:end], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape in $'...': %w", err)
+			}
+			sb.WriteByte(byte(n))
+			i = end - 1
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
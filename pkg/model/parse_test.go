@@ -0,0 +1,239 @@
+package model
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []CommandStep
+	}{
+		{
+			"simple",
+			"ls -la",
+			[]CommandStep{{Tool: "ls", Args: []string{"-la"}}},
+		},
+		{
+			"pipeline and list",
+			`grep -r "TODO list" src | sort && echo done; echo 'it''s' || true`,
+			[]CommandStep{
+				{Tool: "grep", Args: []string{"-r", "TODO list", "src"}, Op: "|"},
+				{Tool: "sort", Args: []string{}, Op: "&&"},
+				{Tool: "echo", Args: []string{"done"}, Op: ";"},
+				{Tool: "echo", Args: []string{"its"}, Op: "||"},
+				{Tool: "true", Args: []string{}},
+			},
+		},
+		{
+			"redirects",
+			"sort < in.txt > 'out file.txt' && cat out\\ file.txt",
+			[]CommandStep{
+				{Tool: "sort", Args: []string{}, Redirects: []Redirect{
+					{Op: "<", Target: "in.txt"},
+					{Op: ">", Target: "out file.txt"},
+				}, Op: "&&"},
+				{Tool: "cat", Args: []string{"out file.txt"}},
+			},
+		},
+		{
+			"descriptor redirects",
+			"make 2>&1 >build.log | tee -a all.log",
+			[]CommandStep{
+				{Tool: "make", Args: []string{}, Redirects: []Redirect{
+					{FD: 2, Op: ">&", Target: "1"},
+					{Op: ">", Target: "build.log"},
+				}, Op: "|"},
+				{Tool: "tee", Args: []string{"-a", "all.log"}},
+			},
+		},
+		{
+			"env assignments",
+			"GOOS=linux CGO_ENABLED=0 go build ./...",
+			[]CommandStep{{Tool: "go", Args: []string{"build", "./..."}, Env: map[string]string{"GOOS": "linux", "CGO_ENABLED": "0"}}},
+		},
+		{
+			"subshell and background",
+			"(cd web && npm run build) & go run .",
+			[]CommandStep{
+				{Subshell: []CommandStep{
+					{Tool: "cd", Args: []string{"web"}, Op: "&&"},
+					{Tool: "npm", Args: []string{"run", "build"}},
+				}, Background: true, Op: ";"},
+				{Tool: "go", Args: []string{"run", "."}},
+			},
+		},
+		{
+			"heredoc",
+			"cat <<'EOF' > notes.txt\nhello $USER\nEOF\n",
+			[]CommandStep{{Tool: "cat", Args: []string{}, Stdin: "hello $USER\n", Redirects: []Redirect{{Op: ">", Target: "notes.txt"}}}},
+		},
+		{
+			"here string",
+			"grep -c x <<< 'xx'",
+			[]CommandStep{{Tool: "grep", Args: []string{"-c", "x"}, Stdin: "xx\n"}},
+		},
+		{
+			"newline separated",
+			"cd /tmp\nls",
+			[]CommandStep{
+				{Tool: "cd", Args: []string{"/tmp"}, Op: ";"},
+				{Tool: "ls", Args: []string{}},
+			},
+		},
+		{
+			"variables keep source text",
+			`echo "$HOME/x" ${USER} \$literal`,
+			[]CommandStep{{Tool: "echo", Args: []string{"$HOME/x", "${USER}", `\$literal`}, Expand: true}},
+		},
+		{
+			"globs and tilde",
+			`rm -f *.tmp ~/logs/'old logs'/* > ~/rm.log`,
+			[]CommandStep{{Tool: "rm", Args: []string{"-f", "*.tmp", "~/logs/old logs/*"}, Expand: true, Redirects: []Redirect{{Op: ">", Target: "~/rm.log"}}}},
+		},
+		{
+			"quoted globs stay literal",
+			`find ~ -name '*.go' -newer "~"`,
+			[]CommandStep{{Tool: "find", Args: []string{"~", "-name", `\*.go`, "-newer", `\~`}, Expand: true}},
+		},
+		{
+			"no expansions",
+			`grep -E 'a.*b' '$(x' a~b`,
+			[]CommandStep{{Tool: "grep", Args: []string{"-E", "a.*b", "$(x", "a~b"}}},
+		},
+		{
+			"ansi c quoting",
+			`printf $'a\tb\n'`,
+			[]CommandStep{{Tool: "printf", Args: []string{"a\tb\n"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if !reflect.DeepEqual(result.Steps, tt.want) {
+				t.Errorf("Parse(%q)\n got %#v\nwant %#v", tt.input, result.Steps, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		input       string
+		unsupported bool
+	}{
+		{"", false},
+		{"echo 'unterminated", false},
+		{"ls |", false},
+		{"for f in *; do echo $f; done", true},
+		{"if [ -f x ]; then cat x; fi", true},
+		{"{ ls; pwd; } > out", true},
+		{"echo $(rm -rf ~)", true},
+		{"echo \"`rm -rf /`\"", true},
+		{"echo ${a:-$(rm x)}", true},
+		{"echo $((1 + 2))", true},
+		{"diff <(ls a) <(ls b)", true},
+		{"cat <<EOF\n$(rm x)\nEOF", true},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		if err == nil {
+			t.Errorf("Expected an error for %q", tt.input)
+			continue
+		}
+		if errors.Is(err, ErrUnsupported) != tt.unsupported {
+			t.Errorf("Parse(%q): expected unsupported=%v, got %v", tt.input, tt.unsupported, err)
+		}
+	}
+}
+
+// TestParse_RenderRoundTrip checks that parsing a rendered command gives back
+// the original arguments, however hostile they are.
+func TestParse_RenderRoundTrip(t *testing.T) {
+	original := &CommandResult{Steps: []CommandStep{
+		{Tool: "printf", Args: append([]string{`%s\0`}, nasty...), Op: "|"},
+		{Tool: "xargs", Args: []string{"-0", "echo"}, Env: map[string]string{"LC_ALL": "C"}, Redirects: []Redirect{
+			{Op: ">>", Target: "log file.txt"},
+			{FD: 2, Op: ">&", Target: "1"},
+		}, Op: ";"},
+		{Subshell: []CommandStep{
+			{Tool: "cd", Args: []string{"/tmp dir"}, Op: "&&"},
+			{Tool: "cat", Stdin: "it's $literal\n"},
+		}, Background: true},
+	}}
+
+	for _, shell := range []Shell{ShellPOSIX, ShellBash, ShellZsh} {
+		parsed, err := Parse(original.Render(shell))
+		if err != nil {
+			t.Fatalf("Parse failed for %s: %v", shell, err)
+		}
+		if len(parsed.Steps) != len(original.Steps) {
+			t.Fatalf("Expected %d steps, got %d", len(original.Steps), len(parsed.Steps))
+		}
+		for i := range original.Steps {
+			want, got := original.Steps[i], parsed.Steps[i]
+			if len(want.Args) == 0 {
+				got.Args = want.Args
+			}
+			if len(want.Subshell) > 0 && len(got.Subshell) > 1 {
+				got.Subshell[1].Args = want.Subshell[1].Args
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s step %d:\n got %#v\nwant %#v", shell, i, got, want)
+			}
+		}
+	}
+}
+
+// TestParse_RenderRoundTripExpansions checks that a parsed command with
+// globs, ~ and variables renders to one that expands to the same arguments
+func TestParse_RenderRoundTripExpansions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("round trip needs a POSIX environment")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "*.log"} {
+		if err := exec.Command("touch", dir+"/"+name).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := `printf '%s|' *.log '*.log' ~/*.log "$HOME/my dir" ${HOME}x '$(echo hi)' "it's" '~'`
+	for _, sh := range []struct {
+		bin   string
+		shell Shell
+	}{{"sh", ShellPOSIX}, {"bash", ShellBash}, {"zsh", ShellZsh}} {
+		bin, err := exec.LookPath(sh.bin)
+		if err != nil {
+			continue
+		}
+		parsed, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		rendered := parsed.Render(sh.shell)
+
+		run := func(line string) string {
+			cmd := exec.Command(bin, "-c", line)
+			cmd.Dir = dir
+			cmd.Env = []string{"HOME=" + dir, "PATH=/usr/bin:/bin"}
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%s -c %s failed: %v", sh.bin, line, err)
+			}
+			return string(out)
+		}
+		if want, got := run(input), run(rendered); got != want {
+			t.Errorf("%s: %s\n got %q\nwant %q", sh.bin, rendered, got, want)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Shell selects the quoting and operator rules used when rendering a command
+type Shell string
+
+const (
+	ShellPOSIX      Shell = "sh"
+	ShellBash       Shell = "bash"
+	ShellZsh        Shell = "zsh"
+	ShellFish       Shell = "fish"
+	ShellPowerShell Shell = "powershell"
+	ShellCmd        Shell = "cmd"
+)
+
+// ShellFromPath maps a $SHELL value or an executable name to a Shell.
+// Unknown shells fall back to POSIX sh rules.
+func ShellFromPath(path string) Shell {
+	name := strings.ToLower(filepath.Base(strings.ReplaceAll(path, `\`, "/")))
+	name = strings.TrimSuffix(name, ".exe")
+	switch name {
+	case "bash":
+		return ShellBash
+	case "zsh":
+		return ShellZsh
+	case "fish":
+		return ShellFish
+	case "pwsh", "powershell":
+		return ShellPowerShell
+	case "cmd":
+		return ShellCmd
+	default:
+		return ShellPOSIX
+	}
+}
+
+// IsPOSIX reports whether the shell follows sh quoting rules
+func (s Shell) IsPOSIX() bool {
+	return s == ShellPOSIX || s == ShellBash || s == ShellZsh || s == ""
+}
+
+// IsRedirect reports whether op sends output to (or reads input from) a file.
+// The step after a redirect names the file instead of a tool.
+func IsRedirect(op string) bool {
+	switch op {
+	case ">", ">>", "<":
+		return true
+	}
+	return false
+}
+
+// Render builds the command line for the given shell, quoting every tool and
+// argument so that it reaches the program exactly as it appears in Steps.
+// Steps that expand keep their globs, ~ and variables, see
+// CommandStep.Expand.
+func (r *CommandResult) Render(shell Shell) string {
+	return RenderSteps(r.Steps, shell)
+}
+
+// RenderSteps renders a slice of steps, see CommandResult.Render
+func RenderSteps(steps []CommandStep, shell Shell) string {
+	r := &renderer{shell: shell}
+	line := r.steps(steps)
+	if len(r.heredocs) > 0 {
+		// Heredoc bodies follow the line that introduced them
+		line += "\n" + strings.Join(r.heredocs, "\n")
+	}
+	return line
+}
+
+// renderer carries the state needed while rendering nested steps
+type renderer struct {
+	shell    Shell
+	heredocs []string
+}
+
+func (r *renderer) steps(steps []CommandStep) string {
+	var sb strings.Builder
+	for i, step := range steps {
+		last := i == len(steps)-1
+		if i > 0 && IsRedirect(steps[i-1].Op) {
+			// The "step" is the target of a legacy redirect operator
+			sb.WriteString(r.word(redirectTarget(step), steps[i-1].Expand))
+		} else {
+			sb.WriteString(r.step(step))
+		}
+
+		if step.Background && r.shell != ShellCmd {
+			// '&' already separates the job from whatever follows
+			sb.WriteString(" &")
+			if !last {
+				sb.WriteString(" ")
+			}
+			continue
+		}
+
+		op := step.Op
+		if op == "" && step.Background && !last {
+			op = ";"
+		}
+		if op != "" && !last {
+			sb.WriteString(" " + renderOp(r.shell, op) + " ")
+		}
+	}
+	return sb.String()
+}
+
+// redirectTarget returns the filename of a legacy redirect step. Models
+// sometimes put it in Args and leave Tool empty.
+func redirectTarget(step CommandStep) string {
+	if step.Tool == "" && len(step.Args) > 0 {
+		return step.Args[0]
+	}
+	return step.Tool
+}
+
+func (r *renderer) step(step CommandStep) string {
+	var body string
+	if len(step.Subshell) > 0 {
+		inner := r.steps(step.Subshell)
+		switch r.shell {
+		case ShellFish:
+			// fish has no subshells, a block is the closest grouping
+			body = "begin; " + inner + "; end"
+		case ShellPowerShell:
+			body = "& { " + inner + " }"
+		default:
+			body = "(" + inner + ")"
+		}
+	} else {
+		body = r.invocation(step)
+	}
+
+	for _, rd := range step.Redirects {
+		body += " " + r.redirect(rd, step.Expand)
+	}
+
+	if step.Stdin != "" {
+		body = r.stdin(step.Stdin, body)
+	}
+
+	if step.Background && r.shell == ShellCmd {
+		body = `start "" /b ` + body
+	}
+
+	return r.env(step.Env, step.Expand) + body
+}
+
+func (r *renderer) env(env map[string]string, expand bool) string {
+	if len(env) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		switch r.shell {
+		case ShellPowerShell:
+			sb.WriteString("$env:" + k + " = " + r.word(env[k], expand) + "; ")
+		case ShellCmd:
+			sb.WriteString(`set "` + k + "=" + env[k] + `" && `)
+		default:
+			sb.WriteString(k + "=" + r.word(env[k], expand) + " ")
+		}
+	}
+	return sb.String()
+}
+
+func (r *renderer) redirect(rd Redirect, expand bool) string {
+	op := rd.Op
+	if op == "&>" || op == "&>>" {
+		switch r.shell {
+		case ShellBash, ShellZsh:
+		case ShellPowerShell:
+			op = "*" + strings.TrimPrefix(op, "&")
+		default:
+			// Plain sh, fish and cmd spell "both streams" the long way
+			return strings.TrimPrefix(op, "&") + " " + r.word(rd.Target, expand) + " 2>&1"
+		}
+	}
+
+	fd := ""
+	if rd.FD != 0 {
+		fd = strconv.Itoa(rd.FD)
+	}
+
+	if rd.Duplicates() {
+		target := rd.Target
+		if _, err := strconv.Atoi(target); err != nil && target != "-" {
+			target = Quote(r.shell, target)
+		}
+		return fd + op + target
+	}
+	if fd != "" {
+		return fd + op + " " + r.word(rd.Target, expand)
+	}
+	return op + " " + r.word(rd.Target, expand)
+}
+
+// stdin feeds literal text into body using the shell's closest construct
+func (r *renderer) stdin(text, body string) string {
+	switch r.shell {
+	case ShellFish:
+		return "printf '%s' " + Quote(r.shell, text) + " | " + body
+	case ShellPowerShell:
+		return Quote(r.shell, text) + " | " + body
+	case ShellCmd:
+		var echoes []string
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			echoes = append(echoes, "echo("+escapeCmdEcho(line))
+		}
+		return "(" + strings.Join(echoes, "& ") + ") | " + body
+	default:
+		delim := "CMDFY_EOF"
+		for n := 1; strings.Contains(text, delim); n++ {
+			delim = fmt.Sprintf("CMDFY_EOF_%d", n)
+		}
+		r.heredocs = append(r.heredocs, strings.TrimSuffix(text, "\n")+"\n"+delim)
+		return body + " <<'" + delim + "'"
+	}
+}
+
+// escapeCmdEcho caret-escapes the characters cmd would otherwise interpret
+func escapeCmdEcho(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune("^&|<>()%", c) {
+			sb.WriteRune('^')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func (r *renderer) invocation(step CommandStep) string {
+	parts := make([]string, 0, len(step.Args)+1)
+
+	tool := Quote(r.shell, step.Tool)
+	if r.shell == ShellPowerShell && tool != step.Tool {
+		// A quoted string is an expression in PowerShell, the call operator runs it
+		tool = "& " + tool
+	}
+	parts = append(parts, tool)
+
+	for _, arg := range step.Args {
+		parts = append(parts, r.word(arg, step.Expand))
+	}
+	return strings.Join(parts, " ")
+}
+
+// word quotes an argument, redirect target or env value. In a step that
+// expands, its globs, leading ~ and $VARIABLES are left to the shell.
+func (r *renderer) word(s string, expand bool) string {
+	switch {
+	case !expand || s == "":
+		return Quote(r.shell, s)
+	case r.shell.IsPOSIX():
+		return quoteExpanding(s)
+	default:
+		// Other shells expand differently, so nothing expands there
+		return Quote(r.shell, Unescape(s))
+	}
+}
+
+func renderOp(shell Shell, op string) string {
+	if shell == ShellCmd && op == ";" {
+		return "&"
+	}
+	return op
+}
+
+// CommandLine turns the arguments of a command such as "cmdfy run -- ..."
+// back into a line for the shell. A single argument is taken as a command
+// line already, e.g. 'make && make install'. Several are the words of one
+// command, as the calling shell split them, and are quoted so they reach the
+// program unchanged.
+func CommandLine(shell Shell, args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	words := make([]string, len(args))
+	for i, a := range args {
+		words[i] = Quote(shell, a)
+	}
+	return strings.Join(words, " ")
+}
+
+// Quote returns s quoted for the shell so that it is passed as a single
+// literal word. Words made only of safe characters are left bare.
+func Quote(shell Shell, s string) string {
+	switch shell {
+	case ShellFish:
+		return quoteFish(s)
+	case ShellPowerShell:
+		return quotePowerShell(s)
+	case ShellCmd:
+		return quoteCmd(s)
+	default:
+		return quotePOSIX(s)
+	}
+}
+
+func isSafe(s string, extra string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune(extra, c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func quotePOSIX(s string) string {
+	// A leading '=' triggers command path expansion in zsh
+	if isSafe(s, "_-+=:,./@%") && s[0] != '=' {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteExpanding quotes s for a POSIX shell, except for its expansions:
+// unescaped globs stay bare, a leading ~ or ~user stays in front and
+// $NAME, ${...}, $(...) and backquotes are double quoted, so that their
+// value isn't split into several words. A backslash keeps the character
+// after it literal.
+func quoteExpanding(s string) string {
+	var sb, lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			sb.WriteString(quotePOSIX(lit.String()))
+			lit.Reset()
+		}
+	}
+
+	if end := tildeEnd(s); end > 0 {
+		sb.WriteString(s[:end])
+		s = s[end:]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			lit.WriteByte(s[i])
+		case c == '*' || c == '?':
+			flush()
+			sb.WriteByte(c)
+		case c == '[' && bracketEnd(s, i) > 0:
+			end := bracketEnd(s, i)
+			flush()
+			sb.WriteString(s[i:end])
+			i = end - 1
+		case c == '$' && expansionEnd(s, i) > 0:
+			end := expansionEnd(s, i)
+			flush()
+			sb.WriteString(`"` + s[i:end] + `"`)
+			i = end - 1
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return sb.String()
+}
+
+// tildeEnd returns the length of the ~ or ~user prefix s starts with,
+// including the slash after it, or 0 when there is none
+func tildeEnd(s string) int {
+	if !strings.HasPrefix(s, "~") {
+		return 0
+	}
+	end := 1
+	for end < len(s) && isSafe(s[end:end+1], "_-.") {
+		end++
+	}
+	if end < len(s) {
+		if s[end] != '/' {
+			return 0
+		}
+		end++
+	}
+	return end
+}
+
+// bracketEnd returns the end of the [...] glob starting at s[i], or 0 when
+// the bracket is a literal one
+func bracketEnd(s string, i int) int {
+	close := strings.IndexByte(s[i+1:], ']')
+	if close <= 0 || !isSafe(s[i+1:i+1+close], "!^-_.") {
+		return 0
+	}
+	return i + close + 2
+}
+
+// expansionEnd returns the end of the $NAME or ${NAME} expansion starting
+// at s[i], or 0 when there is none. Command substitutions and the other
+// forms of ${...} are left literal, since they can run commands.
+func expansionEnd(s string, i int) int {
+	if s[i] != '$' || i+1 >= len(s) {
+		return 0
+	}
+	switch c := s[i+1]; {
+	case c == '{':
+		close := strings.IndexByte(s[i+2:], '}')
+		if close < 0 || !isParamName(s[i+2:i+2+close]) {
+			return 0
+		}
+		return i + close + 3
+	case c >= '0' && c <= '9' || strings.IndexByte("@#?", c) >= 0:
+		return i + 2
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		end := i + 2
+		for end < len(s) && isSafe(s[end:end+1], "_") {
+			end++
+		}
+		return end
+	}
+	return 0
+}
+
+// isParamName reports whether s names a variable, a positional parameter
+// or one of $@, $# and $?
+func isParamName(s string) bool {
+	switch {
+	case s == "":
+		return false
+	case len(s) == 1 && strings.IndexByte("@#?", s[0]) >= 0:
+		return true
+	case s[0] >= '0' && s[0] <= '9':
+		return strings.Trim(s, "0123456789") == ""
+	}
+	return isSafe(s, "_")
+}
+
+// EscapeExpansions returns s with a backslash before every character a
+// step that expands would treat specially, so that it stays literal there
+func EscapeExpansions(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte("\\*?[$`", s[i]) >= 0 || (i == 0 && s[i] == '~') {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// Unescape removes the backslashes that keep characters literal in a step
+// that expands
+func Unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func quoteFish(s string) string {
+	if isSafe(s, "_-+=:,./@%") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(s) + "'"
+}
+
+func quotePowerShell(s string) string {
+	// ',' builds arrays and a leading '@' splats, so neither is safe
+	if isSafe(s, `_-+=:./\`) {
+		return s
+	}
+	// PowerShell also treats typographic single quotes as quote characters
+	r := strings.NewReplacer("'", "''", "‘", "‘‘", "’", "’’", "‚", "‚‚", "‛", "‛‛")
+	return "'" + r.Replace(s) + "'"
+}
+
+func quoteCmd(s string) string {
+	if isSafe(s, `_-+:./\`) {
+		return s
+	}
+	// cmd has no way to quote '%' inside double quotes. Close the quotes and
+	// escape it with a caret instead: "50"^%" off"
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			sb.WriteString(`""`)
+		case '%':
+			sb.WriteString(`"^%"`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,337 @@
+package model
+
+import (
+	"bytes"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// nasty holds arguments that broke the old "wrap in double quotes if it has a space" logic
+var nasty = []string{
+	"plain",
+	"with space",
+	"$HOME",
+	"`whoami`",
+	"*.go",
+	`say "hi"`,
+	"it's",
+	"line1\nline2",
+	"back\\slash",
+	"semi;colon",
+	"a&&b",
+	"=equals",
+	"~",
+	"!bang",
+	"",
+	"tab\there",
+	"100%",
+}
+
+func TestQuote_Expected(t *testing.T) {
+	tests := []struct {
+		shell Shell
+		in    string
+		want  string
+	}{
+		{ShellBash, "simple", "simple"},
+		{ShellBash, "with space", "'with space'"},
+		{ShellBash, "it's", `'it'\''s'`},
+		{ShellBash, "$HOME", "'$HOME'"},
+		{ShellBash, "", "''"},
+		{ShellZsh, "=ls", "'=ls'"},
+		{ShellFish, "it's", `'it\'s'`},
+		{ShellFish, `a\b`, `'a\\b'`},
+		{ShellPowerShell, "it's", "'it''s'"},
+		{ShellPowerShell, "a,b", "'a,b'"},
+		{ShellPowerShell, `C:\Windows`, `C:\Windows`},
+		{ShellCmd, "with space", `"with space"`},
+		{ShellCmd, `say "hi"`, `"say ""hi"""`},
+		{ShellCmd, "50% off", `"50"^%" off"`},
+	}
+
+	for _, tt := range tests {
+		if got := Quote(tt.shell, tt.in); got != tt.want {
+			t.Errorf("Quote(%s, %q) = %s, want %s", tt.shell, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		shell Shell
+		args  []string
+		want  string
+	}{
+		{ShellBash, []string{"tar -xf backup.tar | head"}, "tar -xf backup.tar | head"},
+		{ShellBash, []string{"make", "build"}, "make build"},
+		{ShellBash, []string{"git", "commit", "-m", "fix the build"}, "git commit -m 'fix the build'"},
+		{ShellBash, []string{"grep", "-r", "a|b", "*.go"}, "grep -r 'a|b' '*.go'"},
+		{ShellBash, []string{"echo", "it's", ""}, `echo 'it'\''s' ''`},
+		{ShellFish, []string{"echo", "$HOME"}, `echo '$HOME'`},
+	}
+
+	for _, tt := range tests {
+		if got := CommandLine(tt.shell, tt.args); got != tt.want {
+			t.Errorf("CommandLine(%s, %q) = %s, want %s", tt.shell, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRender_Ops(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{
+		{Tool: "grep", Args: []string{"-r", "TODO", "src dir"}, Op: "|"},
+		{Tool: "sort", Op: ">"},
+		{Tool: "todo list.txt", Op: ";"},
+		{Tool: "echo", Args: []string{"done"}},
+	}}
+
+	tests := []struct {
+		shell Shell
+		want  string
+	}{
+		{ShellBash, `grep -r TODO 'src dir' | sort > 'todo list.txt' ; echo done`},
+		{ShellCmd, `grep -r TODO "src dir" | sort > "todo list.txt" & echo done`},
+	}
+
+	for _, tt := range tests {
+		if got := result.Render(tt.shell); got != tt.want {
+			t.Errorf("Render(%s) =\n%s\nwant\n%s", tt.shell, got, tt.want)
+		}
+	}
+}
+
+func TestRender_Expand(t *testing.T) {
+	tests := []struct {
+		step CommandStep
+		want string
+	}{
+		{CommandStep{Tool: "rm", Args: []string{"*.tmp"}, Expand: true}, "rm *.tmp"},
+		{CommandStep{Tool: "rm", Args: []string{"*.tmp"}}, "rm '*.tmp'"},
+		{CommandStep{Tool: "ls", Args: []string{"~/logs", "~"}, Expand: true}, "ls ~/logs ~"},
+		{CommandStep{Tool: "echo", Args: []string{"$HOME", "${USER}s", "$1"}, Expand: true}, `echo "$HOME" "${USER}"s "$1"`},
+		// Nothing that can run a command expands
+		{CommandStep{Tool: "echo", Args: []string{"$(rm -rf ~)", "`rm -rf /`", "$((1+1))", "${HOME:-$(id)}", "${!x}"}, Expand: true}, "echo '$(rm -rf ~)' '`rm -rf /`' '$((1+1))' '${HOME:-$(id)}' '${!x}'"},
+		{CommandStep{Tool: "ls", Args: []string{"$HOME/my dir/*.txt", "file[0-9]"}, Expand: true}, `ls "$HOME"'/my dir/'*.txt file[0-9]`},
+		{CommandStep{Tool: "find", Args: []string{"~", "-name", `\*.go`, `\~`, "a[b c]", "$", "50$"}, Expand: true}, `find ~ -name '*.go' '~' 'a[b c]' '$' '50$'`},
+		{CommandStep{Tool: "cat", Args: []string{"~/in.txt"}, Expand: true, Redirects: []Redirect{{Op: ">", Target: "~/out.txt"}}}, "cat ~/in.txt > ~/out.txt"},
+	}
+
+	for _, tt := range tests {
+		result := &CommandResult{Steps: []CommandStep{tt.step}}
+		if got := result.Render(ShellBash); got != tt.want {
+			t.Errorf("Render(%q) = %s, want %s", tt.step.Args, got, tt.want)
+		}
+	}
+}
+
+func TestRender_ExpandOtherShells(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{{Tool: "ls", Args: []string{"x; rm -rf ~", `\*.go`}, Expand: true}}}
+
+	tests := []struct {
+		shell Shell
+		want  string
+	}{
+		{ShellFish, `ls 'x; rm -rf ~' '*.go'`},
+		{ShellPowerShell, `ls 'x; rm -rf ~' '*.go'`},
+		{ShellCmd, `ls "x; rm -rf ~" "*.go"`},
+	}
+	for _, tt := range tests {
+		if got := result.Render(tt.shell); got != tt.want {
+			t.Errorf("Render(%s) = %s, want %s", tt.shell, got, tt.want)
+		}
+	}
+}
+
+// TestRender_RoundTripExpand runs a step that expands through real shells
+// and checks what its globs, ~ and variables expand to
+func TestRender_RoundTripExpand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("round trip needs a POSIX environment")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.tmp", "b.tmp"} {
+		if err := exec.Command("touch", dir+"/"+name).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := &CommandResult{Steps: []CommandStep{{
+		Tool:   "printf",
+		Args:   []string{`%s\\0`, "*.tmp", "~/a.tmp", "$HOME", `\*.tmp`, "it's $HOME"},
+		Expand: true,
+	}}}
+	want := []string{"a.tmp", "b.tmp", dir + "/a.tmp", dir, "*.tmp", "it's " + dir}
+
+	for _, sh := range []struct {
+		bin   string
+		shell Shell
+	}{{"sh", ShellPOSIX}, {"bash", ShellBash}, {"zsh", ShellZsh}} {
+		bin, err := exec.LookPath(sh.bin)
+		if err != nil {
+			continue
+		}
+		t.Run(sh.bin, func(t *testing.T) {
+			cmd := exec.Command(bin, "-c", result.Render(sh.shell))
+			cmd.Dir = dir
+			cmd.Env = []string{"HOME=" + dir, "PATH=/usr/bin:/bin"}
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("Command failed: %v", err)
+			}
+			got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+			if strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestRender_PowerShellQuotedTool(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{{Tool: `C:\Program Files\Git\bin\git.exe`, Args: []string{"status"}}}}
+	want := `& 'C:\Program Files\Git\bin\git.exe' status`
+	if got := result.Render(ShellPowerShell); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestShellFromPath(t *testing.T) {
+	tests := map[string]Shell{
+		"/bin/bash":       ShellBash,
+		"/usr/bin/zsh":    ShellZsh,
+		"/opt/fish/fish":  ShellFish,
+		"pwsh":            ShellPowerShell,
+		`C:\cmd.exe`:      ShellCmd,
+		"/bin/dash":       ShellPOSIX,
+		"":                ShellPOSIX,
+		"powershell.exe":  ShellPowerShell,
+		"/usr/local/bin/": ShellPOSIX,
+	}
+	for in, want := range tests {
+		if got := ShellFromPath(in); got != want {
+			t.Errorf("ShellFromPath(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+// TestRender_RoundTrip runs the rendered command through real shells and
+// checks that every argument arrives unchanged.
+func TestRender_RoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("round trip needs a POSIX environment")
+	}
+
+	shells := []struct {
+		bin   string
+		shell Shell
+	}{
+		{"sh", ShellPOSIX},
+		{"bash", ShellBash},
+		{"zsh", ShellZsh},
+		{"fish", ShellFish},
+	}
+
+	result := &CommandResult{Steps: []CommandStep{{Tool: "printf", Args: append([]string{`%s\0`}, nasty...)}}}
+
+	for _, sh := range shells {
+		bin, err := exec.LookPath(sh.bin)
+		if err != nil {
+			continue
+		}
+		t.Run(sh.bin, func(t *testing.T) {
+			rendered := result.Render(sh.shell)
+			out, err := exec.Command(bin, "-c", rendered).Output()
+			if err != nil {
+				t.Fatalf("%s -c %s failed: %v", sh.bin, rendered, err)
+			}
+
+			got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+			if len(got) != len(nasty) {
+				t.Fatalf("Expected %d arguments, got %d: %q", len(nasty), len(got), got)
+			}
+			for i := range nasty {
+				if got[i] != nasty[i] {
+					t.Errorf("Argument %d: expected %q, got %q", i, nasty[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRender_RoundTripRedirect(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil || runtime.GOOS == "windows" {
+		t.Skip("sh not available")
+	}
+
+	target := t.TempDir() + "/out $file.txt"
+	result := &CommandResult{Steps: []CommandStep{
+		{Tool: "echo", Args: []string{"it's here"}, Op: ">"},
+		{Tool: target, Op: "&&"},
+		{Tool: "cat", Args: []string{target}},
+	}}
+
+	out, err := exec.Command(sh, "-c", result.Render(ShellPOSIX)).Output()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if got := string(bytes.TrimSpace(out)); got != "it's here" {
+		t.Errorf("Expected %q, got %q", "it's here", got)
+	}
+}
+
+func TestRender_RichSteps(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{
+		{Tool: "make", Env: map[string]string{"CC": "clang", "CFLAGS": "-O2 -g"}, Redirects: []Redirect{
+			{Op: ">", Target: "build.log"},
+			{FD: 2, Op: ">&", Target: "1"},
+		}, Op: "&&"},
+		{Subshell: []CommandStep{
+			{Tool: "cd", Args: []string{"docs"}, Op: "&&"},
+			{Tool: "mkdocs", Args: []string{"serve"}},
+		}, Background: true},
+		{Tool: "cat", Stdin: "line one\n", Redirects: []Redirect{{Op: "&>", Target: "out.txt"}}},
+	}}
+
+	tests := []struct {
+		shell Shell
+		want  string
+	}{
+		{ShellBash, "CC=clang CFLAGS='-O2 -g' make > build.log 2>&1 && (cd docs && mkdocs serve) & cat &> out.txt <<'CMDFY_EOF'\nline one\nCMDFY_EOF"},
+		{ShellPOSIX, "CC=clang CFLAGS='-O2 -g' make > build.log 2>&1 && (cd docs && mkdocs serve) & cat > out.txt 2>&1 <<'CMDFY_EOF'\nline one\nCMDFY_EOF"},
+		{ShellFish, "CC=clang CFLAGS='-O2 -g' make > build.log 2>&1 && begin; cd docs && mkdocs serve; end & printf '%s' 'line one\n' | cat > out.txt 2>&1"},
+		{ShellPowerShell, "$env:CC = clang; $env:CFLAGS = '-O2 -g'; make > build.log 2>&1 && & { cd docs && mkdocs serve } & 'line one\n' | cat *> out.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := result.Render(tt.shell); got != tt.want {
+			t.Errorf("Render(%s) =\n%s\nwant\n%s", tt.shell, got, tt.want)
+		}
+	}
+}
+
+func TestRender_RoundTripRichSteps(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil || runtime.GOOS == "windows" {
+		t.Skip("sh not available")
+	}
+
+	dir := t.TempDir()
+	result := &CommandResult{Steps: []CommandStep{
+		{Subshell: []CommandStep{
+			{Tool: "cd", Args: []string{dir}, Op: "&&"},
+			{Tool: "sh", Args: []string{"-c", `echo "$GREETING" >&2`}, Env: map[string]string{"GREETING": "it's $me"}},
+		}, Redirects: []Redirect{{FD: 2, Op: ">&", Target: "1"}}, Op: "&&"},
+		{Tool: "tr", Args: []string{"a-z", "A-Z"}, Stdin: "from a heredoc\n"},
+	}}
+
+	out, err := exec.Command(sh, "-c", result.Render(ShellPOSIX)).Output()
+	if err != nil {
+		t.Fatalf("Command failed: %v\n%s", err, result.Render(ShellPOSIX))
+	}
+	want := "it's $me\nFROM A HEREDOC\n"
+	if string(out) != want {
+		t.Errorf("Expected %q, got %q", want, string(out))
+	}
+}
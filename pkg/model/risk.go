@@ -0,0 +1,134 @@
+package model
+
+import "strings"
+
+// RiskLevel is the severity of a command's side effects
+type RiskLevel string
+
+const (
+	RiskNone     RiskLevel = "none"
+	RiskLow      RiskLevel = "low"
+	RiskMedium   RiskLevel = "medium"
+	RiskHigh     RiskLevel = "high"
+	RiskCritical RiskLevel = "critical"
+)
+
+// Enum lists the levels, weakest first
+func (RiskLevel) Enum() []string {
+	return []string{string(RiskNone), string(RiskLow), string(RiskMedium), string(RiskHigh), string(RiskCritical)}
+}
+
+var riskSeverity = map[RiskLevel]int{
+	RiskNone:     0,
+	RiskLow:      1,
+	RiskMedium:   2,
+	RiskHigh:     3,
+	RiskCritical: 4,
+}
+
+// Severity returns the rank of the level. Unknown levels rank as none.
+func (l RiskLevel) Severity() int {
+	return riskSeverity[RiskLevel(strings.ToLower(string(l)))]
+}
+
+// AtLeast reports whether l is as severe as other or more
+func (l RiskLevel) AtLeast(other RiskLevel) bool {
+	return l.Severity() >= other.Severity()
+}
+
+// MaxRiskLevel returns the more severe of the two levels
+func MaxRiskLevel(a, b RiskLevel) RiskLevel {
+	if b.Severity() > a.Severity() {
+		return b
+	}
+	return a
+}
+
+// RiskCategory names the kind of side effect a command has
+type RiskCategory string
+
+const (
+	CategoryDeletesData         RiskCategory = "deletes_data"
+	CategoryNetworkEgress       RiskCategory = "network_egress"
+	CategoryPrivilegeEscalation RiskCategory = "privilege_escalation"
+	CategoryWritesOutsideCwd    RiskCategory = "writes_outside_cwd"
+	CategoryIrreversible        RiskCategory = "irreversible"
+)
+
+// Enum lists the categories
+func (RiskCategory) Enum() []string {
+	return []string{
+		string(CategoryDeletesData),
+		string(CategoryNetworkEgress),
+		string(CategoryPrivilegeEscalation),
+		string(CategoryWritesOutsideCwd),
+		string(CategoryIrreversible),
+	}
+}
+
+// StepRisk explains why a single step of the pipeline is risky
+type StepRisk struct {
+	Step   int       `json:"step"` // Index into CommandResult.Steps
+	Level  RiskLevel `json:"level,omitempty"`
+	Reason string    `json:"reason"`
+}
+
+// Risk is the structured risk assessment of a command pipeline
+type Risk struct {
+	Level      RiskLevel      `json:"level"`
+	Categories []RiskCategory `json:"categories,omitempty"`
+	Steps      []StepRisk     `json:"steps,omitempty"`
+}
+
+// IsDangerous reports whether the command should be confirmed before running
+func (r Risk) IsDangerous() bool {
+	return r.Level.AtLeast(RiskMedium)
+}
+
+// HasCategory reports whether the assessment lists the category
+func (r Risk) HasCategory(c RiskCategory) bool {
+	for _, existing := range r.Categories {
+		if existing == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge combines two assessments, keeping the higher level and the union of
+// categories and step reasons.
+func (r Risk) Merge(other Risk) Risk {
+	merged := Risk{
+		Level: MaxRiskLevel(r.Level, other.Level),
+	}
+	if merged.Level == "" {
+		merged.Level = RiskNone
+	}
+
+	for _, c := range append(append([]RiskCategory{}, r.Categories...), other.Categories...) {
+		if !merged.HasCategory(c) {
+			merged.Categories = append(merged.Categories, c)
+		}
+	}
+
+	seen := make(map[StepRisk]bool)
+	for _, s := range append(append([]StepRisk{}, r.Steps...), other.Steps...) {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		merged.Steps = append(merged.Steps, s)
+	}
+	return merged
+}
+
+// StepReasons returns the reasons recorded for the step at index i
+func (r Risk) StepReasons(i int) []string {
+	var reasons []string
+	for _, s := range r.Steps {
+		if s.Step == i {
+			reasons = append(reasons, s.Reason)
+		}
+	}
+	return reasons
+}
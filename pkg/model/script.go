@@ -0,0 +1,248 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Script renders the command as a standalone, commented script for a POSIX
+// shell. Parameters become variables at the top that can be overridden from
+// the environment, and running the script with --dry-run prints the commands
+// instead of executing them. request is quoted in the header.
+//
+// sh has no pipefail, so a failing stage in the middle of a pipeline would go
+// unnoticed; a script for sh with a pipeline is written for bash instead.
+func (r *CommandResult) Script(shell Shell, request string) (string, error) {
+	if !shell.IsPOSIX() {
+		return "", fmt.Errorf("scripts can only be written for POSIX shells, not %s", shell)
+	}
+	if (shell == ShellPOSIX || shell == "") && r.HasPipeline() {
+		shell = ShellBash
+	}
+
+	// Placeholders are filled with markers that are swapped for variable
+	// references once the steps are rendered and quoted
+	params := r.Params()
+	for _, p := range params {
+		// The name is written into the script as a variable
+		if !paramNamePattern.MatchString(p.Name) {
+			return "", fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+	}
+	markers := make(map[string]string, len(params))
+	values := make(map[string]string, len(params))
+	for i, p := range params {
+		marker := fmt.Sprintf("CMDFYPARAM%dX", i)
+		markers[marker] = p.Name
+		values[p.Name] = marker
+	}
+	filled := *r
+	filled.Steps = copySteps(r.Steps)
+	filled.walk(func(s string, _ bool) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			return values[placeholderPattern.FindStringSubmatch(m)[1]]
+		})
+	})
+
+	var sb strings.Builder
+	switch shell {
+	case ShellPOSIX, "":
+		sb.WriteString("#!/bin/sh\n")
+	default:
+		sb.WriteString("#!/usr/bin/env " + string(shell) + "\n")
+	}
+	if request != "" {
+		writeComment(&sb, "", "Generated by cmdfy for: "+request)
+	}
+	if r.Explanation != "" {
+		writeComment(&sb, "", withVariables(filled.Explanation, markers))
+	}
+	sb.WriteString("#\n# Usage: $0 [--dry-run]\n")
+	if len(params) > 0 {
+		sb.WriteString("# Parameters can be set in the environment, e.g. " + params[0].Name + "=value $0\n")
+	}
+	if shell == ShellPOSIX || shell == "" {
+		// pipefail is not portable to every sh
+		sb.WriteString("set -eu\n")
+	} else {
+		sb.WriteString("set -euo pipefail\n")
+	}
+
+	// With -n the shell only reads the rest of the script, and -v echoes it.
+	// This comes first so that a dry run doesn't stop at a missing parameter.
+	sb.WriteString(`
+if [ "${1:-}" = "--dry-run" ]; then
+  echo "Dry run, these commands would be executed:" >&2
+  set -nv
+fi
+`)
+
+	if len(params) > 0 {
+		sb.WriteString("\n")
+		for _, p := range params {
+			note := p.Description
+			if p.Type != "" && p.Type != ParamString {
+				note = strings.TrimSpace(note + " (" + string(p.Type) + ")")
+			}
+			if note != "" {
+				writeComment(&sb, "", note)
+			}
+			if p.Default != "" {
+				sb.WriteString(fmt.Sprintf("%s=\"${%s:-%s}\"\n", p.Name, p.Name, escapeDoubleQuoted(p.Default)))
+			} else {
+				sb.WriteString(fmt.Sprintf("%s=\"${%s:?set %s to run this script}\"\n", p.Name, p.Name, p.Name))
+			}
+		}
+	}
+
+	for _, stage := range filled.Stages() {
+		units := scriptUnits(stage.Steps)
+		for i, unit := range units {
+			indent := ""
+			if i > 0 {
+				indent = "  "
+			}
+			sb.WriteString("\n")
+			for _, step := range unit {
+				if step.Explanation != "" {
+					writeComment(&sb, indent, withVariables(step.Explanation, markers))
+				}
+			}
+
+			op := "|"
+			if i == len(units)-1 {
+				op = stage.Op
+			}
+			line := RenderSteps(unit, shell)
+			if op != "" && op != ";" {
+				// The operator has to end the first line, before any heredoc body
+				first, rest, _ := strings.Cut(line, "\n")
+				line = first + " " + op
+				if rest != "" {
+					line += "\n" + rest
+				}
+			}
+			sb.WriteString(indent + substituteVariables(line, markers) + "\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// HasPipeline reports whether any step, including those in subshells, pipes
+// its output into the next
+func (r *CommandResult) HasPipeline() bool {
+	return hasPipe(r.Steps)
+}
+
+func hasPipe(steps []CommandStep) bool {
+	for _, step := range steps {
+		if step.Op == "|" || hasPipe(step.Subshell) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptUnits splits a stage into the steps written on a line of their own:
+// each command of a pipeline, together with its legacy redirect target
+func scriptUnits(steps []CommandStep) [][]CommandStep {
+	var units [][]CommandStep
+	for i := 0; i < len(steps); i++ {
+		unit := []CommandStep{steps[i]}
+		for IsRedirect(unit[len(unit)-1].Op) && i+1 < len(steps) {
+			i++
+			unit = append(unit, steps[i])
+		}
+		unit[len(unit)-1].Op = ""
+		units = append(units, unit)
+	}
+	return units
+}
+
+func writeComment(sb *strings.Builder, indent, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		sb.WriteString(strings.TrimRight(indent+"# "+line, " ") + "\n")
+	}
+}
+
+// withVariables replaces the markers in prose with the variable names
+func withVariables(text string, markers map[string]string) string {
+	for marker, name := range markers {
+		text = strings.ReplaceAll(text, marker, "${"+name+"}")
+	}
+	return text
+}
+
+// substituteVariables replaces the markers in a rendered command line with
+// double-quoted variable references, closing and reopening single quotes
+// around them where needed
+func substituteVariables(line string, markers map[string]string) string {
+	if len(markers) == 0 {
+		return line
+	}
+
+	var out []byte
+	inQuotes := false
+	opened := -1 // Position in out of the quote that opened the current string
+	for i := 0; i < len(line); i++ {
+		if name, n := markerAt(line[i:], markers); n > 0 {
+			i += n - 1
+			ref := `"${` + name + `}"`
+			if !inQuotes {
+				out = append(out, ref...)
+				continue
+			}
+			// Leave the quoted string, dropping it if it would be empty
+			if opened == len(out)-1 {
+				out = out[:opened]
+			} else {
+				out = append(out, '\'')
+			}
+			out = append(out, ref...)
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				inQuotes = false
+			} else {
+				opened = len(out)
+				out = append(out, '\'')
+			}
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case c == '\'':
+			inQuotes = !inQuotes
+			if inQuotes {
+				opened = len(out)
+			}
+		case c == '\\' && !inQuotes && i+1 < len(line):
+			out = append(out, c)
+			i++
+			c = line[i]
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// markerAt returns the parameter whose marker s starts with, and its length
+func markerAt(s string, markers map[string]string) (string, int) {
+	if !strings.HasPrefix(s, "CMDFYPARAM") {
+		return "", 0
+	}
+	end := strings.IndexByte(s, 'X')
+	if end < 0 {
+		return "", 0
+	}
+	name, ok := markers[s[:end+1]]
+	if !ok {
+		return "", 0
+	}
+	return name, end + 1
+}
+
+func escapeDoubleQuoted(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return r.Replace(s)
+}
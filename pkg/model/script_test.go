@@ -0,0 +1,156 @@
+package model
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	result := &CommandResult{
+		Steps: []CommandStep{
+			{Tool: "find", Args: []string{".", "-name", "*.log"}, Explanation: "Find the logs", Op: "|"},
+			{Tool: "tar", Args: []string{"-czf", "{{archive_name}}.tar.gz", "-T", "-"}, Explanation: "Archive them", Op: "&&"},
+			{Tool: "echo", Args: []string{"done"}},
+		},
+		Explanation: "Archive the logs as {{archive_name}}",
+		Parameters:  []Parameter{{Name: "archive_name", Description: "Name of the archive", Default: "logs", Type: ParamPath}},
+	}
+
+	script, err := result.Script(ShellBash, "archive the logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"#!/usr/bin/env bash\n",
+		"# Generated by cmdfy for: archive the logs\n",
+		"# Archive the logs as ${archive_name}\n",
+		"set -euo pipefail\n",
+		"# Name of the archive (path)\narchive_name=\"${archive_name:-logs}\"\n",
+		"# Find the logs\nfind . -name '*.log' |\n",
+		"  # Archive them\n  tar -czf \"${archive_name}\".tar.gz -T - &&\n",
+		"\necho done\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected script to contain %q, got:\n%s", want, script)
+		}
+	}
+}
+
+func TestScript_Shells(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{{Tool: "ls"}}}
+
+	script, err := result.Script(ShellPOSIX, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(script, "#!/bin/sh\n") || !strings.Contains(script, "set -eu\n") {
+		t.Errorf("Expected a portable sh script, got:\n%s", script)
+	}
+
+	// Without pipefail a failure before the last stage would go unnoticed
+	piped := &CommandResult{Steps: []CommandStep{{Tool: "ls", Op: "|"}, {Tool: "wc", Args: []string{"-l"}}}}
+	script, err = piped.Script(ShellPOSIX, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(script, "#!/usr/bin/env bash\n") || !strings.Contains(script, "set -euo pipefail\n") {
+		t.Errorf("Expected a bash script for a pipeline, got:\n%s", script)
+	}
+
+	if _, err := result.Script(ShellFish, ""); err == nil {
+		t.Error("Expected an error for fish")
+	}
+}
+
+func TestScript_InvalidParameter(t *testing.T) {
+	for _, name := range []string{"x;rm -rf ~;y", "a b", "1st", ""} {
+		result := &CommandResult{
+			Steps:      []CommandStep{{Tool: "echo", Args: []string{"hi"}}},
+			Parameters: []Parameter{{Name: name, Default: "x"}},
+		}
+		if _, err := result.Script(ShellBash, ""); err == nil {
+			t.Errorf("Expected the parameter name %q to be rejected", name)
+		}
+	}
+}
+
+func TestSubstituteVariables(t *testing.T) {
+	markers := map[string]string{"CMDFYPARAM0X": "name", "CMDFYPARAM1X": "host"}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"cat CMDFYPARAM0X", `cat "${name}"`},
+		{"cat CMDFYPARAM0X.txt", `cat "${name}".txt`},
+		{"cat 'CMDFYPARAM0X'", `cat "${name}"`},
+		{"cat 'my CMDFYPARAM0X file'", `cat 'my '"${name}"' file'`},
+		{"scp 'a b' CMDFYPARAM1X:'CMDFYPARAM0X'", `scp 'a b' "${host}":"${name}"`},
+		{`echo 'it'\''s CMDFYPARAM0X'`, `echo 'it'\''s '"${name}"`},
+		{"echo CMDFYPARAM9X", "echo CMDFYPARAM9X"},
+	}
+	for _, tt := range tests {
+		if got := substituteVariables(tt.line, markers); got != tt.want {
+			t.Errorf("substituteVariables(%q): expected %q, got %q", tt.line, tt.want, got)
+		}
+	}
+}
+
+func TestScript_Runs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	result := &CommandResult{
+		Steps: []CommandStep{
+			{Tool: "printf", Args: []string{"%s\n", "it's {{greeting}}"}, Op: ">"},
+			{Tool: "{{out}}"},
+		},
+		Parameters: []Parameter{{Name: "greeting", Default: "hello world"}, {Name: "out"}},
+	}
+	script, err := result.Script(ShellPOSIX, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run needs no parameters set
+	cmd := exec.Command(path, "--dry-run")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Dry run without parameters failed: %v\n%s", err, output)
+	}
+
+	out := filepath.Join(dir, "out file.txt")
+	cmd = exec.Command(path, "--dry-run")
+	cmd.Env = append(os.Environ(), "out="+out)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("Expected the dry run not to write anything")
+	}
+
+	cmd = exec.Command(path)
+	cmd.Env = append(os.Environ(), "out="+out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Script failed: %v\n%s\n%s", err, output, script)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "it's hello world\n" {
+		t.Errorf("Unexpected output %q", data)
+	}
+
+	if err := exec.Command(path).Run(); err == nil {
+		t.Error("Expected the script to fail without a value for out")
+	}
+}
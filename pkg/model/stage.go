@@ -0,0 +1,63 @@
+package model
+
+import "strings"
+
+// Stage is a group of steps that run as one shell invocation: a single
+// command, a pipeline, or a command with its legacy redirect target.
+// Stages are joined by "&&", "||" or ";".
+type Stage struct {
+	// Start is the index of the stage's first step in CommandResult.Steps
+	Start int
+	// Steps holds the stage's steps, with the operator of the last one cleared
+	Steps []CommandStep
+	// Op connects the stage to the next one. Empty for the last stage.
+	Op string
+}
+
+// Stages splits the pipeline at its list operators so that each stage can be
+// run on its own.
+func (r *CommandResult) Stages() []Stage {
+	var stages []Stage
+	current := Stage{}
+	for i, step := range r.Steps {
+		if len(current.Steps) == 0 {
+			current.Start = i
+		}
+		op := step.Op
+		if op == "" && step.Background && i < len(r.Steps)-1 {
+			op = ";"
+		}
+
+		switch op {
+		case "&&", "||", ";":
+			step.Op = ""
+			current.Steps = append(current.Steps, step)
+			current.Op = op
+			stages = append(stages, current)
+			current = Stage{}
+		default:
+			current.Steps = append(current.Steps, step)
+		}
+	}
+	if len(current.Steps) > 0 {
+		current.Steps[len(current.Steps)-1].Op = ""
+		stages = append(stages, current)
+	}
+	return stages
+}
+
+// Render builds the command line for the stage, see CommandResult.Render
+func (s Stage) Render(shell Shell) string {
+	return RenderSteps(s.Steps, shell)
+}
+
+// Explanation joins the explanations of the stage's steps
+func (s Stage) Explanation() string {
+	var parts []string
+	for _, step := range s.Steps {
+		if step.Explanation != "" {
+			parts = append(parts, step.Explanation)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,49 @@
+package model
+
+import "testing"
+
+func TestStages(t *testing.T) {
+	result := &CommandResult{Steps: []CommandStep{
+		{Tool: "make", Explanation: "Build", Op: "&&"},
+		{Tool: "grep", Args: []string{"-c", "FAIL"}, Op: "|"},
+		{Tool: "tee", Args: []string{"report.txt"}, Explanation: "Save the report", Op: "||"},
+		{Tool: "echo", Args: []string{"it's broken"}, Op: ">"},
+		{Tool: "status.txt", Op: ";"},
+		{Tool: "sleep", Args: []string{"1"}, Background: true},
+		{Tool: "ls"},
+	}}
+
+	stages := result.Stages()
+
+	want := []struct {
+		start    int
+		rendered string
+		op       string
+	}{
+		{0, "make", "&&"},
+		{1, "grep -c FAIL | tee report.txt", "||"},
+		{3, "echo 'it'\\''s broken' > status.txt", ";"},
+		{5, "sleep 1 &", ";"},
+		{6, "ls", ""},
+	}
+
+	if len(stages) != len(want) {
+		t.Fatalf("Expected %d stages, got %d: %+v", len(want), len(stages), stages)
+	}
+	for i, w := range want {
+		s := stages[i]
+		if s.Start != w.start || s.Op != w.op {
+			t.Errorf("Stage %d: expected start %d op %q, got start %d op %q", i, w.start, w.op, s.Start, s.Op)
+		}
+		if got := s.Render(ShellPOSIX); got != w.rendered {
+			t.Errorf("Stage %d: expected %s, got %s", i, w.rendered, got)
+		}
+	}
+
+	if got := stages[1].Explanation(); got != "Save the report" {
+		t.Errorf("Expected the pipeline's explanation, got %q", got)
+	}
+	if result.Steps[0].Op != "&&" {
+		t.Error("Stages must not modify the original steps")
+	}
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// wrappers run another command given as their arguments.
+// The value is the set of flags that consume the following argument.
+var wrappers = map[string]map[string]bool{
+	"sudo":    {"-u": true, "-g": true, "-C": true, "-h": true, "-p": true},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true},
+	"nohup":   {},
+	"nice":    {"-n": true},
+	"time":    {},
+	"command": {},
+	"xargs":   {"-I": true, "-n": true, "-P": true, "-d": true, "-L": true, "-s": true, "-E": true, "-a": true},
+}
+
+// Unwrap removes one wrapper such as sudo or env and returns the command it
+// runs, with its tool reduced to a base name. ok is false when tool is not a
+// wrapper or wraps nothing.
+func Unwrap(tool string, args []string) (inner string, innerArgs []string, ok bool) {
+	tool = BaseName(tool)
+	flagsWithValue, ok := wrappers[tool]
+	if !ok {
+		return "", nil, false
+	}
+
+	rest := args
+	for len(rest) > 0 {
+		a := rest[0]
+		if flagsWithValue[a] {
+			rest = rest[min(2, len(rest)):]
+			continue
+		}
+		if strings.HasPrefix(a, "-") || (tool == "env" && strings.Contains(a, "=")) {
+			rest = rest[1:]
+			continue
+		}
+		break
+	}
+	if len(rest) == 0 {
+		return "", nil, false
+	}
+	return BaseName(rest[0]), rest[1:], true
+}
+
+// ToolChain returns the programs a step runs, outermost first, e.g.
+// ["sudo", "rm"] for "sudo rm -rf build".
+func ToolChain(step CommandStep) []string {
+	tool, args := BaseName(step.Tool), step.Args
+	chain := []string{tool}
+	for {
+		next, nextArgs, ok := Unwrap(tool, args)
+		if !ok {
+			return chain
+		}
+		tool, args = next, nextArgs
+		chain = append(chain, tool)
+	}
+}
+
+// BaseName is the program name of a tool given as a path, e.g. "rm" for
+// "/bin/rm"
+func BaseName(tool string) string {
+	return filepath.Base(strings.TrimSpace(tool))
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToolChain(t *testing.T) {
+	tests := []struct {
+		step CommandStep
+		want []string
+	}{
+		{CommandStep{Tool: "rm", Args: []string{"-rf", "build"}}, []string{"rm"}},
+		{CommandStep{Tool: "/usr/bin/sudo", Args: []string{"-u", "root", "rm", "x"}}, []string{"sudo", "rm"}},
+		{CommandStep{Tool: "env", Args: []string{"A=1", "nice", "-n", "5", "make"}}, []string{"env", "nice", "make"}},
+		{CommandStep{Tool: "xargs", Args: []string{"-a", "list.txt", "-0", "rm"}}, []string{"xargs", "rm"}},
+		{CommandStep{Tool: "sudo", Args: []string{"-v"}}, []string{"sudo"}},
+	}
+
+	for _, tt := range tests {
+		if got := ToolChain(tt.step); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ToolChain(%v) = %v, want %v", tt.step, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/safety"
+)
+
+// FileName is the name of the policy file, both in ~/.cmdfy and in a
+// project's .cmdfy directory
+const FileName = "policy.yaml"
+
+// Policy is a set of rules a command must pass before cmdfy executes it.
+// Unlike the prompt, they do not depend on the model obeying.
+type Policy struct {
+	// AllowedTools, when not empty, is the only tools that may run
+	AllowedTools []string `yaml:"allowed_tools,omitempty"`
+	DeniedTools  []string `yaml:"denied_tools,omitempty"`
+	// DeniedArgs refuses steps whose arguments match a pattern
+	DeniedArgs []ArgRule `yaml:"denied_args,omitempty"`
+	// ReadOnly refuses any step that writes, moves or deletes files
+	ReadOnly bool `yaml:"read_only,omitempty"`
+	// AutoExecute says whether -y may run a command of each risk level.
+	// Levels that are not listed are allowed.
+	AutoExecute map[model.RiskLevel]bool `yaml:"auto_execute,omitempty"`
+
+	// Source is the file the policy was loaded from
+	Source string `yaml:"-"`
+}
+
+// ArgRule denies a regular expression matched against a step's arguments
+// joined by spaces
+type ArgRule struct {
+	Tool    string `yaml:"tool,omitempty"` // Empty matches every tool
+	Pattern string `yaml:"pattern"`
+	Reason  string `yaml:"reason,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Violation is a rule a step broke
+type Violation struct {
+	Step   int // 0-based index into CommandResult.Steps
+	Reason string
+	Source string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("step %d: %s (%s)", v.Step+1, v.Reason, v.Source)
+}
+
+// Set holds every policy that applies, all of which must pass
+type Set struct {
+	Policies []*Policy
+}
+
+// Load reads the global policy and the nearest project policy found from
+// dir upwards. Missing files are not an error.
+func Load(dir string) (*Set, error) {
+	set := &Set{}
+
+	var paths []string
+	home, err := os.UserHomeDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(home, ".cmdfy", FileName))
+	}
+	if project := findProject(dir, home); project != "" {
+		paths = append(paths, project)
+	}
+
+	for _, path := range paths {
+		p, err := LoadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		set.Policies = append(set.Policies, p)
+	}
+	return set, nil
+}
+
+// findProject looks for .cmdfy/policy.yaml in dir and its parents. The home
+// directory is skipped since its .cmdfy holds the global policy.
+func findProject(dir, home string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if dir != home {
+			path := filepath.Join(dir, ".cmdfy", FileName)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadFile reads a single policy file
+func LoadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{Source: path}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+	for i := range p.DeniedArgs {
+		re, err := regexp.Compile(p.DeniedArgs[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in policy %s: %w", path, err)
+		}
+		p.DeniedArgs[i].re = re
+	}
+	return p, nil
+}
+
+// Check returns every rule the command breaks
+func (s *Set) Check(result *model.CommandResult) []Violation {
+	var violations []Violation
+	for _, p := range s.Policies {
+		violations = append(violations, p.check(result.Steps, -1)...)
+	}
+	return violations
+}
+
+// AllowsAutoExecute reports whether -y may run a command of the given risk
+// level, and which policy forbids it if not.
+func (s *Set) AllowsAutoExecute(level model.RiskLevel) (bool, string) {
+	for _, p := range s.Policies {
+		if allowed, ok := p.AutoExecute[level]; ok && !allowed {
+			return false, p.Source
+		}
+	}
+	return true, ""
+}
+
+// check tests every step. Steps inside a subshell are reported against the
+// top-level step that contains them.
+func (p *Policy) check(steps []model.CommandStep, parent int) []Violation {
+	var violations []Violation
+	add := func(index int, reason string) {
+		v := Violation{Step: index, Reason: reason, Source: p.Source}
+		for _, seen := range violations {
+			if seen == v {
+				return
+			}
+		}
+		violations = append(violations, v)
+	}
+
+	for i, step := range steps {
+		if i > 0 && model.IsRedirect(steps[i-1].Op) {
+			// The step names the redirect's file, not a tool
+			continue
+		}
+		index := i
+		if parent >= 0 {
+			index = parent
+		}
+		if len(step.Subshell) > 0 {
+			for _, v := range p.check(step.Subshell, index) {
+				add(v.Step, v.Reason)
+			}
+		} else {
+			// Shell scripts and find -exec commands answer to the same rules
+			nested, ok := safety.Nested(step)
+			if !ok && p.restrictsSteps() {
+				add(index, "runs a shell script that can't be checked")
+			}
+			for _, v := range p.check(nested, index) {
+				add(v.Step, v.Reason)
+			}
+
+			chain := model.ToolChain(step)
+			for _, tool := range chain {
+				if contains(p.DeniedTools, tool) {
+					add(index, fmt.Sprintf("%s is denied", tool))
+				}
+			}
+			if len(p.AllowedTools) > 0 {
+				for _, tool := range chain {
+					if !contains(p.AllowedTools, tool) {
+						add(index, fmt.Sprintf("%s is not in the allowed tools", tool))
+					}
+				}
+			}
+
+			args := strings.Join(step.Args, " ")
+			for _, rule := range p.DeniedArgs {
+				if rule.Tool != "" && !contains(chain, rule.Tool) {
+					continue
+				}
+				if rule.re.MatchString(args) {
+					reason := rule.Reason
+					if reason == "" {
+						reason = fmt.Sprintf("arguments match denied pattern %q", rule.Pattern)
+					}
+					add(index, reason)
+				}
+			}
+		}
+
+		if p.ReadOnly && safety.ModifiesFiles(steps, i) {
+			add(index, "modifies files, but the policy is read-only")
+		}
+	}
+	return violations
+}
+
+// restrictsSteps reports whether the policy has rules about what steps run
+func (p *Policy) restrictsSteps() bool {
+	return len(p.AllowedTools) > 0 || len(p.DeniedTools) > 0 || len(p.DeniedArgs) > 0 || p.ReadOnly
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
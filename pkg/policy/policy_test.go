@@ -0,0 +1,198 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+func writePolicy(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, ".cmdfy", FileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func parse(t *testing.T, command string) *model.CommandResult {
+	t.Helper()
+	result, err := model.Parse(command)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", command, err)
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := writePolicy(t, dir, `
+denied_tools: [dd, curl]
+denied_args:
+  - tool: git
+    pattern: "push .*--force"
+    reason: force pushes are not allowed
+  - pattern: "^/etc"
+`)
+	p, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	set := &Set{Policies: []*Policy{p}}
+
+	tests := []struct {
+		command string
+		want    []int // steps with violations
+	}{
+		{"ls -la | grep go", nil},
+		{"sudo dd if=/dev/zero of=disk.img", []int{0}},
+		{"git fetch && git push origin main --force", []int{1}},
+		{"cat /etc/hosts", []int{0}},
+		{"echo hi; (cd x && curl -O https://example.com)", []int{1}},
+	}
+
+	for _, tt := range tests {
+		violations := set.Check(parse(t, tt.command))
+		var got []int
+		for _, v := range violations {
+			got = append(got, v.Step)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected violations at %v, got %v", tt.command, tt.want, violations)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected violations at %v, got %v", tt.command, tt.want, violations)
+			}
+		}
+	}
+
+	v := set.Check(parse(t, "git push -f origin main --force"))
+	if len(v) != 1 || v[0].Reason != "force pushes are not allowed" || v[0].Source != path {
+		t.Errorf("Expected the rule's reason and source, got %+v", v)
+	}
+}
+
+func TestCheck_AllowedToolsAndReadOnly(t *testing.T) {
+	set := &Set{Policies: []*Policy{{
+		AllowedTools: []string{"ls", "grep", "sed", "cat"},
+		ReadOnly:     true,
+		Source:       "test",
+	}}}
+
+	tests := []struct {
+		command string
+		refused bool
+	}{
+		{"ls | grep x", false},
+		{"sed s/a/b/ notes.txt", false},
+		{"sed -i s/a/b/ notes.txt", true},
+		{"cat a > b", true},
+		{"ls 2>/dev/null", false},
+		{"sudo ls", true},
+		{"find . -name x", true},
+	}
+
+	for _, tt := range tests {
+		violations := set.Check(parse(t, tt.command))
+		if refused := len(violations) > 0; refused != tt.refused {
+			t.Errorf("%s: expected refused=%v, got %v", tt.command, tt.refused, violations)
+		}
+	}
+}
+
+// TestCheck_Nested checks that a denied tool can't be run through a shell
+// script, find -exec or xargs instead of as a step of its own
+func TestCheck_Nested(t *testing.T) {
+	set := &Set{Policies: []*Policy{{
+		DeniedTools: []string{"rm"},
+		DeniedArgs:  []ArgRule{{Tool: "git", Pattern: "--force", re: regexp.MustCompile("--force")}},
+		Source:      "test",
+	}}}
+
+	tests := []struct {
+		command string
+		refused bool
+	}{
+		{`bash -c "rm -rf x"`, true},
+		{`sh -c 'cd build && rm -f *.o'`, true},
+		{`sudo bash -lc 'rm x'`, true},
+		{`sh -c 'sh -c "rm x"'`, true},
+		{`find . -name '*.tmp' -exec rm {} \;`, true},
+		{`find . -type f -execdir rm -f {} +`, true},
+		{`find . | xargs rm`, true},
+		{`xargs -a list.txt rm`, true},
+		{`find . -print0 | xargs -0 sh -c 'rm "$@"' _`, true},
+		{`bash -c 'git push --force'`, true},
+		{`eval 'rm -rf x'`, true},
+		{`bash -c 'for f in *; do echo "$f"; done'`, true},
+		{`bash -c 'ls -la'`, false},
+		{`find . -exec grep -l TODO {} +`, false},
+		{`echo "rm -rf x"`, false},
+	}
+
+	for _, tt := range tests {
+		violations := set.Check(parse(t, tt.command))
+		if refused := len(violations) > 0; refused != tt.refused {
+			t.Errorf("%s: expected refused=%v, got %v", tt.command, tt.refused, violations)
+		}
+	}
+
+	// A script can't be checked for a policy that only limits -y
+	lenient := &Set{Policies: []*Policy{{AutoExecute: map[model.RiskLevel]bool{model.RiskHigh: false}}}}
+	if v := lenient.Check(parse(t, `bash -c 'for f in *; do echo "$f"; done'`)); len(v) != 0 {
+		t.Errorf("Expected no violations, got %v", v)
+	}
+}
+
+func TestAllowsAutoExecute(t *testing.T) {
+	set := &Set{Policies: []*Policy{
+		{AutoExecute: map[model.RiskLevel]bool{model.RiskHigh: false}, Source: "global"},
+		{AutoExecute: map[model.RiskLevel]bool{model.RiskMedium: false, model.RiskHigh: true}, Source: "project"},
+	}}
+
+	tests := map[model.RiskLevel]string{
+		model.RiskNone:   "",
+		model.RiskMedium: "project",
+		model.RiskHigh:   "global", // A project can't loosen the global policy
+	}
+	for level, wantSource := range tests {
+		ok, source := set.AllowsAutoExecute(level)
+		if ok != (wantSource == "") || source != wantSource {
+			t.Errorf("%s: expected source %q, got %v %q", level, wantSource, ok, source)
+		}
+	}
+}
+
+func TestLoad_FindsProjectPolicy(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	project := t.TempDir()
+	path := writePolicy(t, project, "read_only: true\n")
+	nested := filepath.Join(project, "src", "pkg")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := Load(nested)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(set.Policies) != 1 || set.Policies[0].Source != path || !set.Policies[0].ReadOnly {
+		t.Errorf("Expected the project policy from %s, got %+v", path, set.Policies)
+	}
+}
+
+func TestLoadFile_InvalidPattern(t *testing.T) {
+	path := writePolicy(t, t.TempDir(), "denied_args:\n  - pattern: \"(\"\n")
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,195 @@
+package prompt
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+)
+
+//go:embed templates/*.tmpl
+var builtin embed.FS
+
+// ext is the extension of template files, which are named after the
+// template they define
+const ext = ".tmpl"
+
+// blankLines collapses the gaps left by empty sections
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// Prompt is a rendered system message and user message. Providers map the
+// two onto their own request format.
+type Prompt struct {
+	System string
+	User   string
+}
+
+// Data is what the templates are executed with
+type Data struct {
+	Query    string // The request, for the generate template
+	Command  string // The command, for the explain template
+	Meta     llm.SystemMetadata
+	MaxTools int    // The list function shows at most this many tools, 0 for all
+	Response string // The reply that could not be used, for the repair template
+	Problem  string // What was wrong with it
+}
+
+// Templates renders the prompts sent to providers from the built-in templates
+// and the user's own
+type Templates struct {
+	// MaxTools bounds the available tools listed, for models with a small
+	// context. Zero lists them all.
+	MaxTools int
+
+	tmpl    *template.Template
+	sources map[string]string // Name to the text it was parsed from
+	origins map[string]string // Name to the file it came from, "" if built in
+}
+
+// Load reads the built-in templates and the overrides in ~/.cmdfy/prompts
+func Load() (*Templates, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %w", err)
+	}
+	return LoadDir(filepath.Join(home, ".cmdfy", "prompts"))
+}
+
+// LoadDir reads the built-in templates and the *.tmpl files in dir, which
+// may not exist. A file named after a built-in template replaces it, any
+// other adds a template the others can include.
+func LoadDir(dir string) (*Templates, error) {
+	t := &Templates{
+		tmpl:    template.New("").Funcs(funcs),
+		sources: make(map[string]string),
+		origins: make(map[string]string),
+	}
+
+	entries, err := fs.ReadDir(builtin, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read built-in templates: %w", err)
+	}
+	for _, e := range entries {
+		data, err := builtin.ReadFile("templates/" + e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read built-in template %s: %w", e.Name(), err)
+		}
+		if err := t.add(strings.TrimSuffix(e.Name(), ext), string(data), ""); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read prompts dir: %w", err)
+	}
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), ext)
+		if f.IsDir() || !ok {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", path, err)
+		}
+		if err := t.add(name, string(data), path); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// add parses text as the template name, replacing any earlier definition.
+// The newline editors put at the end of a file is dropped so that templates
+// can be included mid-line.
+func (t *Templates) add(name, text, origin string) error {
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	if _, err := t.tmpl.New(name).Parse(text); err != nil {
+		if origin == "" {
+			origin = "built-in template " + name
+		}
+		return fmt.Errorf("failed to parse %s: %w", origin, err)
+	}
+	t.sources[name] = text
+	t.origins[name] = origin
+	return nil
+}
+
+// Generate renders the prompt that asks for a command for query
+func (t *Templates) Generate(query string, meta llm.SystemMetadata) (Prompt, error) {
+	return t.render("system", "generate", Data{Query: query, Meta: meta, MaxTools: t.MaxTools})
+}
+
+// Explain renders the prompt that asks for a breakdown of command
+func (t *Templates) Explain(command string, meta llm.SystemMetadata) (Prompt, error) {
+	return t.render("explain_system", "explain", Data{Command: command, Meta: meta, MaxTools: t.MaxTools})
+}
+
+// Repair extends p, a prompt whose reply could not be used, with the reply
+// and what was wrong with it, to ask the model for a corrected one
+func (t *Templates) Repair(p Prompt, response string, problem error) (Prompt, error) {
+	repair, err := t.execute("repair", Data{Response: strings.TrimSpace(response), Problem: problem.Error(), MaxTools: t.MaxTools})
+	if err != nil {
+		return p, err
+	}
+	return Prompt{System: p.System, User: p.User + "\n\n" + repair}, nil
+}
+
+func (t *Templates) render(system, user string, data Data) (Prompt, error) {
+	var p Prompt
+	var err error
+	if p.System, err = t.execute(system, data); err != nil {
+		return p, err
+	}
+	if p.User, err = t.execute(user, data); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func (t *Templates) execute(name string, data Data) (string, error) {
+	var sb strings.Builder
+	if err := t.tmpl.ExecuteTemplate(&sb, name, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt: %w", err)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(sb.String(), "\n\n")), nil
+}
+
+// Names returns the names of every template, sorted
+func (t *Templates) Names() []string {
+	names := make([]string, 0, len(t.sources))
+	for name := range t.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Source returns the text of a template and the file it was read from, which
+// is empty for a built-in one
+func (t *Templates) Source(name string) (text, origin string, ok bool) {
+	text, ok = t.sources[name]
+	return text, t.origins[name], ok
+}
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	"list": list,
+}
+
+// list joins items with commas, cutting it off after max of them
+func list(items []string, max int) string {
+	if max > 0 && len(items) > max {
+		return strings.Join(items[:max], ", ") + " (and others)"
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,178 @@
+package prompt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/brain"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+)
+
+func loadTest(t *testing.T, files map[string]string) *Templates {
+	dir := t.TempDir()
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpl, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir failed: %v", err)
+	}
+	return tmpl
+}
+
+func TestGenerate(t *testing.T) {
+	tmpl := loadTest(t, nil)
+
+	meta := llm.SystemMetadata{
+		OS:                "linux",
+		Shell:             "/bin/bash",
+		AvailableCommands: []string{"find", "grep"},
+		CurrentDirFiles:   []string{"main.go"},
+		UnavailableTools:  []string{"fd"},
+		PreviousCommand:   "ls /nope",
+		PreviousError:     "No such file or directory",
+		Alternatives:      3,
+		AvoidCommands:     []string{"ls -la"},
+		FewShotExamples:   []brain.BrainEntry{{Query: "big files", Command: "find . -size +1G", Provider: "system", Template: "find . -size +{{size}}"}},
+		Conversation:      []llm.ConversationTurn{{Query: "list files", Command: "ls", Outcome: "ran successfully"}},
+	}
+	p, err := tmpl.Generate("show hidden files too", meta)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if !strings.Contains(p.System, "structured shell commands in JSON") {
+		t.Errorf("Unexpected system prompt: %q", p.System)
+	}
+	for _, want := range []string{
+		`"parameters"`,
+		"Write it as {{name}} in args",
+		"Available Tools: find, grep\nNOT installed, do not use: fd\nCurrent Directory Files: main.go",
+		"- Query: big files\n  Command: find . -size +1G\n  Origin: system\n  Template: find . -size +{{size}}",
+		"- Request: list files\n  Command: ls\n  Result: ran successfully",
+		"Failed command:\nls /nope\nError output:\nNo such file or directory",
+		"Already suggested, use a different approach:\nls -la",
+	} {
+		if !strings.Contains(p.User, want) {
+			t.Errorf("Expected %q in the prompt", want)
+		}
+	}
+	if !strings.HasSuffix(p.User, "Request: show hidden files too") {
+		t.Errorf("Expected the prompt to end with the request, got %q", p.User[len(p.User)-50:])
+	}
+}
+
+func TestGenerate_EmptySectionsAreLeftOut(t *testing.T) {
+	tmpl := loadTest(t, nil)
+
+	p, err := tmpl.Generate("list files", llm.SystemMetadata{OS: "linux", Shell: "sh"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unwanted := range []string{"NOT installed", "Current Directory Files", "Reference", "refines", "FAILED", "alternative"} {
+		if strings.Contains(p.User, unwanted) {
+			t.Errorf("Expected no %q section in the prompt", unwanted)
+		}
+	}
+	if strings.Contains(p.User, "\n\n\n") {
+		t.Error("Expected empty sections not to leave gaps")
+	}
+}
+
+func TestGenerate_MaxTools(t *testing.T) {
+	tmpl := loadTest(t, nil)
+	tmpl.MaxTools = 2
+
+	p, err := tmpl.Generate("q", llm.SystemMetadata{AvailableCommands: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(p.User, "Available Tools: a, b (and others)\n") {
+		t.Errorf("Expected the tools to be cut off, got %q", p.User)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tmpl := loadTest(t, nil)
+
+	p, err := tmpl.Explain("ls -la | wc -l", llm.SystemMetadata{OS: "darwin", Shell: "zsh"})
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if !strings.Contains(p.System, "explains shell commands") {
+		t.Errorf("Unexpected system prompt: %q", p.System)
+	}
+	if !strings.Contains(p.User, `"summary"`) || !strings.HasSuffix(p.User, "Shell: zsh\nCommand: ls -la | wc -l") {
+		t.Errorf("Unexpected prompt: %q", p.User)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	tmpl := loadTest(t, nil)
+
+	p, err := tmpl.Generate("list files", llm.SystemMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repair, err := tmpl.Repair(p, `{"steps": "ls"} `, errors.New("steps should be an array"))
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+
+	if repair.System != p.System || !strings.HasPrefix(repair.User, p.User) {
+		t.Error("Expected the original prompt to be kept")
+	}
+	if !strings.Contains(repair.User, "could not be used: steps should be an array\n\nPrevious reply:\n{\"steps\": \"ls\"}\n") {
+		t.Errorf("Expected the reply and the problem, got %q", repair.User[len(p.User):])
+	}
+}
+
+func TestLoadDir_Overrides(t *testing.T) {
+	tmpl := loadTest(t, map[string]string{
+		"extra.tmpl":     "Prefer {{template \"favourite\" .}} over grep.\n",
+		"favourite.tmpl": "rg\n",
+		"system.tmpl":    "You only answer in JSON.\n",
+		"notes.txt":      "not a template",
+	})
+
+	p, err := tmpl.Generate("find TODOs", llm.SystemMetadata{})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if p.System != "You only answer in JSON." {
+		t.Errorf("Expected the system prompt to be replaced, got %q", p.System)
+	}
+	if !strings.Contains(p.User, "Prefer rg over grep.\n\nRequest: find TODOs") {
+		t.Errorf("Expected the extra instructions before the request, got %q", p.User)
+	}
+	if !strings.Contains(p.User, `"risk"`) {
+		t.Error("Expected the built-in schema to be kept")
+	}
+
+	if _, origin, ok := tmpl.Source("extra"); !ok || filepath.Base(origin) != "extra.tmpl" {
+		t.Errorf("Expected extra to come from the prompts dir, got %q", origin)
+	}
+	if _, origin, ok := tmpl.Source("schema"); !ok || origin != "" {
+		t.Errorf("Expected schema to be built in, got %q", origin)
+	}
+	names := strings.Join(tmpl.Names(), ",")
+	if !strings.Contains(names, "favourite") || strings.Contains(names, "notes") {
+		t.Errorf("Unexpected template names: %s", names)
+	}
+}
+
+func TestLoadDir_BrokenTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fix.tmpl"), []byte("{{if .Meta.PreviousError}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "fix.tmpl") {
+		t.Errorf("Expected an error naming the file, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// MaxStderrTail is how much of the end of stderr is kept for error context
+const MaxStderrTail = 2000
+
+// waitDelay bounds how long output is still read once a cancelled command's
+// shell is killed, as its children may hold the pipes open
+const waitDelay = 2 * time.Second
+
+// Result describes how a command line finished
+type Result struct {
+	ExitCode int
+	// StderrTail holds the last bytes the command wrote to stderr
+	StderrTail string
+	Duration   time.Duration
+}
+
+// Success reports whether the command exited with status zero
+func (r Result) Success() bool {
+	return r.ExitCode == 0
+}
+
+// Runner executes rendered command lines through the user's shell
+type Runner struct {
+	// Shell is the shell binary run with -c. Windows always uses cmd /C.
+	Shell string
+	// Dir is the working directory, empty for the current one
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// New returns a Runner attached to the terminal
+func New(shell string) *Runner {
+	return &Runner{
+		Shell:  shell,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// Run executes line and waits for it to finish. Output is streamed to the
+// runner's writers as it is produced. An error is returned only when the
+// command could not be started; a non-zero exit is reported in Result.
+func (r *Runner) Run(ctx context.Context, line string) (Result, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", line)
+	} else {
+		cmd = exec.CommandContext(ctx, r.Shell, "-c", line)
+	}
+
+	tail := &tailBuffer{max: MaxStderrTail}
+	cmd.WaitDelay = waitDelay
+	cmd.Dir = r.Dir
+	cmd.Stdin = r.Stdin
+	cmd.Stdout = r.Stdout
+	if r.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(r.Stderr, tail)
+	} else {
+		cmd.Stderr = tail
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	res := Result{StderrTail: string(tail.buf), Duration: time.Since(start)}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		return res, fmt.Errorf("failed to run command: %w", err)
+	}
+	return res, nil
+}
+
+// Chdir changes the runner's working directory the way "cd" would. Each
+// command runs in its own shell, so a bare "cd" has to be applied here.
+func (r *Runner) Chdir(dir string) error {
+	home, _ := os.UserHomeDir()
+	switch {
+	case dir == "" || dir == "~":
+		dir = home
+	case strings.HasPrefix(dir, "~/"):
+		dir = filepath.Join(home, dir[2:])
+	case !filepath.IsAbs(dir) && r.Dir != "":
+		dir = filepath.Join(r.Dir, dir)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to change directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("failed to change directory: %s is not a directory", dir)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to change directory: %w", err)
+	}
+	r.Dir = abs
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
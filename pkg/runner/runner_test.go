@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRunner(t *testing.T) (*Runner, *bytes.Buffer, *bytes.Buffer) {
+	if runtime.GOOS == "windows" {
+		t.Skip("runner tests use sh")
+	}
+	var stdout, stderr bytes.Buffer
+	return &Runner{Shell: "sh", Stdout: &stdout, Stderr: &stderr}, &stdout, &stderr
+}
+
+func TestRun_ExitCodeAndStderr(t *testing.T) {
+	r, stdout, stderr := newTestRunner(t)
+
+	res, err := r.Run(context.Background(), "echo out; echo oops >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != 3 || res.Success() {
+		t.Errorf("Expected exit code 3, got %d", res.ExitCode)
+	}
+	if stdout.String() != "out\n" {
+		t.Errorf("Expected stdout to be streamed, got %q", stdout.String())
+	}
+	if stderr.String() != "oops\n" || res.StderrTail != "oops\n" {
+		t.Errorf("Expected stderr to be streamed and captured, got %q and %q", stderr.String(), res.StderrTail)
+	}
+}
+
+func TestRun_StderrTailIsBounded(t *testing.T) {
+	r, _, _ := newTestRunner(t)
+
+	res, err := r.Run(context.Background(), `i=0; while [ $i -lt 500 ]; do echo "line $i" >&2; i=$((i+1)); done`)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(res.StderrTail) != MaxStderrTail {
+		t.Errorf("Expected %d bytes of stderr, got %d", MaxStderrTail, len(res.StderrTail))
+	}
+	if !strings.HasSuffix(res.StderrTail, "line 499\n") {
+		t.Errorf("Expected the tail to end with the last line, got %q", res.StderrTail[len(res.StderrTail)-20:])
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	r, _, _ := newTestRunner(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The background sleep keeps stderr open after the shell is killed
+	start := time.Now()
+	res, err := r.Run(ctx, "echo started >&2; sleep 30 & sleep 30")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.Success() {
+		t.Error("Expected a timed out command to fail")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected Run to return soon after the timeout, took %s", time.Since(start))
+	}
+	if res.StderrTail != "started\n" {
+		t.Errorf("Expected the output before the timeout, got %q", res.StderrTail)
+	}
+}
+
+func TestChdir(t *testing.T) {
+	r, stdout, _ := newTestRunner(t)
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Chdir(dir); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	if err := r.Chdir("sub dir"); err != nil {
+		t.Fatalf("Relative Chdir failed: %v", err)
+	}
+	if err := r.Chdir("missing"); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+
+	if _, err := r.Run(context.Background(), "pwd"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want, _ := filepath.EvalSymlinks(filepath.Join(dir, "sub dir"))
+	got, _ := filepath.EvalSymlinks(strings.TrimSpace(stdout.String()))
+	if got != want {
+		t.Errorf("Expected to run in %s, got %s", want, got)
+	}
+}
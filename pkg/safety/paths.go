@@ -0,0 +1,237 @@
+package safety
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// pathTools are commands that modify the files they are given. The value is
+// the set of flags that consume the following argument.
+var pathTools = map[string]map[string]bool{
+	"rm":       {},
+	"rmdir":    {},
+	"unlink":   {},
+	"shred":    {"-n": true, "-s": true},
+	"truncate": {"-s": true, "-r": true},
+	"touch":    {"-r": true, "-d": true, "-t": true},
+	"tee":      {},
+	"mkdir":    {"-m": true},
+	"chmod":    {"--reference": true},
+	"chown":    {"--reference": true},
+	"chgrp":    {"--reference": true},
+	"mv":       {"-t": true, "-S": true},
+	"cp":       {"-t": true, "-S": true},
+	"install":  {"-t": true, "-m": true, "-o": true, "-g": true, "-S": true},
+	"ln":       {"-t": true, "-S": true},
+	"sed":      {"-e": true, "-f": true, "-l": true},
+}
+
+// AffectedPaths predicts the files the command will create, modify or delete,
+// as absolute paths relative to dir. In steps that expand, ~ and globs are
+// expanded the way the shell will, and arguments holding variables or
+// command substitutions are left out since their value is only known at run
+// time. The paths the model listed itself are included too.
+func AffectedPaths(result *model.CommandResult, dir string) []string {
+	if result == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	add := func(p string, expand bool) {
+		for _, abs := range resolvePath(p, dir, expand) {
+			if !seen[abs] {
+				seen[abs] = true
+				paths = append(paths, abs)
+			}
+		}
+	}
+
+	for _, p := range result.AffectedPaths {
+		add(p, true)
+	}
+	collectPaths(result.Steps, dir, add)
+	return paths
+}
+
+func collectPaths(steps []model.CommandStep, dir string, add func(string, bool)) {
+	for i, step := range steps {
+		if isRedirectTarget(steps, i) {
+			continue
+		}
+		if len(step.Subshell) > 0 {
+			collectPaths(step.Subshell, dir, add)
+		}
+		for _, target := range writeTargets(steps, i) {
+			add(target.path, step.Expand)
+		}
+
+		inv := unwrap(step)
+		if inv.tool == "dd" {
+			for _, a := range inv.args {
+				if strings.HasPrefix(a, "of=") {
+					add(strings.TrimPrefix(a, "of="), step.Expand)
+				}
+			}
+			continue
+		}
+
+		flagsWithValue, ok := pathTools[inv.tool]
+		if !ok {
+			continue
+		}
+		args := pathOperands(inv.args, flagsWithValue)
+
+		switch inv.tool {
+		case "chmod", "chown", "chgrp":
+			// The first operand is the mode or owner
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		case "sed":
+			if !hasFlag(inv.args, "i", "--in-place") {
+				continue
+			}
+			// Without -e or -f the script is the first operand
+			if !hasFlag(inv.args, "ef", "--expression", "--file") && len(args) > 0 {
+				args = args[1:]
+			}
+		case "cp", "install", "ln":
+			// Only the destination is written
+			args = destinations(args, dir, step.Expand)
+		case "mv":
+			// Sources disappear and the destination is written
+			if len(args) > 1 {
+				dests := destinations(args, dir, step.Expand)
+				args = append(args[:len(args)-1], dests...)
+			}
+		}
+
+		for _, a := range args {
+			add(a, step.Expand)
+		}
+	}
+}
+
+// ModifiesFiles reports whether step i writes, moves or deletes files,
+// including through redirects and inside a subshell.
+func ModifiesFiles(steps []model.CommandStep, i int) bool {
+	step := steps[i]
+	for j := range step.Subshell {
+		if ModifiesFiles(step.Subshell, j) {
+			return true
+		}
+	}
+	if len(writeTargets(steps, i)) > 0 {
+		return true
+	}
+
+	inv := unwrap(step)
+	switch inv.tool {
+	case "dd":
+		for _, a := range inv.args {
+			if strings.HasPrefix(a, "of=") {
+				return true
+			}
+		}
+		return false
+	case "sed":
+		return hasFlag(inv.args, "i", "--in-place")
+	case "find":
+		_, deletes := ruleFindDelete(steps, i, inv)
+		return deletes
+	}
+	_, ok := pathTools[inv.tool]
+	return ok
+}
+
+// pathOperands returns the non-flag arguments, skipping flag values
+func pathOperands(args []string, flagsWithValue map[string]bool) []string {
+	var out []string
+	afterDashes := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case afterDashes:
+			out = append(out, a)
+		case a == "--":
+			afterDashes = true
+		case flagsWithValue[a]:
+			i++
+		case strings.HasPrefix(a, "-") && a != "-":
+		default:
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// destinations returns what cp, mv and ln write to: the last operand, or the
+// copies inside it when it is an existing directory.
+func destinations(args []string, dir string, expand bool) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	dest := args[len(args)-1]
+	abs := dest
+	if expand {
+		abs = model.Unescape(expandHome(dest))
+	}
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(dir, abs)
+	}
+	info, err := os.Stat(abs)
+	if err != nil || !info.IsDir() {
+		return []string{dest}
+	}
+	var out []string
+	for _, src := range args[:len(args)-1] {
+		out = append(out, filepath.Join(dest, filepath.Base(src)))
+	}
+	return out
+}
+
+// resolvePath turns an argument into absolute paths. When the shell expands
+// it, ~ and globs are expanded too.
+func resolvePath(p, dir string, expand bool) []string {
+	p = strings.Trim(p, `"'`)
+	if p == "" || p == "-" || strings.HasPrefix(p, "/dev/") {
+		return nil
+	}
+	if expand {
+		if strings.ContainsAny(p, "$`") {
+			return nil
+		}
+		p = expandHome(p)
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+
+	if expand && strings.ContainsAny(p, "*?[") {
+		// Glob honors the backslash escapes as well
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil
+		}
+		return matches
+	}
+	if expand {
+		p = model.Unescape(p)
+	}
+	return []string{filepath.Clean(p)}
+}
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
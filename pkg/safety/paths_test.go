@@ -0,0 +1,79 @@
+package safety
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+func TestAffectedPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "keep.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "backup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	abs := func(names ...string) []string {
+		out := make([]string, len(names))
+		for i, n := range names {
+			out[i] = filepath.Join(dir, n)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		steps []model.CommandStep
+		want  []string
+	}{
+		{"read only", []model.CommandStep{step("cat", "keep.txt")}, nil},
+		{"rm glob", []model.CommandStep{expanding("rm", "-f", "*.log")}, abs("a.log", "b.log")},
+		{"rm literal glob", []model.CommandStep{step("rm", "-f", "*.log")}, abs("*.log")},
+		{"rm escaped glob", []model.CommandStep{expanding("rm", `\*.log`, "x?.log")}, nil},
+		{"sed in place", []model.CommandStep{step("sed", "-i", "s/a/b/", "keep.txt")}, abs("keep.txt")},
+		{"sed to stdout", []model.CommandStep{step("sed", "s/a/b/", "keep.txt")}, nil},
+		{"mv into dir", []model.CommandStep{step("mv", "a.log", "backup")}, abs("a.log", "backup/a.log")},
+		{"cp to file", []model.CommandStep{step("cp", "keep.txt", "copy.txt")}, abs("copy.txt")},
+		{"chmod", []model.CommandStep{step("sudo", "chmod", "600", "keep.txt")}, abs("keep.txt")},
+		{"redirect", []model.CommandStep{{Tool: "date", Redirects: []model.Redirect{{Op: ">>", Target: "keep.txt"}, {FD: 2, Op: ">", Target: "/dev/null"}}}}, abs("keep.txt")},
+		{"legacy redirect", []model.CommandStep{{Tool: "date", Op: ">"}, step("out.txt")}, abs("out.txt")},
+		{"variable", []model.CommandStep{expanding("rm", "$FILE")}, nil},
+		{"literal dollar", []model.CommandStep{step("rm", "$FILE")}, abs("$FILE")},
+		{"subshell", []model.CommandStep{{Subshell: []model.CommandStep{step("touch", "new.txt")}}}, abs("new.txt")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AffectedPaths(&model.CommandResult{Steps: tt.steps}, dir)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func expanding(tool string, args ...string) model.CommandStep {
+	s := step(tool, args...)
+	s.Expand = true
+	return s
+}
+
+func TestAffectedPaths_IncludesModelPaths(t *testing.T) {
+	dir := t.TempDir()
+	result := &model.CommandResult{
+		Steps:         []model.CommandStep{step("rm", "x.txt")},
+		AffectedPaths: []string{"x.txt", "y.txt"},
+	}
+
+	want := []string{filepath.Join(dir, "x.txt"), filepath.Join(dir, "y.txt")}
+	if got := AffectedPaths(result, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
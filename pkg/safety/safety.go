@@ -0,0 +1,561 @@
+package safety
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// finding is a single rule match against a step of the pipeline
+type finding struct {
+	level      model.RiskLevel
+	reason     string
+	categories []model.RiskCategory
+}
+
+// rule inspects the step at index i and returns a finding if it matches.
+// The whole pipeline is passed so that rules can look at neighbouring steps.
+type rule func(steps []model.CommandStep, i int, inv invocation) (finding, bool)
+
+var rules = []rule{
+	ruleElevated,
+	ruleRecursiveDelete,
+	ruleDiskDestroyers,
+	ruleChmod,
+	ruleSystemWrites,
+	rulePipeToShell,
+	ruleGit,
+	ruleOverwrite,
+	ruleFindDelete,
+	rulePower,
+	ruleNetwork,
+	ruleOutsideCwd,
+}
+
+// Analyze walks every step of the result against the built-in rules.
+// It never trusts the risk reported by the model.
+func Analyze(result *model.CommandResult) model.Risk {
+	risk := model.Risk{Level: model.RiskNone}
+	if result == nil {
+		return risk
+	}
+
+	return analyze(risk, result.Steps, -1)
+}
+
+// analyze applies the rules to steps. Findings inside a subshell are
+// reported against the top-level step that contains it.
+func analyze(risk model.Risk, steps []model.CommandStep, parent int) model.Risk {
+	for i, step := range steps {
+		if isRedirectTarget(steps, i) {
+			continue
+		}
+		index := i
+		if parent >= 0 {
+			index = parent
+		}
+		if len(step.Subshell) > 0 {
+			risk = analyze(risk, step.Subshell, index)
+		}
+
+		nested, ok := Nested(step)
+		if !ok {
+			risk = risk.Merge(model.Risk{
+				Level: model.RiskHigh,
+				Steps: []model.StepRisk{{Step: index, Level: model.RiskHigh, Reason: "runs a script that can't be checked"}},
+			})
+		}
+		if len(nested) > 0 {
+			risk = analyze(risk, nested, index)
+		}
+
+		inv := unwrap(step)
+		for _, r := range rules {
+			f, ok := r(steps, i, inv)
+			if !ok {
+				continue
+			}
+			risk = risk.Merge(model.Risk{
+				Level:      f.level,
+				Categories: f.categories,
+				Steps:      []model.StepRisk{{Step: index, Level: f.level, Reason: f.reason}},
+			})
+		}
+	}
+	return risk
+}
+
+// Assess merges the model's own assessment with the local analysis, so the
+// result is dangerous when either one of them says so.
+func Assess(result *model.CommandResult) model.Risk {
+	if result == nil {
+		return model.Risk{Level: model.RiskNone}
+	}
+	return result.Risk.Merge(Analyze(result))
+}
+
+// invocation is a step with wrappers such as sudo or env peeled off
+type invocation struct {
+	tool     string
+	args     []string
+	elevated bool
+}
+
+// Nested returns the commands a step runs through its arguments: the script
+// a shell is given with -c or eval is given, and the commands find runs with
+// -exec. Wrappers
+// such as sudo and xargs are looked through first. ok is false when there is
+// a script that can't be parsed, so what it runs is unknown.
+func Nested(step model.CommandStep) (nested []model.CommandStep, ok bool) {
+	inv := unwrap(step)
+	switch {
+	case shells[inv.tool]:
+		for i, a := range inv.args {
+			if a == "--" || !strings.HasPrefix(a, "-") {
+				break
+			}
+			if strings.HasPrefix(a, "--") || !strings.Contains(a, "c") {
+				continue
+			}
+			if i+1 >= len(inv.args) {
+				return nil, true
+			}
+			script, err := model.Parse(inv.args[i+1])
+			if err != nil {
+				return nil, false
+			}
+			return script.Steps, true
+		}
+	case inv.tool == "eval":
+		if len(inv.args) == 0 {
+			return nil, true
+		}
+		script, err := model.Parse(strings.Join(inv.args, " "))
+		if err != nil {
+			return nil, false
+		}
+		return script.Steps, true
+	case inv.tool == "find":
+		for i := 0; i < len(inv.args); i++ {
+			switch inv.args[i] {
+			case "-exec", "-execdir", "-ok", "-okdir":
+			default:
+				continue
+			}
+			end := i + 1
+			for end < len(inv.args) && inv.args[end] != ";" && inv.args[end] != "+" {
+				end++
+			}
+			if end > i+1 {
+				nested = append(nested, model.CommandStep{Tool: inv.args[i+1], Args: inv.args[i+2 : end]})
+			}
+			i = end
+		}
+	}
+	return nested, true
+}
+
+func unwrap(step model.CommandStep) invocation {
+	inv := invocation{tool: model.BaseName(step.Tool), args: step.Args}
+	for {
+		next, ok := peel(inv)
+		if !ok {
+			return inv
+		}
+		inv = next
+	}
+}
+
+// peel removes one wrapper from the invocation. It reports false when the
+// tool is not a wrapper or wraps nothing.
+func peel(inv invocation) (invocation, bool) {
+	tool, args, ok := model.Unwrap(inv.tool, inv.args)
+	if !ok {
+		return inv, false
+	}
+	elevated := inv.elevated || inv.tool == "sudo" || inv.tool == "doas"
+	return invocation{tool: tool, args: args, elevated: elevated}, true
+}
+
+// isRedirectTarget reports whether step i is the file operand of a redirect
+func isRedirectTarget(steps []model.CommandStep, i int) bool {
+	return i > 0 && model.IsRedirect(steps[i-1].Op)
+}
+
+// isRedirectOp reports whether op writes to the file named by the next step
+func isRedirectOp(op string) bool {
+	return op == ">" || op == ">>"
+}
+
+// writeTarget is a file the step's output is redirected into
+type writeTarget struct {
+	path     string
+	truncate bool
+}
+
+// writeTargets collects the files step i writes through redirects, both the
+// explicit ones and the legacy "> next-step" form. Output streams like
+// /dev/null are not files and are left out.
+func writeTargets(steps []model.CommandStep, i int) []writeTarget {
+	var targets []writeTarget
+	add := func(path string, truncate bool) {
+		switch strings.Trim(path, `"'`) {
+		case "/dev/null", "/dev/stdout", "/dev/stderr", "/dev/tty":
+			return
+		}
+		targets = append(targets, writeTarget{path: path, truncate: truncate})
+	}
+	if isRedirectOp(steps[i].Op) && i+1 < len(steps) {
+		add(steps[i+1].Tool, steps[i].Op == ">")
+	}
+	for _, rd := range steps[i].Redirects {
+		if rd.Writes() {
+			add(rd.Target, !strings.HasSuffix(rd.Op, ">>"))
+		}
+	}
+	return targets
+}
+
+// hasFlag reports whether any argument is one of the long flags or a short
+// flag cluster containing one of the short letters (e.g. -rf contains r).
+func hasFlag(args []string, short string, long ...string) bool {
+	for _, a := range args {
+		if a == "--" {
+			return false
+		}
+		for _, l := range long {
+			if a == l {
+				return true
+			}
+		}
+		if short != "" && len(a) > 1 && a[0] == '-' && a[1] != '-' && strings.ContainsAny(a[1:], short) {
+			return true
+		}
+	}
+	return false
+}
+
+// operands returns the non-flag arguments
+func operands(args []string) []string {
+	var out []string
+	afterDashes := false
+	for _, a := range args {
+		if !afterDashes && a == "--" {
+			afterDashes = true
+			continue
+		}
+		if !afterDashes && strings.HasPrefix(a, "-") && a != "-" {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// systemPaths are locations a generated command should almost never write to
+var systemPaths = []string{"/etc", "/boot", "/bin", "/sbin", "/lib", "/lib64", "/usr", "/var/lib", "/System", "/dev"}
+
+func isSystemPath(p string) bool {
+	p = strings.Trim(p, `"'`)
+	for _, sp := range systemPaths {
+		if p == sp || strings.HasPrefix(p, sp+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSweepingTarget catches deletes that resolve to "everything" or that
+// become "/" when a variable expands to the empty string.
+func isSweepingTarget(p string) bool {
+	p = strings.Trim(p, `"'`)
+	switch p {
+	case "/", "/*", "~", "~/", "~/*", "*", ".", "..", "./*", "$HOME", "${HOME}", "$HOME/", "$HOME/*":
+		return true
+	}
+	if !strings.HasPrefix(p, "$") {
+		return false
+	}
+	// Strip the variable reference and see what is left, e.g. "$DIR"/ or ${DIR}/*
+	rest := p[1:]
+	if strings.HasPrefix(rest, "{") {
+		end := strings.Index(rest, "}")
+		if end == -1 {
+			return false
+		}
+		rest = rest[end+1:]
+	} else {
+		rest = strings.TrimLeft(rest, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_")
+	}
+	rest = strings.TrimPrefix(rest, `"`)
+	return rest == "/" || rest == "/*"
+}
+
+// isOutsideCwd reports whether a path points away from the working directory
+func isOutsideCwd(p string) bool {
+	p = strings.Trim(p, `"'`)
+	if p == "" || p == "/dev/null" || p == "-" {
+		return false
+	}
+	if filepath.IsAbs(p) || strings.HasPrefix(p, "~") || strings.HasPrefix(p, "$HOME") {
+		return true
+	}
+	clean := filepath.Clean(p)
+	return clean == ".." || strings.HasPrefix(clean, "../")
+}
+
+func ruleElevated(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	if inv.elevated {
+		return finding{model.RiskMedium, "runs with elevated privileges", []model.RiskCategory{model.CategoryPrivilegeEscalation}}, true
+	}
+	if inv.tool == "su" {
+		return finding{model.RiskMedium, "switches user", []model.RiskCategory{model.CategoryPrivilegeEscalation}}, true
+	}
+	return finding{}, false
+}
+
+func ruleRecursiveDelete(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	if inv.tool != "rm" && inv.tool != "rmdir" {
+		return finding{}, false
+	}
+	deletes := []model.RiskCategory{model.CategoryDeletesData, model.CategoryIrreversible}
+	for _, target := range operands(inv.args) {
+		if isSweepingTarget(target) || isSystemPath(target) {
+			return finding{model.RiskCritical, fmt.Sprintf("deletes %s", target), append(deletes, model.CategoryWritesOutsideCwd)}, true
+		}
+	}
+	if inv.tool == "rm" && hasFlag(inv.args, "rR", "--recursive") {
+		return finding{model.RiskHigh, "recursively deletes files", deletes}, true
+	}
+	if inv.tool == "rm" {
+		return finding{model.RiskMedium, "deletes files", deletes}, true
+	}
+	return finding{}, false
+}
+
+func ruleDiskDestroyers(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	destroys := []model.RiskCategory{model.CategoryDeletesData, model.CategoryIrreversible}
+	switch {
+	case inv.tool == "dd":
+		for _, a := range inv.args {
+			if strings.HasPrefix(a, "of=/dev/") {
+				return finding{model.RiskCritical, fmt.Sprintf("writes raw data to device %s", strings.TrimPrefix(a, "of=")), destroys}, true
+			}
+		}
+		return finding{model.RiskHigh, "dd overwrites its output without confirmation", destroys}, true
+	case inv.tool == "mkfs" || strings.HasPrefix(inv.tool, "mkfs."):
+		return finding{model.RiskCritical, "formats a filesystem", destroys}, true
+	case inv.tool == "fdisk" || inv.tool == "parted" || inv.tool == "wipefs" || inv.tool == "sfdisk":
+		return finding{model.RiskCritical, "modifies disk partitions", destroys}, true
+	case inv.tool == "shred":
+		return finding{model.RiskHigh, "irreversibly destroys file contents", destroys}, true
+	}
+	return finding{}, false
+}
+
+func ruleChmod(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	if inv.tool != "chmod" && inv.tool != "chown" && inv.tool != "chgrp" {
+		return finding{}, false
+	}
+	recursive := hasFlag(inv.args, "R", "--recursive")
+	worldWritable := false
+	for _, a := range inv.args {
+		if a == "777" || a == "0777" || a == "a+rwx" || a == "o+w" || a == "a+w" {
+			worldWritable = true
+		}
+	}
+	for _, target := range operands(inv.args) {
+		if recursive && (isSweepingTarget(target) || isSystemPath(target)) {
+			return finding{model.RiskCritical, fmt.Sprintf("recursively changes permissions of %s", target), []model.RiskCategory{model.CategoryWritesOutsideCwd, model.CategoryIrreversible}}, true
+		}
+	}
+	switch {
+	case recursive && worldWritable:
+		return finding{model.RiskHigh, "recursively makes files world-writable", nil}, true
+	case worldWritable:
+		return finding{model.RiskMedium, "makes files world-writable", nil}, true
+	case recursive:
+		return finding{model.RiskMedium, fmt.Sprintf("recursively changes ownership or permissions (%s -R)", inv.tool), nil}, true
+	}
+	return finding{}, false
+}
+
+func ruleSystemWrites(steps []model.CommandStep, i int, inv invocation) (finding, bool) {
+	outside := []model.RiskCategory{model.CategoryWritesOutsideCwd}
+	for _, target := range writeTargets(steps, i) {
+		if isSystemPath(target.path) {
+			return finding{model.RiskHigh, fmt.Sprintf("redirects output into %s", target.path), outside}, true
+		}
+	}
+
+	ops := operands(inv.args)
+	switch inv.tool {
+	case "tee":
+		for _, target := range ops {
+			if isSystemPath(target) {
+				return finding{model.RiskHigh, fmt.Sprintf("writes to %s", target), outside}, true
+			}
+		}
+	case "cp", "mv", "install", "ln", "rsync":
+		if len(ops) > 1 && isSystemPath(ops[len(ops)-1]) {
+			return finding{model.RiskHigh, fmt.Sprintf("writes to %s", ops[len(ops)-1]), outside}, true
+		}
+	case "sed", "perl":
+		if hasFlag(inv.args, "i", "--in-place") {
+			for _, target := range ops {
+				if isSystemPath(target) {
+					return finding{model.RiskHigh, fmt.Sprintf("edits %s in place", target), outside}, true
+				}
+			}
+		}
+	}
+	return finding{}, false
+}
+
+// shells run the script piped into them, or given with -c
+var shells = map[string]bool{"sh": true, "bash": true, "zsh": true, "fish": true, "dash": true, "ksh": true, "pwsh": true, "powershell": true}
+
+var downloaders = map[string]bool{"curl": true, "wget": true, "fetch": true, "iwr": true, "Invoke-WebRequest": true}
+
+func rulePipeToShell(steps []model.CommandStep, i int, inv invocation) (finding, bool) {
+	if steps[i].Op != "|" || i+1 >= len(steps) {
+		return finding{}, false
+	}
+	next := unwrap(steps[i+1])
+	if !shells[next.tool] {
+		return finding{}, false
+	}
+	if downloaders[inv.tool] {
+		return finding{model.RiskCritical, fmt.Sprintf("pipes a download from %s straight into %s", inv.tool, next.tool), []model.RiskCategory{model.CategoryNetworkEgress}}, true
+	}
+	return finding{model.RiskHigh, fmt.Sprintf("pipes generated text into %s", next.tool), nil}, true
+}
+
+func ruleGit(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	if inv.tool != "git" || len(inv.args) == 0 {
+		return finding{}, false
+	}
+	sub := operands(inv.args)
+	if len(sub) == 0 {
+		return finding{}, false
+	}
+	switch sub[0] {
+	case "push":
+		if hasFlag(inv.args, "f", "--force", "--mirror", "--delete") || containsPrefix(inv.args, "--force-with-lease") || containsPrefix(sub[1:], "+") || containsPrefix(sub[1:], ":") {
+			return finding{model.RiskHigh, "rewrites or deletes remote history", []model.RiskCategory{model.CategoryNetworkEgress, model.CategoryIrreversible}}, true
+		}
+	case "reset":
+		if hasFlag(inv.args, "", "--hard") {
+			return finding{model.RiskMedium, "discards uncommitted changes", []model.RiskCategory{model.CategoryDeletesData, model.CategoryIrreversible}}, true
+		}
+	case "clean":
+		if hasFlag(inv.args, "f", "--force") {
+			return finding{model.RiskMedium, "deletes untracked files", []model.RiskCategory{model.CategoryDeletesData, model.CategoryIrreversible}}, true
+		}
+	}
+	return finding{}, false
+}
+
+func containsPrefix(args []string, prefix string) bool {
+	for _, a := range args {
+		if strings.HasPrefix(a, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleOverwrite(steps []model.CommandStep, i int, _ invocation) (finding, bool) {
+	for _, target := range writeTargets(steps, i) {
+		path := strings.Trim(target.path, `"'`)
+		if !target.truncate || path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return finding{model.RiskMedium, fmt.Sprintf("overwrites existing file %s", path), []model.RiskCategory{model.CategoryDeletesData}}, true
+		}
+	}
+	return finding{}, false
+}
+
+func ruleFindDelete(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	if inv.tool != "find" {
+		return finding{}, false
+	}
+	deletes := []model.RiskCategory{model.CategoryDeletesData, model.CategoryIrreversible}
+	for i, a := range inv.args {
+		if a == "-delete" {
+			return finding{model.RiskHigh, "deletes every file find matches", deletes}, true
+		}
+		if (a == "-exec" || a == "-execdir" || a == "-ok") && i+1 < len(inv.args) {
+			if next := model.BaseName(inv.args[i+1]); next == "rm" || next == "shred" {
+				return finding{model.RiskHigh, fmt.Sprintf("runs %s on every file find matches", next), deletes}, true
+			}
+		}
+	}
+	return finding{}, false
+}
+
+func rulePower(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	switch inv.tool {
+	case "shutdown", "reboot", "halt", "poweroff":
+		return finding{model.RiskHigh, "powers off or restarts the machine", []model.RiskCategory{model.CategoryIrreversible}}, true
+	case "kill", "pkill", "killall":
+		for _, a := range inv.args {
+			if a == "-1" {
+				return finding{model.RiskHigh, "signals every process", []model.RiskCategory{model.CategoryIrreversible}}, true
+			}
+		}
+	}
+	return finding{}, false
+}
+
+// networkTools talk to other machines. On their own they are low risk, but
+// the category is useful for the user to see.
+var networkTools = map[string]bool{
+	"curl": true, "wget": true, "scp": true, "sftp": true, "ssh": true, "rsync": true,
+	"nc": true, "ncat": true, "telnet": true, "ftp": true,
+}
+
+func ruleNetwork(_ []model.CommandStep, _ int, inv invocation) (finding, bool) {
+	if !networkTools[inv.tool] {
+		return finding{}, false
+	}
+	if inv.tool == "rsync" && !containsRemote(operands(inv.args)) {
+		return finding{}, false
+	}
+	return finding{model.RiskLow, fmt.Sprintf("%s talks to a remote host", inv.tool), []model.RiskCategory{model.CategoryNetworkEgress}}, true
+}
+
+func containsRemote(args []string) bool {
+	for _, a := range args {
+		if strings.Contains(a, ":") && !strings.HasPrefix(a, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleOutsideCwd(steps []model.CommandStep, i int, inv invocation) (finding, bool) {
+	for _, target := range writeTargets(steps, i) {
+		if isOutsideCwd(target.path) && !isSystemPath(target.path) {
+			return finding{model.RiskLow, fmt.Sprintf("writes to %s outside the current directory", target.path), []model.RiskCategory{model.CategoryWritesOutsideCwd}}, true
+		}
+	}
+
+	ops := operands(inv.args)
+	switch inv.tool {
+	case "cp", "mv", "install", "ln", "tee", "touch", "mkdir":
+		if len(ops) == 0 {
+			return finding{}, false
+		}
+		target := ops[len(ops)-1]
+		if isOutsideCwd(target) && !isSystemPath(target) {
+			return finding{model.RiskLow, fmt.Sprintf("writes to %s outside the current directory", target), []model.RiskCategory{model.CategoryWritesOutsideCwd}}, true
+		}
+	}
+	return finding{}, false
+}
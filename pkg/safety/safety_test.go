@@ -0,0 +1,111 @@
+package safety
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+func step(tool string, args ...string) model.CommandStep {
+	return model.CommandStep{Tool: tool, Args: args}
+}
+
+func TestAnalyze_Levels(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []model.CommandStep
+		want  model.RiskLevel
+	}{
+		{"list files", []model.CommandStep{step("ls", "-la")}, model.RiskNone},
+		{"plain rm", []model.CommandStep{step("rm", "notes.txt")}, model.RiskMedium},
+		{"recursive rm", []model.CommandStep{step("rm", "-rf", "build")}, model.RiskHigh},
+		{"rm of unset variable", []model.CommandStep{step("rm", "-rf", `"$DIR"/`)}, model.RiskCritical},
+		{"rm of root via sudo", []model.CommandStep{step("sudo", "rm", "-rf", "/")}, model.RiskCritical},
+		{"dd to device", []model.CommandStep{step("dd", "if=image.iso", "of=/dev/sda")}, model.RiskCritical},
+		{"mkfs", []model.CommandStep{step("mkfs.ext4", "/dev/sdb1")}, model.RiskCritical},
+		{"chmod 777 recursive", []model.CommandStep{step("chmod", "-R", "777", "www")}, model.RiskHigh},
+		{"tee into etc", []model.CommandStep{{Tool: "echo", Args: []string{"x"}, Op: "|"}, step("sudo", "tee", "/etc/hosts")}, model.RiskHigh},
+		{"redirect into etc", []model.CommandStep{{Tool: "echo", Args: []string{"x"}, Op: ">>"}, step("/etc/hosts")}, model.RiskHigh},
+		{"curl pipe sh", []model.CommandStep{{Tool: "curl", Args: []string{"-fsSL", "https://example.com/install.sh"}, Op: "|"}, step("sh")}, model.RiskCritical},
+		{"force push", []model.CommandStep{step("git", "push", "--force", "origin", "main")}, model.RiskHigh},
+		{"normal push", []model.CommandStep{step("git", "push", "origin", "main")}, model.RiskNone},
+		{"find delete", []model.CommandStep{step("find", ".", "-name", "*.tmp", "-delete")}, model.RiskHigh},
+		{"xargs rm", []model.CommandStep{{Tool: "find", Args: []string{".", "-name", "*.o"}, Op: "|"}, step("xargs", "rm")}, model.RiskMedium},
+		{"download", []model.CommandStep{step("curl", "-O", "https://example.com/file.tgz")}, model.RiskLow},
+		{"explicit redirect into etc", []model.CommandStep{{Tool: "echo", Args: []string{"x"}, Redirects: []model.Redirect{{Op: ">>", Target: "/etc/hosts"}}}}, model.RiskHigh},
+		{"stderr to null", []model.CommandStep{{Tool: "ls", Redirects: []model.Redirect{{FD: 2, Op: ">", Target: "/dev/null"}}}}, model.RiskNone},
+		{"loop inside bash -c", []model.CommandStep{step("bash", "-c", `for f in *; do rm -rf "$f"; done`)}, model.RiskHigh},
+		{"rm inside eval", []model.CommandStep{step("eval", "rm -rf /")}, model.RiskCritical},
+		{"harmless eval", []model.CommandStep{step("eval", "echo", "hi")}, model.RiskNone},
+		{"rm inside bash -c", []model.CommandStep{step("sudo", "bash", "-ec", "cd / && rm -rf /")}, model.RiskCritical},
+		{"chmod via find -exec", []model.CommandStep{step("find", "www", "-exec", "chmod", "-R", "777", "{}", "+")}, model.RiskHigh},
+		{"rm inside subshell", []model.CommandStep{{Subshell: []model.CommandStep{{Tool: "cd", Args: []string{"out"}, Op: "&&"}, step("rm", "-rf", "*")}}}, model.RiskCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			risk := Analyze(&model.CommandResult{Steps: tt.steps})
+			if risk.Level != tt.want {
+				t.Errorf("Expected level %s, got %s (%+v)", tt.want, risk.Level, risk.Steps)
+			}
+		})
+	}
+}
+
+func TestAnalyze_Categories(t *testing.T) {
+	risk := Analyze(&model.CommandResult{Steps: []model.CommandStep{step("sudo", "rm", "-rf", "/var/cache/app")}})
+
+	for _, c := range []model.RiskCategory{model.CategoryPrivilegeEscalation, model.CategoryDeletesData, model.CategoryIrreversible} {
+		if !risk.HasCategory(c) {
+			t.Errorf("Expected category %s in %v", c, risk.Categories)
+		}
+	}
+	if len(risk.StepReasons(0)) == 0 {
+		t.Error("Expected a reason for step 0")
+	}
+}
+
+func TestAnalyze_OverwriteExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(existing, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	overwrite := &model.CommandResult{Steps: []model.CommandStep{
+		{Tool: "echo", Args: []string{"hi"}, Op: ">"},
+		{Tool: existing},
+	}}
+	if risk := Analyze(overwrite); risk.Level != model.RiskMedium {
+		t.Errorf("Expected overwriting an existing file to be medium, got %s", risk.Level)
+	}
+
+	fresh := &model.CommandResult{Steps: []model.CommandStep{
+		{Tool: "echo", Args: []string{"hi"}, Op: ">"},
+		{Tool: filepath.Join(dir, "new.txt")},
+	}}
+	if risk := Analyze(fresh); risk.IsDangerous() {
+		t.Errorf("Expected writing a new file not to be dangerous, got %s", risk.Level)
+	}
+}
+
+func TestAssess(t *testing.T) {
+	safe := &model.CommandResult{Steps: []model.CommandStep{step("ls")}}
+	if Assess(safe).IsDangerous() {
+		t.Error("Expected ls not to require confirmation")
+	}
+
+	// The model says it is safe, the analyzer disagrees
+	lying := &model.CommandResult{Steps: []model.CommandStep{step("rm", "-rf", "/")}, Risk: model.Risk{Level: model.RiskNone}}
+	if !Assess(lying).IsDangerous() {
+		t.Error("Expected rm -rf / to require confirmation despite the model's assessment")
+	}
+
+	// The model says it is dangerous, the analyzer has no rule for it
+	flagged := &model.CommandResult{Steps: []model.CommandStep{step("docker", "system", "prune")}, Risk: model.Risk{Level: model.RiskHigh}}
+	if !Assess(flagged).IsDangerous() {
+		t.Error("Expected the model's assessment to be honoured")
+	}
+}
@@ -0,0 +1,242 @@
+package session
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/llm"
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// Kinds of turns in a session transcript
+const (
+	KindRequest = "request" // A request and the command generated for it
+	KindRun     = "run"     // The current command was executed
+	KindExplain = "explain" // The current command was explained
+	KindSave    = "save"    // The current command was saved to the brain
+)
+
+// Turn is one entry of a session transcript
+type Turn struct {
+	Timestamp   time.Time       `json:"timestamp"`
+	Kind        string          `json:"kind"`
+	Query       string          `json:"query,omitempty"`
+	Command     string          `json:"command,omitempty"`
+	Explanation string          `json:"explanation,omitempty"`
+	Risk        model.RiskLevel `json:"risk,omitempty"`
+	ExitCode    *int            `json:"exit_code,omitempty"` // Set for runs
+	Output      string          `json:"output,omitempty"`    // Error output of a failed run
+	Error       string          `json:"error,omitempty"`     // Why the turn produced nothing
+}
+
+// Session is a conversation whose transcript is appended to a JSONL file as
+// it goes, so it can be resumed later
+type Session struct {
+	ID    string
+	Turns []Turn
+	path  string
+}
+
+// Info summarizes a stored session
+type Info struct {
+	ID      string
+	Updated time.Time
+	Turns   int
+	First   string // The first request, as a title
+}
+
+// Store keeps one transcript file per session in a directory
+type Store struct {
+	dir string
+}
+
+// NewStore opens the sessions in ~/.cmdfy/sessions
+func NewStore() (*Store, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %w", err)
+	}
+	return NewStoreAt(filepath.Join(home, ".cmdfy", "sessions"))
+}
+
+// NewStoreAt opens the sessions stored in dir
+func NewStoreAt(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create sessions dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Create starts a new, empty session. Its file is written with the first turn.
+func (s *Store) Create() (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, path: filepath.Join(s.dir, id+".jsonl")}, nil
+}
+
+// Open reads the transcript of a session
+func (s *Store) Open(id string) (*Session, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid session id: %q", id)
+	}
+	path := filepath.Join(s.dir, id+".jsonl")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session %s: %w", id, err)
+	}
+	defer f.Close()
+
+	sess := &Session{ID: id, path: path}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var t Turn
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+			// A line cut short by a crash shouldn't lose the rest
+			continue
+		}
+		sess.Turns = append(sess.Turns, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+	}
+	return sess, nil
+}
+
+// List returns every stored session, most recently updated first
+func (s *Store) List() ([]Info, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions dir: %w", err)
+	}
+
+	var infos []Info
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".jsonl")
+		if f.IsDir() || !ok {
+			continue
+		}
+		sess, err := s.Open(id)
+		if err != nil || len(sess.Turns) == 0 {
+			continue
+		}
+		info := Info{ID: id, Updated: sess.Turns[len(sess.Turns)-1].Timestamp, Turns: len(sess.Turns)}
+		for _, t := range sess.Turns {
+			if t.Kind == KindRequest {
+				info.First = t.Query
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Updated.After(infos[b].Updated) })
+	return infos, nil
+}
+
+// Latest opens the most recently updated session
+func (s *Store) Latest() (*Session, error) {
+	infos, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, errors.New("no sessions to resume")
+	}
+	return s.Open(infos[0].ID)
+}
+
+// Append adds a turn to the session and writes it to the transcript
+func (s *Session) Append(t Turn) error {
+	if t.Timestamp.IsZero() {
+		t.Timestamp = time.Now()
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal turn: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open session file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+
+	s.Turns = append(s.Turns, t)
+	return nil
+}
+
+// Current returns the latest request that produced a command, which is what
+// :run, :explain and :save act on
+func (s *Session) Current() (Turn, bool) {
+	for i := len(s.Turns) - 1; i >= 0; i-- {
+		if t := s.Turns[i]; t.Kind == KindRequest && t.Command != "" {
+			return t, true
+		}
+	}
+	return Turn{}, false
+}
+
+// Conversation returns up to limit of the latest requests with their
+// commands and how running them went, oldest first, for the provider to
+// refine
+func (s *Session) Conversation(limit int) []llm.ConversationTurn {
+	var turns []llm.ConversationTurn
+	for _, t := range s.Turns {
+		switch {
+		case t.Kind == KindRequest && t.Command != "":
+			turns = append(turns, llm.ConversationTurn{Query: t.Query, Command: t.Command})
+		case t.Kind == KindRun && t.ExitCode != nil && len(turns) > 0:
+			last := &turns[len(turns)-1]
+			if *t.ExitCode == 0 {
+				last.Outcome = "ran successfully"
+			} else {
+				last.Outcome = fmt.Sprintf("failed with exit status %d", *t.ExitCode)
+				if t.Output != "" {
+					last.Outcome += ": " + t.Output
+				}
+			}
+		}
+	}
+	if len(turns) > limit {
+		turns = turns[len(turns)-limit:]
+	}
+	return turns
+}
+
+// Lines returns what the user typed, oldest first, for history navigation
+func (s *Session) Lines() []string {
+	var lines []string
+	for _, t := range s.Turns {
+		switch t.Kind {
+		case KindRequest:
+			lines = append(lines, t.Query)
+		default:
+			lines = append(lines, ":"+t.Kind)
+		}
+	}
+	return lines
+}
+
+// newID returns a sortable unique id, e.g. 20240102-150405-a1b2c3
+func newID() (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
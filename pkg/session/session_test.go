@@ -0,0 +1,137 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exitCode(n int) *int {
+	return &n
+}
+
+func TestAppendAndOpen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreAt(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := store.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	turns := []Turn{
+		{Kind: KindRequest, Query: "list large files", Command: "find . -size +100M"},
+		{Kind: KindRun, Command: "find . -size +100M", ExitCode: exitCode(0)},
+		{Kind: KindRequest, Query: "now only .log files", Command: "find . -size +100M -name '*.log'"},
+	}
+	for _, turn := range turns {
+		if err := sess.Append(turn); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	resumed, err := store.Open(sess.ID)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(resumed.Turns) != 3 || resumed.Turns[2].Query != "now only .log files" {
+		t.Fatalf("Expected the transcript back, got %+v", resumed.Turns)
+	}
+	if resumed.Turns[0].Timestamp.IsZero() {
+		t.Error("Expected Append to set the timestamp")
+	}
+
+	current, ok := resumed.Current()
+	if !ok || current.Command != "find . -size +100M -name '*.log'" {
+		t.Errorf("Expected the latest command to be current, got %+v", current)
+	}
+
+	// Resuming continues the same file
+	if err := resumed.Append(Turn{Kind: KindSave, Command: current.Command}); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := store.Open(sess.ID)
+	if len(again.Turns) != 4 {
+		t.Errorf("Expected 4 turns after resuming, got %d", len(again.Turns))
+	}
+}
+
+func TestOpen_SkipsBrokenLines(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewStoreAt(dir)
+	data := `{"kind":"request","query":"a","command":"ls"}` + "\n" + `{"kind":"req` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "s1.jsonl"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := store.Open("s1")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(sess.Turns) != 1 {
+		t.Errorf("Expected the broken line to be skipped, got %+v", sess.Turns)
+	}
+
+	if _, err := store.Open("../s1"); err == nil {
+		t.Error("Expected an error for an id with a path in it")
+	}
+}
+
+func TestListAndLatest(t *testing.T) {
+	store, _ := NewStoreAt(t.TempDir())
+	if _, err := store.Latest(); err == nil {
+		t.Error("Expected an error without sessions")
+	}
+
+	older, _ := store.Create()
+	older.Append(Turn{Timestamp: time.Now().Add(-time.Hour), Kind: KindRequest, Query: "first", Command: "ls"})
+	newer, _ := store.Create()
+	newer.Append(Turn{Kind: KindRequest, Query: "second", Command: "pwd"})
+	empty, _ := store.Create() // Never written, so not listed
+	_ = empty
+
+	infos, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].First != "second" || infos[1].First != "first" {
+		t.Errorf("Expected the sessions newest first, got %+v", infos)
+	}
+
+	latest, err := store.Latest()
+	if err != nil || latest.ID != newer.ID {
+		t.Errorf("Expected the newest session, got %+v, %v", latest, err)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	sess := &Session{Turns: []Turn{
+		{Kind: KindRequest, Query: "one", Command: "cmd1"},
+		{Kind: KindRequest, Query: "two", Command: "cmd2"},
+		{Kind: KindRun, Command: "cmd2", ExitCode: exitCode(2), Output: "no such file"},
+		{Kind: KindExplain, Command: "cmd2"},
+		{Kind: KindRequest, Query: "three", Error: "provider down"},
+		{Kind: KindRequest, Query: "four", Command: "cmd4"},
+		{Kind: KindRun, Command: "cmd4", ExitCode: exitCode(0)},
+	}}
+
+	turns := sess.Conversation(2)
+	if len(turns) != 2 {
+		t.Fatalf("Expected the limit to apply, got %+v", turns)
+	}
+	if turns[0].Query != "two" || !strings.Contains(turns[0].Outcome, "exit status 2: no such file") {
+		t.Errorf("Unexpected first turn: %+v", turns[0])
+	}
+	if turns[1].Query != "four" || turns[1].Outcome != "ran successfully" {
+		t.Errorf("Unexpected second turn: %+v", turns[1])
+	}
+
+	lines := sess.Lines()
+	if len(lines) != 7 || lines[0] != "one" || lines[2] != ":run" {
+		t.Errorf("Unexpected lines: %v", lines)
+	}
+}
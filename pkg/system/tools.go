@@ -0,0 +1,171 @@
+package system
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+// The commands each shell runs itself, separated by spaces
+const (
+	posixBuiltins = ". : [ alias bg break cd command continue echo eval exec exit export false fg getopts " +
+		"hash jobs kill printf pwd read readonly return set shift test times trap true type ulimit umask " +
+		"unalias unset wait"
+	bashBuiltins = "[[ bind builtin caller declare dirs disown enable help history let local logout " +
+		"mapfile popd pushd readarray shopt source suspend time typeset"
+	zshBuiltins = "[[ autoload bindkey builtin declare dirs disown emulate functions history integer let " +
+		"local noglob popd print pushd rehash setopt source suspend time typeset unsetopt whence where " +
+		"which zmodload"
+	fishBuiltins = "abbr and argparse begin bg bind block break builtin case cd command commandline " +
+		"complete contains continue count echo else emit end eval exec exit false fg for function " +
+		"functions history if jobs math not or path printf pwd random read realpath return set " +
+		"set_color source status string switch test time true type ulimit umask wait while"
+	// PowerShell's built-in aliases; its cmdlets are recognised by their Verb-Noun names
+	powerShellBuiltins = "% ? cd chdir clc clear cls copy cp cpi del diff dir echo erase foreach gc " +
+		"gci gcm gi gl gm gps gv h history kill ls md measure mi mkdir move mv popd ps pushd pwd r rd " +
+		"ren ri rm rmdir select set sl sleep sort start tee type where write"
+	cmdBuiltins = "assoc break call cd chdir cls color copy date del dir echo endlocal erase exit for " +
+		"ftype goto if md mkdir mklink move path pause popd prompt pushd rd ren rename rmdir set " +
+		"setlocal shift start time title type ver verify vol"
+)
+
+// builtins are the commands each shell runs itself, which are never found on
+// PATH. Unknown shells use the POSIX set.
+var builtins = map[model.Shell]map[string]bool{
+	model.ShellPOSIX:      wordSet(posixBuiltins),
+	model.ShellBash:       wordSet(posixBuiltins, bashBuiltins),
+	model.ShellZsh:        wordSet(posixBuiltins, zshBuiltins),
+	model.ShellFish:       wordSet(fishBuiltins),
+	model.ShellPowerShell: wordSet(powerShellBuiltins),
+	model.ShellCmd:        wordSet(cmdBuiltins),
+}
+
+func wordSet(lists ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, list := range lists {
+		for _, w := range strings.Fields(list) {
+			set[w] = true
+		}
+	}
+	return set
+}
+
+// isBuiltin reports whether shell runs tool itself
+func isBuiltin(shell model.Shell, tool string) bool {
+	set, ok := builtins[shell]
+	if !ok {
+		set = builtins[model.ShellPOSIX]
+	}
+	if shell == model.ShellPowerShell {
+		// Cmdlets such as Get-ChildItem come from modules, not PATH
+		return set[strings.ToLower(tool)] || isCmdlet(tool)
+	}
+	if shell == model.ShellCmd {
+		return set[strings.ToLower(tool)]
+	}
+	return set[tool]
+}
+
+// isCmdlet reports whether name has PowerShell's Verb-Noun form
+func isCmdlet(name string) bool {
+	verb, noun, ok := strings.Cut(name, "-")
+	return ok && isWord(verb) && isWord(noun)
+}
+
+func isWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Alternatives maps tools models often assume are installed to a standard
+// tool that does the same job
+var Alternatives = map[string]string{
+	"fd":     "find",
+	"fdfind": "find",
+	"rg":     "grep -r",
+	"ag":     "grep -r",
+	"ack":    "grep -r",
+	"jq":     "python3 -m json.tool",
+	"bat":    "cat",
+	"exa":    "ls",
+	"eza":    "ls",
+	"htop":   "top",
+	"btop":   "top",
+	"http":   "curl",
+	"wget":   "curl -O",
+	"curl":   "wget",
+	"delta":  "diff",
+	"tldr":   "man",
+	"gsed":   "sed",
+	"gawk":   "awk",
+	"pigz":   "gzip",
+	"rsync":  "cp -r",
+	"nproc":  "getconf _NPROCESSORS_ONLN",
+}
+
+// MissingTools returns the tools the command runs that are neither builtins
+// of shell nor found in available, sorted. When available is empty nothing
+// can be checked and nil is returned.
+func MissingTools(result *model.CommandResult, available []string, shell model.Shell) []string {
+	if result == nil || len(available) == 0 {
+		return nil
+	}
+	installed := make(map[string]bool, len(available))
+	for _, c := range available {
+		installed[c] = true
+	}
+
+	missing := make(map[string]bool)
+	collectMissing(result.Steps, shell, installed, missing)
+
+	out := make([]string, 0, len(missing))
+	for tool := range missing {
+		out = append(out, tool)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func collectMissing(steps []model.CommandStep, shell model.Shell, installed, missing map[string]bool) {
+	for i, step := range steps {
+		if i > 0 && model.IsRedirect(steps[i-1].Op) {
+			continue // A filename, not a tool
+		}
+		if len(step.Subshell) > 0 {
+			collectMissing(step.Subshell, shell, installed, missing)
+			continue
+		}
+		if strings.ContainsAny(step.Tool, "$`") {
+			continue // Only known at run time
+		}
+		chain := model.ToolChain(step)
+		if strings.ContainsAny(step.Tool, `/\`) {
+			// A path is checked as given rather than looked up on PATH
+			if !isExecutable(step.Tool) {
+				missing[step.Tool] = true
+			}
+			chain = chain[1:]
+		}
+		for _, tool := range chain {
+			if !isBuiltin(shell, tool) && !installed[tool] && !strings.ContainsAny(tool, "$`") {
+				missing[tool] = true
+			}
+		}
+	}
+}
+
+// isExecutable checks a tool given as a path, e.g. ./build.sh
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
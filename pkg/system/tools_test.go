@@ -0,0 +1,56 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kesavan-vaisakh/cmdfy/pkg/model"
+)
+
+func TestMissingTools(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "build.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	available := []string{"find", "grep", "sort", "sudo", "xargs"}
+
+	tests := []struct {
+		name  string
+		steps []model.CommandStep
+		shell model.Shell
+		want  []string
+	}{
+		{"all installed", []model.CommandStep{{Tool: "find", Op: "|"}, {Tool: "sort"}}, model.ShellBash, []string{}},
+		{"hallucinated", []model.CommandStep{{Tool: "fd", Args: []string{"-e", "go"}, Op: "|"}, {Tool: "rg", Args: []string{"TODO"}}}, model.ShellBash, []string{"fd", "rg"}},
+		{"builtins", []model.CommandStep{{Tool: "cd", Args: []string{"src"}, Op: "&&"}, {Tool: "echo", Args: []string{"hi"}}}, model.ShellBash, []string{}},
+		{"behind a wrapper", []model.CommandStep{{Tool: "sudo", Args: []string{"jq", "."}}}, model.ShellBash, []string{"jq"}},
+		{"inside xargs", []model.CommandStep{{Tool: "find", Op: "|"}, {Tool: "xargs", Args: []string{"bat"}}}, model.ShellBash, []string{"bat"}},
+		{"legacy redirect target", []model.CommandStep{{Tool: "grep", Args: []string{"x"}, Op: ">"}, {Tool: "out.txt"}}, model.ShellBash, []string{}},
+		{"subshell", []model.CommandStep{{Subshell: []model.CommandStep{{Tool: "exa"}}}}, model.ShellBash, []string{"exa"}},
+		{"script path", []model.CommandStep{{Tool: script, Op: "&&"}, {Tool: "./missing.sh"}}, model.ShellBash, []string{"./missing.sh"}},
+		{"variable", []model.CommandStep{{Tool: "$EDITOR", Args: []string{"notes.txt"}}}, model.ShellBash, []string{}},
+		{"bash builtin in sh", []model.CommandStep{{Tool: "source", Args: []string{"env.sh"}}}, model.ShellPOSIX, []string{"source"}},
+		{"fish builtins", []model.CommandStep{{Tool: "set", Args: []string{"-x", "A", "1"}, Op: ";"}, {Tool: "string", Args: []string{"upper", "a"}}}, model.ShellFish, []string{}},
+		{"bash builtin in fish", []model.CommandStep{{Tool: "declare", Args: []string{"A=1"}}}, model.ShellFish, []string{"declare"}},
+		{"zsh builtins", []model.CommandStep{{Tool: "setopt", Args: []string{"extendedglob"}, Op: "&&"}, {Tool: "print", Args: []string{"-l", "x"}}}, model.ShellZsh, []string{}},
+		{"cmdlets", []model.CommandStep{{Tool: "Get-ChildItem", Op: "|"}, {Tool: "Sort-Object", Op: "|"}, {Tool: "rg"}}, model.ShellPowerShell, []string{"rg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MissingTools(&model.CommandResult{Steps: tt.steps}, available, tt.shell)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMissingTools_NothingToCheck(t *testing.T) {
+	result := &model.CommandResult{Steps: []model.CommandStep{{Tool: "fd"}}}
+	if got := MissingTools(result, nil, model.ShellBash); got != nil {
+		t.Errorf("Expected nil without a list of available commands, got %v", got)
+	}
+}
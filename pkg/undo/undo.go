@@ -0,0 +1,494 @@
+package undo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSnapshotBytes bounds how much file content a single snapshot may copy.
+// Paths that would go over it are skipped and reported.
+const MaxSnapshotBytes = 512 << 20
+
+// Entry is the journal record of one execution
+type Entry struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Command   string    `json:"command"`
+	Dir       string    `json:"dir"`
+	Files     []File    `json:"files"`
+	Skipped   []string  `json:"skipped,omitempty"` // Too large to snapshot
+	Created   []string  `json:"created,omitempty"` // New paths found right after the run
+	Sealed    bool      `json:"sealed,omitempty"`  // Created was recorded
+	Undone    bool      `json:"undone,omitempty"`
+}
+
+// File is the state of one path before the command ran
+type File struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	IsDir   bool        `json:"is_dir,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	Hash    string      `json:"hash,omitempty"` // sha256 of the content, also the blob name
+	Link    string      `json:"link,omitempty"` // Target of a symlink
+}
+
+// Summary lists what the command did to the snapshotted paths, which is
+// what Restore reverted.
+type Summary struct {
+	Created  []string
+	Modified []string
+	Deleted  []string
+}
+
+// Journal stores snapshots under one directory, one subdirectory per entry
+type Journal struct {
+	dir string
+}
+
+// NewJournal opens the journal in ~/.cmdfy/undo
+func NewJournal() (*Journal, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %w", err)
+	}
+	return NewJournalAt(filepath.Join(home, ".cmdfy", "undo"))
+}
+
+// NewJournalAt opens a journal stored in dir
+func NewJournalAt(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create undo dir: %w", err)
+	}
+	return &Journal{dir: dir}, nil
+}
+
+// Snapshot copies the given absolute paths, recursively for directories, and
+// records a journal entry for the command about to run in dir.
+func (j *Journal) Snapshot(command, dir string, paths []string) (*Entry, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{ID: id, Timestamp: time.Now(), Command: command, Dir: dir}
+
+	blobs := filepath.Join(j.dir, id, "blobs")
+	if err := os.MkdirAll(blobs, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot dir: %w", err)
+	}
+
+	budget := int64(MaxSnapshotBytes)
+	for _, p := range paths {
+		size, ok := treeSize(p, budget)
+		if !ok {
+			entry.Skipped = append(entry.Skipped, p)
+			continue
+		}
+		budget -= size
+
+		files, err := snapshotTree(p, blobs)
+		if err != nil {
+			return nil, err
+		}
+		entry.Files = append(entry.Files, files...)
+	}
+
+	if err := j.save(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Seal records the paths that appeared under the snapshotted paths while the
+// command ran. It must be called right after the command finishes: Restore
+// only removes what Seal saw, so files written later are left alone.
+func (j *Journal) Seal(id string) error {
+	entry, err := j.Load(id)
+	if err != nil {
+		return err
+	}
+
+	recorded := make(map[string]bool, len(entry.Files))
+	dirs := make(map[string]bool)
+	for _, f := range entry.Files {
+		recorded[f.Path] = f.Existed
+		if f.IsDir {
+			dirs[f.Path] = true
+		}
+	}
+
+	entry.Created = nil
+	for _, f := range entry.Files {
+		// Only walk the snapshot roots, their subdirectories are covered
+		if (f.Existed && !f.IsDir) || dirs[filepath.Dir(f.Path)] {
+			continue
+		}
+		err := filepath.WalkDir(f.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !recorded[path] {
+				recorded[path] = true
+				entry.Created = append(entry.Created, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", f.Path, err)
+		}
+	}
+
+	entry.Sealed = true
+	return j.save(entry)
+}
+
+// List returns every entry, newest first
+func (j *Journal) List() ([]Entry, error) {
+	dirs, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read undo dir: %w", err)
+	}
+
+	var entries []Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		entry, err := j.Load(d.Name())
+		if err != nil {
+			// Skip broken entries, don't crash
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(a, b int) bool { return entries[a].ID > entries[b].ID })
+	return entries, nil
+}
+
+// Latest returns the newest entry that has files and was not undone yet
+func (j *Journal) Latest() (*Entry, error) {
+	entries, err := j.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.Undone && len(e.Files) > 0 {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("nothing to undo")
+}
+
+// Load reads a single entry
+func (j *Journal) Load(id string) (*Entry, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid undo id: %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(j.dir, id, "journal.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read undo entry %s: %w", id, err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse undo entry %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// Restore puts every snapshotted path back the way it was before the command
+// ran, removes what Seal saw it create and marks the entry as undone.
+func (j *Journal) Restore(id string) (*Summary, error) {
+	entry, err := j.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Undone {
+		return nil, fmt.Errorf("entry %s was already undone", id)
+	}
+
+	summary := &Summary{}
+	created, err := removeCreated(entry)
+	if err != nil {
+		return nil, err
+	}
+	summary.Created = created
+
+	blobs := filepath.Join(j.dir, id, "blobs")
+	for _, f := range entry.Files {
+		if !f.Existed {
+			continue
+		}
+		state, err := restoreFile(f, blobs)
+		if err != nil {
+			return nil, err
+		}
+		switch state {
+		case "modified":
+			summary.Modified = append(summary.Modified, f.Path)
+		case "deleted":
+			summary.Deleted = append(summary.Deleted, f.Path)
+		}
+	}
+
+	entry.Undone = true
+	if err := j.save(entry); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (j *Journal) save(entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal undo entry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(j.dir, entry.ID, "journal.json"), data, 0600); err != nil {
+		return fmt.Errorf("failed to write undo entry: %w", err)
+	}
+	return nil
+}
+
+// newID returns a sortable unique id, e.g. 20240102-150405-a1b2c3
+func newID() (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate undo id: %w", err)
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// treeSize adds up the regular files under p, giving up once it goes over
+// limit. A missing path has size zero.
+func treeSize(p string, limit int64) (int64, bool) {
+	var total int64
+	err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+			if total > limit {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	return total, err == nil && total <= limit
+}
+
+// snapshotTree records p and everything below it, copying file contents
+// into blobs. Symlinks are recorded, not followed.
+func snapshotTree(p, blobs string) ([]File, error) {
+	if _, err := os.Lstat(p); os.IsNotExist(err) {
+		return []File{{Path: p}}, nil
+	}
+
+	var files []File
+	err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f := File{Path: path, Existed: true, Mode: info.Mode()}
+		switch {
+		case d.IsDir():
+			f.IsDir = true
+		case d.Type()&fs.ModeSymlink != 0:
+			if f.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if f.Hash, err = storeBlob(path, blobs); err != nil {
+				return err
+			}
+		default:
+			// Devices, sockets and pipes can't be restored
+			return nil
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", p, err)
+	}
+	return files, nil
+}
+
+func storeBlob(path, blobs string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(blobs, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), src); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(blobs, hash)); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removeCreated deletes the paths the command created and returns them,
+// leaving out those inside a removed directory. Without a seal only the
+// predicted paths that did not exist are candidates. Directories are only
+// removed when empty, so anything written into them since stays.
+func removeCreated(entry *Entry) ([]string, error) {
+	candidates := entry.Created
+	if !entry.Sealed {
+		candidates = nil
+		for _, f := range entry.Files {
+			if !f.Existed {
+				candidates = append(candidates, f.Path)
+			}
+		}
+	}
+
+	// Children come after their parent, so go backwards
+	removed := make(map[string]bool)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		p := candidates[i]
+		info, err := os.Lstat(p)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			if info.IsDir() {
+				continue // Not empty
+			}
+			return nil, fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		removed[p] = true
+	}
+
+	var created []string
+	for _, p := range candidates {
+		if removed[p] && !removed[filepath.Dir(p)] {
+			created = append(created, p)
+		}
+	}
+	return created, nil
+}
+
+// restoreFile brings one path back and reports whether it had been
+// "modified" or "deleted", or "" when it was unchanged.
+func restoreFile(f File, blobs string) (string, error) {
+	state := ""
+	current, err := os.Lstat(f.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		state = "deleted"
+	case err != nil:
+		return "", fmt.Errorf("failed to inspect %s: %w", f.Path, err)
+	case current.Mode().Type() != f.Mode.Type():
+		// A file replaced by a directory or the other way round
+		if err := os.RemoveAll(f.Path); err != nil {
+			return "", fmt.Errorf("failed to remove %s: %w", f.Path, err)
+		}
+		state = "modified"
+	}
+
+	if state == "deleted" {
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+			return "", fmt.Errorf("failed to restore %s: %w", filepath.Dir(f.Path), err)
+		}
+	}
+
+	switch {
+	case f.IsDir:
+		if state != "" {
+			if err := os.MkdirAll(f.Path, f.Mode.Perm()); err != nil {
+				return "", fmt.Errorf("failed to restore %s: %w", f.Path, err)
+			}
+		}
+	case f.Link != "":
+		if state == "" {
+			if target, _ := os.Readlink(f.Path); target == f.Link {
+				return "", nil
+			}
+			state = "modified"
+			if err := os.Remove(f.Path); err != nil {
+				return "", fmt.Errorf("failed to remove %s: %w", f.Path, err)
+			}
+		}
+		if err := os.Symlink(f.Link, f.Path); err != nil {
+			return "", fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+		return state, nil
+	default:
+		if state == "" {
+			if hash, err := hashFile(f.Path); err == nil && hash == f.Hash {
+				break
+			}
+			state = "modified"
+		}
+		data, err := os.ReadFile(filepath.Join(blobs, f.Hash))
+		if err != nil {
+			return "", fmt.Errorf("failed to read snapshot of %s: %w", f.Path, err)
+		}
+		if err := os.WriteFile(f.Path, data, f.Mode.Perm()); err != nil {
+			return "", fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+	}
+
+	// chmod counts as a modification too
+	if current != nil && state == "" && current.Mode().Perm() != f.Mode.Perm() {
+		state = "modified"
+	}
+	if err := os.Chmod(f.Path, f.Mode.Perm()); err != nil {
+		return "", fmt.Errorf("failed to restore mode of %s: %w", f.Path, err)
+	}
+	return state, nil
+}
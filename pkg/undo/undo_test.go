@@ -0,0 +1,226 @@
+package undo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected %s to exist: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	j, err := NewJournalAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := t.TempDir()
+	edited := filepath.Join(work, "config.ini")
+	removed := filepath.Join(work, "src", "main.go")
+	created := filepath.Join(work, "out.txt")
+	stray := filepath.Join(work, "src", "new.go")
+	writeFile(t, edited, "debug=false\n")
+	writeFile(t, removed, "package main\n")
+
+	entry, err := j.Snapshot("sed -i ... && rm -rf src", work, []string{edited, filepath.Join(work, "src"), created})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// What a bad command might do
+	writeFile(t, edited, "debug=true\n")
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, created, "hello")
+	writeFile(t, stray, "package main\n")
+	if err := j.Seal(entry.ID); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// Written by the user after the command finished
+	later := filepath.Join(work, "src", "later.go")
+	writeFile(t, later, "package main\n")
+
+	summary, err := j.Restore(entry.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if got := readFile(t, edited); got != "debug=false\n" {
+		t.Errorf("Expected the edit to be reverted, got %q", got)
+	}
+	if got := readFile(t, removed); got != "package main\n" {
+		t.Errorf("Expected the deleted file to be back, got %q", got)
+	}
+	for _, p := range []string{created, stray} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", p)
+		}
+	}
+
+	if got := readFile(t, later); got != "package main\n" {
+		t.Errorf("Expected a file written after the run to stay, got %q", got)
+	}
+
+	want := &Summary{
+		Created:  []string{stray, created},
+		Modified: []string{edited},
+		Deleted:  []string{removed},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("Expected summary %+v, got %+v", want, summary)
+	}
+
+	if _, err := j.Restore(entry.ID); err == nil {
+		t.Error("Expected restoring twice to fail")
+	}
+}
+
+func TestRestore_CreatedDirectory(t *testing.T) {
+	j, err := NewJournalAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := t.TempDir()
+	out := filepath.Join(work, "out")
+	entry, err := j.Snapshot("mkdir out && touch out/a", work, []string{work})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(out, "a"), "a")
+	if err := j.Seal(entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(out, "b"), "b")
+	writeFile(t, filepath.Join(work, "notes.txt"), "mine")
+
+	summary, err := j.Restore(entry.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "a")); !os.IsNotExist(err) {
+		t.Error("Expected the created file to be removed")
+	}
+	for _, p := range []string{filepath.Join(out, "b"), filepath.Join(work, "notes.txt")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("Expected %s, written after the run, to stay", p)
+		}
+	}
+	if want := []string{filepath.Join(out, "a")}; !reflect.DeepEqual(summary.Created, want) {
+		t.Errorf("Expected created %v, got %v", want, summary.Created)
+	}
+}
+
+func TestRestore_Unsealed(t *testing.T) {
+	j, err := NewJournalAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := t.TempDir()
+	predicted := filepath.Join(work, "out.txt")
+	entry, err := j.Snapshot("echo hi > out.txt", work, []string{work, predicted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, predicted, "hi")
+	other := filepath.Join(work, "other.txt")
+	writeFile(t, other, "x")
+
+	if _, err := j.Restore(entry.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(predicted); !os.IsNotExist(err) {
+		t.Error("Expected the predicted path to be removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("Expected an unpredicted path to stay without a seal")
+	}
+}
+
+func TestRestore_DeletedDirectory(t *testing.T) {
+	j, err := NewJournalAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := t.TempDir()
+	dir := filepath.Join(work, "build")
+	writeFile(t, filepath.Join(dir, "a", "b.txt"), "b")
+	if err := os.Symlink("a/b.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := j.Snapshot("rm -rf build", work, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.Restore(entry.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "link")); got != "b" {
+		t.Errorf("Expected the tree and symlink to be restored, got %q", got)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	j, err := NewJournalAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Latest(); err == nil {
+		t.Error("Expected an empty journal to have nothing to undo")
+	}
+
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	writeFile(t, file, "x")
+
+	first, err := j.Snapshot("rm notes.txt", "/", []string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Snapshot("ls", "/", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := j.Latest()
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	if latest.ID != first.ID {
+		t.Errorf("Expected the entry with files, got %s", latest.Command)
+	}
+
+	entries, err := j.List()
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %d (%v)", len(entries), err)
+	}
+
+	if _, err := j.Load("../escape"); err == nil {
+		t.Error("Expected an invalid id to be rejected")
+	}
+}